@@ -0,0 +1,61 @@
+package libnetwork
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/pkg/options"
+)
+
+// checkNetworkCapabilities verifies that the driver registered for
+// networkType supports the features requested by the network options.
+func (c *controller) checkNetworkCapabilities(networkType string, capability driverapi.Capability, options interface{}) error {
+	if v, ok := optionValue(options, "EnableIPv6").(bool); ok && v && !capability.IPv6 {
+		return fmt.Errorf("driver %q does not support IPv6", networkType)
+	}
+
+	if !capability.MultipleNetworks {
+		c.Lock()
+		defer c.Unlock()
+		for _, n := range c.networks {
+			if n.networkType == networkType {
+				return fmt.Errorf("driver %q does not support multiple networks", networkType)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkEndpointCapabilities verifies that the driver registered for
+// networkType supports the features requested by the endpoint options.
+func checkEndpointCapabilities(networkType string, capability driverapi.Capability, options interface{}) error {
+	if v := reflect.ValueOf(optionValue(options, "PortBindings")); v.Kind() == reflect.Slice && v.Len() > 0 && !capability.PortMapping {
+		return fmt.Errorf("driver %q does not support port mapping", networkType)
+	}
+
+	return nil
+}
+
+// optionValue returns the value of the named option from either a generic
+// options map or a pointer to a driver specific configuration structure. It
+// returns nil when the option is not set.
+func optionValue(opts interface{}, name string) interface{} {
+	if generic, ok := opts.(options.Generic); ok {
+		return generic[name]
+	}
+
+	v := reflect.ValueOf(opts)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	if f := v.FieldByName(name); f.IsValid() && f.CanInterface() {
+		return f.Interface()
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/docker/libnetwork"
 	"github.com/docker/libnetwork/pkg/options"
+	"golang.org/x/net/context"
 )
 
 func main() {
@@ -15,7 +16,7 @@ func main() {
 
 	options := options.Generic{"AddressIPv4": net}
 	controller := libnetwork.New()
-	netw, err := controller.NewNetwork("simplebridge", "dummy", options)
+	netw, err := controller.NewNetwork(context.Background(), "simplebridge", "dummy", options)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,26 @@
+// Package datastore provides the key-value storage used to persist the
+// networks and endpoints managed by libnetwork across restarts.
+package datastore
+
+import "errors"
+
+// ErrKeyNotFound is returned when the requested key is not in the store.
+var ErrKeyNotFound = errors.New("key not found in store")
+
+// DataStore is a key-value store. Keys are slash separated paths, such as
+// "network/<id>".
+type DataStore interface {
+	// Put stores value under key, replacing any previous value.
+	Put(key string, value []byte) error
+
+	// Get returns the value stored under key, or ErrKeyNotFound.
+	Get(key string) ([]byte, error)
+
+	// Delete removes key from the store. Deleting a missing key is not an
+	// error.
+	Delete(key string) error
+
+	// List returns all the keys starting with prefix, along with their
+	// values.
+	List(prefix string) (map[string][]byte, error)
+}
@@ -0,0 +1,118 @@
+package datastore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type fileStore struct {
+	root string
+	sync.Mutex
+}
+
+// NewFileStore returns a DataStore keeping each key in its own file under
+// the root directory, which gets created if needed.
+func NewFileStore(root string) (DataStore, error) {
+	// The keys are checked against the clean form of the root.
+	root = filepath.Clean(root)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory %s: %v", root, err)
+	}
+	return &fileStore{root: root}, nil
+}
+
+func (s *fileStore) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	// Keys must name a file strictly under the root, which may be "/".
+	rel, err := filepath.Rel(s.root, p)
+	if key == "" || err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return p, nil
+}
+
+func (s *fileStore) Put(key string, value []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a crash never leaves a
+	// truncated value behind.
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, value, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *fileStore) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	value, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	}
+	return value, err
+}
+
+func (s *fileStore) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) List(prefix string) (map[string][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	kvs := make(map[string][]byte)
+	err := filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		value, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		kvs[key] = value
+		return nil
+	})
+	return kvs, err
+}
@@ -0,0 +1,116 @@
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T) (DataStore, func()) {
+	dir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestFileStore(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if _, err := s.Get("network/1"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	for _, key := range []string{"network/1", "network/2", "endpoint/1/1"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, err := s.Get("network/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "network/1" {
+		t.Fatalf("Unexpected value %q", value)
+	}
+
+	kvs, err := s.List("network/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 || string(kvs["network/2"]) != "network/2" {
+		t.Fatalf("Unexpected list result %v", kvs)
+	}
+
+	if err := s.Delete("network/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("network/1"); err != nil {
+		t.Fatalf("Deleting a missing key failed: %v", err)
+	}
+	if _, err := s.Get("network/1"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound after deletion, got %v", err)
+	}
+}
+
+func TestFileStoreInvalidKey(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for _, key := range []string{"", "../outside", "network/../../outside"} {
+		if err := s.Put(key, nil); err == nil {
+			t.Fatalf("Expected key %q to be rejected", key)
+		}
+	}
+}
+
+func TestFileStoreFilesystemRoot(t *testing.T) {
+	s := &fileStore{root: "/"}
+
+	p, err := s.path("network/1")
+	if err != nil || p != "/network/1" {
+		t.Fatalf("Expected key network/1 to map to /network/1 under root /, got %q and %v", p, err)
+	}
+	for _, key := range []string{"", "."} {
+		if _, err := s.path(key); err == nil {
+			t.Fatalf("Expected key %q to be rejected under root /", key)
+		}
+	}
+}
+
+func TestFileStoreRootForms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, root := range []string{"./relative", filepath.Join(dir, "trailing") + "/"} {
+		s, err := NewFileStore(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put("network/1", []byte("value")); err != nil {
+			t.Fatalf("Failed to put a key under root %q: %v", root, err)
+		}
+		if value, err := s.Get("network/1"); err != nil || string(value) != "value" {
+			t.Fatalf("Failed to get the key back under root %q: %q, %v", root, value, err)
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package driverapi
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/datastore"
+	"golang.org/x/net/context"
+)
 
 var (
 	// ErrEndpointExists is returned if more than one endpoint is added to the network
@@ -9,8 +16,24 @@ var (
 	ErrNoNetwork = errors.New("No network exists")
 	// ErrNoEndpoint is returned if no endpoint with the specified id exists
 	ErrNoEndpoint = errors.New("No endpoint exists")
+	// ErrNotJoined is returned if the endpoint is not joined to the specified sandbox
+	ErrNotJoined = errors.New("Endpoint is not joined to the sandbox")
 )
 
+// ValidateName checks that name can be used as a driver's network type: it
+// must be non-empty and made of lowercase letters and digits only.
+func ValidateName(name string) error {
+	if name == "" {
+		return errors.New("driver name must not be empty")
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return fmt.Errorf("invalid driver name %q: only lowercase letters and digits are allowed", name)
+		}
+	}
+	return nil
+}
+
 // UUID represents a globally unique ID of various resources like network and endpoint
 type UUID string
 
@@ -19,7 +42,8 @@ type Driver interface {
 	// CreateNetwork invokes the driver method to create a network passing
 	// the network id and driver specific config. The config mechanism will
 	// eventually be replaced with labels which are yet to be introduced.
-	CreateNetwork(nid UUID, config interface{}) error
+	// The driver gives up early when ctx is done.
+	CreateNetwork(ctx context.Context, nid UUID, config interface{}) error
 
 	// DeleteNetwork invokes the driver method to delete network passing
 	// the network id.
@@ -28,12 +52,127 @@ type Driver interface {
 	// CreateEndpoint invokes the driver method to create an endpoint
 	// passing the network id, endpoint id, sandbox key and driver
 	// specific config. The config mechanism will eventually be replaced
-	// with labels which are yet to be introduced.
-	CreateEndpoint(nid, eid UUID, key string, config interface{}) (*SandboxInfo, error)
+	// with labels which are yet to be introduced. The driver gives up early
+	// when ctx is done. With an empty key, the endpoint resources are
+	// allocated all the same, and the sandbox is associated on Join.
+	CreateEndpoint(ctx context.Context, nid, eid UUID, key string, config interface{}) (*SandboxInfo, error)
 
 	// DeleteEndpoint invokes the driver method to delete an endpoint
 	// passing the network id and endpoint id.
 	DeleteEndpoint(nid, eid UUID) error
+
+	// Join invokes the driver method to attach an existing endpoint to the
+	// sandbox identified by the sandbox key, passing the network id,
+	// endpoint id and driver specific config. It returns the information
+	// the caller needs to place in the sandbox.
+	Join(nid, eid UUID, sboxKey string, config interface{}) (*SandboxInfo, error)
+
+	// Leave invokes the driver method to detach an endpoint from the
+	// sandbox identified by the sandbox key, releasing any driver state
+	// allocated for it on Join.
+	Leave(nid, eid UUID, sboxKey string) error
+
+	// Capabilities returns the features supported by the driver, which the
+	// caller uses to reject options the driver can't honor.
+	Capabilities() Capability
+
+	// NetworkInfo returns the addressing the driver settled on for the
+	// network, including the subnets and gateways it picked by itself.
+	NetworkInfo(nid UUID) (*NetworkInfo, error)
+
+	// EndpointStatistics returns the traffic counters of the endpoint, as
+	// seen from its interface in the host namespace.
+	EndpointStatistics(nid, eid UUID) (*InterfaceStatistics, error)
+
+	// CheckNetwork verifies that the host resources the driver set up for
+	// the network are still in place, returning an error describing the
+	// first one found missing or altered. Callers may use it to detect
+	// changes made out of band and reconcile the network.
+	CheckNetwork(nid UUID) error
+}
+
+// StoreUser is implemented by the drivers persisting state of their own, such
+// as their address allocations, in the datastore of the controller.
+type StoreUser interface {
+	// SetStore hands the driver the datastore, before any network gets
+	// created or restored.
+	SetStore(store datastore.DataStore)
+
+	// RestoreNetwork reloads the state persisted for the network, restored
+	// by the controller along with endpoints holding the given sandbox
+	// info.
+	RestoreNetwork(nid UUID, endpoints []*SandboxInfo) error
+}
+
+// Fields hold the subject of a logged change, such as the name of the link or
+// the address it is about.
+type Fields map[string]interface{}
+
+// Logger receives a debug line for each change made to the host, such as the
+// links, addresses, iptables rules and sysctls set up or torn down.
+type Logger interface {
+	Debug(msg string, fields Fields)
+}
+
+// NopLogger is a Logger discarding everything, used when none is set.
+type NopLogger struct{}
+
+// Debug does nothing.
+func (NopLogger) Debug(msg string, fields Fields) {}
+
+// LoggerUser is implemented by the drivers reporting the changes they make to
+// the host through the logger of the controller.
+type LoggerUser interface {
+	// SetLogger hands the driver the logger, before any network gets
+	// created or restored.
+	SetLogger(logger Logger)
+}
+
+// Capability represents the features a driver supports.
+type Capability struct {
+	// The driver can assign IPv6 addresses to the endpoints.
+	IPv6 bool
+
+	// The driver can publish endpoint ports on the host.
+	PortMapping bool
+
+	// The driver can manage more than one network at a time.
+	MultipleNetworks bool
+}
+
+// NetworkInfo represents the addressing of a network. Fields are left empty
+// when the network has no such address or subnet.
+type NetworkInfo struct {
+	// IPv4 subnet the endpoint addresses are allocated from.
+	Subnet *net.IPNet
+
+	// IPv4 gateway of the endpoints.
+	Gateway net.IP
+
+	// IPv6 subnet the endpoint addresses are allocated from.
+	SubnetIPv6 *net.IPNet
+
+	// IPv6 gateway of the endpoints.
+	GatewayIPv6 net.IP
+
+	// Address usage of the subnets the endpoint addresses are allocated
+	// from, empty if the driver doesn't account for it.
+	Utilization []SubnetUtilization
+}
+
+// SubnetUtilization reports how many addresses of a subnet are allocated.
+type SubnetUtilization struct {
+	Subnet *net.IPNet
+	Used   int // Number of allocated addresses, the gateway included.
+	Total  int // Number of allocatable addresses, saturating at the largest int.
+}
+
+// InterfaceStatistics represents the traffic counters of a network interface.
+type InterfaceStatistics struct {
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
 }
 
 // Interface represents the settings and identity of a network device. It is
@@ -46,7 +185,9 @@ type Interface struct {
 	SrcName string
 
 	// The name that will be assigned to the interface once moves inside a
-	// network namespace.
+	// network namespace. It must be unique within the sandbox. When left
+	// empty, the sandbox names the interface eth<N> after the lowest index
+	// not already in use, in the order interfaces are added.
 	DstName string
 
 	// IPv4 address for the interface.
@@ -68,5 +209,29 @@ type SandboxInfo struct {
 	// IPv6 gateway for the sandbox.
 	GatewayIPv6 string
 
+	// Ports published on the host for the sandbox, with the host ports
+	// resolved by the driver.
+	PortBindings []PortBinding
+
 	// TODO: Add routes and ip tables etc.
 }
+
+// Protocol represents a transport protocol for a port binding.
+type Protocol string
+
+const (
+	// TCP is the TCP transport protocol.
+	TCP Protocol = "tcp"
+	// UDP is the UDP transport protocol.
+	UDP Protocol = "udp"
+)
+
+// PortBinding represents a port of the sandbox published on the host. A zero
+// HostPort asks the driver to pick an ephemeral port, and a nil HostIP binds
+// on all host addresses.
+type PortBinding struct {
+	Proto    Protocol
+	Port     int
+	HostIP   net.IP
+	HostPort int
+}
@@ -1,18 +1,42 @@
 package libnetwork
 
 import (
+	"fmt"
+
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/drivers/host"
+	"github.com/docker/libnetwork/drivers/ipvlan"
+	"github.com/docker/libnetwork/drivers/null"
 )
 
 type driverTable map[string]driverapi.Driver
 
+type driverInitFn func() (string, driverapi.Driver)
+
 func enumerateDrivers() driverTable {
-	var drivers driverTable
-	for _, fn := range [](func() (string, driverapi.Driver)){bridge.New} {
+	drivers, err := buildDriverTable(bridge.New, host.New, ipvlan.New, null.New)
+	if err != nil {
+		panic(fmt.Sprintf("libnetwork: invalid built-in drivers: %v", err))
+	}
+
+	return drivers
+}
+
+// buildDriverTable registers the drivers returned by each of fns, rejecting
+// invalid names and names registered more than once.
+func buildDriverTable(fns ...driverInitFn) (driverTable, error) {
+	drivers := make(driverTable)
+	for _, fn := range fns {
 		name, driver := fn()
+		if err := driverapi.ValidateName(name); err != nil {
+			return nil, err
+		}
+		if _, ok := drivers[name]; ok {
+			return nil, fmt.Errorf("driver %q is registered more than once", name)
+		}
 		drivers[name] = driver
 	}
 
-	return drivers
+	return drivers, nil
 }
@@ -3,21 +3,32 @@ package bridge
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
-	"github.com/docker/libcontainer/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/pkg/options"
 	"github.com/docker/libnetwork/portmapper"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/net/context"
 )
 
 const (
 	networkType = "simplebridge"
 	vethPrefix  = "veth"
+
+	// maxVethAttempts bounds how many times veth pair creation is retried
+	// when a generated name is taken.
+	maxVethAttempts = 5
 )
 
 var (
@@ -33,22 +44,63 @@ func initPortMapper() {
 }
 
 // Configuration info for the "simplebridge" driver.
+//
+// When passed to CreateNetwork as an options.Generic, the keys of the map are
+// the names of the fields below, and each value must be of the field's type.
 type Configuration struct {
-	BridgeName         string
-	AddressIPv4        *net.IPNet
-	FixedCIDR          *net.IPNet
-	FixedCIDRv6        *net.IPNet
-	EnableIPv6         bool
-	EnableIPTables     bool
-	EnableIPMasquerade bool
-	EnableICC          bool
-	EnableIPForwarding bool
+	BridgeName             string           // Name of the bridge device, required.
+	BridgeMAC              net.HardwareAddr // MAC address of a newly created bridge.
+	AddressIPv4            *net.IPNet       // IPv4 address of the bridge, elected if nil.
+	SecondaryAddressesIPv4 []*net.IPNet     // Additional IPv4 addresses of the bridge, each the gateway of its own subnet.
+	DisableBridgeIPv4      bool             // Leave the bridge without IPv4 address, as a pure L2 network.
+	DefaultGatewayIPv4     net.IP           // Gateway of the endpoints, such as an upstream router, the bridge address if nil.
+	AddressIPv6            *net.IPNet       // IPv6 address of the bridge.
+	FixedCIDR              *net.IPNet       // Deprecated: use FixedCIDRs
+	FixedCIDRs             []*net.IPNet     // IPv4 ranges to allocate endpoint addresses from.
+	FixedCIDRv6            *net.IPNet       // IPv6 range to allocate endpoint addresses from.
+	EnableIPv6             bool
+	DisableIPv6            bool // Turn IPv6 off on the bridge, so that it gets no link-local address, left as is if false.
+	EnableIPTables         bool
+	EnableIPMasquerade     bool
+	MasqueradeExclusions   []*net.IPNet // Destinations the endpoint traffic reaches without masquerading, with EnableIPMasquerade.
+	EnableICC              bool
+	EnableIPForwarding     bool
+	EnableProxyARP         bool   // Have the bridge answer ARP requests for the addresses it routes to.
+	EnableBridgeNetfilter  bool   // Load br_netfilter and have bridged traffic go through iptables, with EnableIPTables.
+	RestoreBridgeNetfilter bool   // Put back the previous bridge-nf-call-iptables setting on DeleteNetwork.
+	AllowExisting          bool   // Adopt an existing bridge named BridgeName.
+	UplinkInterface        string // Existing interface to enslave to the bridge, released on DeleteNetwork.
+	StrictMTU              bool   // Fail rather than warn when Mtu exceeds the MTU of the uplink interface.
+	EnableSTP              bool
+	EnableHairpinMode      bool // Let endpoints reach their own published ports.
+	Mtu                    int  // MTU of the endpoints, DefaultMTU if zero.
+	VethMTU                int  // MTU of both veth ends, Mtu if zero.
+	VethTxQLen             int  // Transmit queue length of both veth ends, DefaultVethTxQLen if zero.
+}
+
+// EndpointConfiguration represents the user specified configuration for the
+// sandbox endpoint.
+//
+// When passed to CreateEndpoint as an options.Generic, the keys of the map are
+// the names of the fields below, and each value must be of the field's type.
+type EndpointConfiguration struct {
+	PortBindings []driverapi.PortBinding // Host ports to map to the endpoint.
+	RequestedIP  net.IP                  // IPv4 address of the endpoint, allocated if nil.
+	AdoptVeth    string                  // Host end of an existing veth pair to attach rather than creating one, detached with its settings put back on deletion.
+	PointToPoint int                     // Prefix length, 30 or 31, of a subnet to route to the endpoint rather than bridging it, zero to bridge it.
 }
 
 type bridgeEndpoint struct {
-	id          driverapi.UUID
-	addressIPv4 net.IP
-	addressIPv6 net.IP
+	id           driverapi.UUID
+	hostIfName   string
+	addressIPv4  net.IP
+	addressIPv6  net.IP
+	sandboxKey   string
+	sandboxInfo  *driverapi.SandboxInfo
+	portBindings []driverapi.PortBinding
+	adopted      bool          // The veth pair was handed over by the caller.
+	vethSettings *vethSettings // Settings of the adopted veth pair put back on deletion.
+	pointToPoint *net.IPNet    // Subnet routed to the endpoint through the host end, nil if bridged.
 }
 
 type bridgeNetwork struct {
@@ -61,6 +113,9 @@ type bridgeNetwork struct {
 
 type driver struct {
 	network *bridgeNetwork
+	ipam    ipallocator.IPAM
+	store   datastore.DataStore
+	logger  driverapi.Logger
 	sync.Mutex
 }
 
@@ -71,11 +126,29 @@ func init() {
 
 // New provides a new instance of bridge driver instance
 func New() (string, driverapi.Driver) {
-	return networkType, &driver{}
+	return NewWithIPAM(ipAllocator)
+}
+
+// NewWithIPAM provides a new instance of bridge driver allocating the
+// addresses of its network through ipam.
+func NewWithIPAM(ipam ipallocator.IPAM) (string, driverapi.Driver) {
+	return networkType, &driver{ipam: ipam, logger: driverapi.NopLogger{}}
+}
+
+// SetLogger makes the driver report the changes it makes to the host for the
+// networks created from now on to logger, or to nothing if nil.
+func (d *driver) SetLogger(logger driverapi.Logger) {
+	if logger == nil {
+		logger = driverapi.NopLogger{}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.logger = logger
 }
 
 // Create a new network using simplebridge plugin
-func (d *driver) CreateNetwork(id driverapi.UUID, option interface{}) error {
+func (d *driver) CreateNetwork(ctx context.Context, id driverapi.UUID, option interface{}) error {
 
 	var (
 		config *Configuration
@@ -88,6 +161,7 @@ func (d *driver) CreateNetwork(id driverapi.UUID, option interface{}) error {
 		return fmt.Errorf("network already exists, simplebridge can only have one network")
 	}
 	d.network = &bridgeNetwork{id: id}
+	logger := d.logger
 	d.Unlock()
 	defer func() {
 		// On failure make sure to reset d.network to nil
@@ -98,26 +172,65 @@ func (d *driver) CreateNetwork(id driverapi.UUID, option interface{}) error {
 		}
 	}()
 
-	switch opt := option.(type) {
-	case options.Generic:
-		opaqueConfig, err := options.GenerateFromModel(opt, &Configuration{})
-		if err != nil {
-			return fmt.Errorf("failed to generate driver config: %v", err)
-		}
-		config = opaqueConfig.(*Configuration)
-	case *Configuration:
-		config = opt
+	if config, err = parseNetworkOptions(option); err != nil {
+		return err
 	}
 
 	bridgeIface := newInterface(config)
+	bridgeIface.allocator = d.ipam
+	bridgeIface.logger = logger
+	if err = validateIfaceName(config.BridgeName); err != nil {
+		return fmt.Errorf("invalid bridge name: %v", err)
+	}
+	if config.VethMTU < 0 || config.VethTxQLen < 0 {
+		err = fmt.Errorf("invalid veth MTU %d or txqueuelen %d", config.VethMTU, config.VethTxQLen)
+		return err
+	}
+	if config.EnableBridgeNetfilter && !config.EnableIPTables {
+		err = errors.New("bridge netfilter can only be enabled along with iptables")
+		return err
+	}
+	if len(config.MasqueradeExclusions) != 0 && !(config.EnableIPTables && config.EnableIPMasquerade) {
+		err = errors.New("masquerade exclusions can only be set along with iptables and IP masquerading")
+		return err
+	}
+	if config.DisableIPv6 && (config.EnableIPv6 || config.AddressIPv6 != nil || config.FixedCIDRv6 != nil) {
+		err = errors.New("a bridge with IPv6 disabled can't have IPv6 addresses or a fixed IPv6 CIDR")
+		return err
+	}
+	// Everything IPv4 hangs off the bridge address.
+	if config.DisableBridgeIPv4 && (config.AddressIPv4 != nil || len(config.SecondaryAddressesIPv4) != 0 || config.FixedCIDR != nil || len(config.FixedCIDRs) != 0 || config.EnableIPTables || config.DefaultGatewayIPv4 != nil) {
+		err = errors.New("an L2 only bridge can't have IPv4 addresses, fixed CIDRs, a default gateway or iptables rules")
+		return err
+	}
+
+	if config.UplinkInterface != "" {
+		if err = checkUplinkMTU(config); err != nil {
+			return err
+		}
+	}
+
 	bridgeSetup := newBridgeSetup(bridgeIface)
 
 	// If the bridge interface doesn't exist, we need to start the setup steps
 	// by creating a new device and assigning it an IPv4 address.
 	bridgeAlreadyExists := bridgeIface.exists()
-	if !bridgeAlreadyExists {
+	if bridgeAlreadyExists {
+		// Only adopt a bridge left behind, for example by a previous run,
+		// when asked to.
+		if _, ok := bridgeIface.Link.(*netlink.Bridge); !ok {
+			err = fmt.Errorf("interface %q already exists and is not a bridge", config.BridgeName)
+			return err
+		}
+		if !config.AllowExisting {
+			err = fmt.Errorf("bridge %q already exists", config.BridgeName)
+			return err
+		}
+	} else {
 		bridgeSetup.queueStep(setupDevice)
-		bridgeSetup.queueStep(setupBridgeIPv4)
+		if !config.DisableBridgeIPv4 {
+			bridgeSetup.queueStep(setupBridgeIPv4)
+		}
 	}
 
 	// Conditionnally queue setup steps depending on configuration values.
@@ -131,23 +244,39 @@ func (d *driver) CreateNetwork(id driverapi.UUID, option interface{}) error {
 		// assigned an IPv6 link-local address.
 		{config.EnableIPv6, setupBridgeIPv6},
 
+		// Or keep the kernel from configuring IPv6 on the bridge at all.
+		{config.DisableIPv6, setupDisableBridgeIPv6},
+
 		// We ensure that the bridge has the expectedIPv4 and IPv6 addresses in
 		// the case of a previously existing device.
 		{bridgeAlreadyExists, setupVerifyConfiguredAddresses},
 
 		// Setup the bridge to allocate containers IPv4 addresses in the
 		// specified subnet.
-		{config.FixedCIDR != nil, setupFixedCIDRv4},
+		{config.FixedCIDR != nil || len(config.FixedCIDRs) != 0, setupFixedCIDRv4},
+
+		// Keep the external default gateway, if any, out of the endpoint
+		// addresses.
+		{config.DefaultGatewayIPv4 != nil, setupGatewayIPv4},
 
 		// Setup the bridge to allocate containers global IPv6 addresses in the
 		// specified subnet.
 		{config.FixedCIDRv6 != nil, setupFixedCIDRv6},
 
+		// Connect the bridge to the physical network.
+		{config.UplinkInterface != "", setupUplink},
+
 		// Setup IPTables.
 		{config.EnableIPTables, setupIPTables},
 
+		// Make sure the IPTables rules see the bridged traffic.
+		{config.EnableIPTables, setupBridgeNetfilter},
+
 		// Setup IP forwarding.
 		{config.EnableIPForwarding, setupIPForwarding},
+
+		// Answer ARP on behalf of the routed endpoints.
+		{config.EnableProxyARP, setupProxyARP},
 	} {
 		if step.Condition {
 			bridgeSetup.queueStep(step.Fn)
@@ -156,14 +285,57 @@ func (d *driver) CreateNetwork(id driverapi.UUID, option interface{}) error {
 
 	// Apply the prepared list of steps, and abort at the first error.
 	bridgeSetup.queueStep(setupDeviceUp)
-	if err = bridgeSetup.apply(); err != nil {
+	if err = bridgeSetup.apply(ctx); err != nil {
 		return err
 	}
 
 	d.network.bridge = bridgeIface
+	d.storeAllocations(d.network)
+	d.storeNetwork(d.network)
 	return nil
 }
 
+// parseNetworkOptions returns the bridge configuration held by option, which
+// is either a *Configuration or an options.Generic keyed by its field names.
+func parseNetworkOptions(option interface{}) (*Configuration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &Configuration{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate driver config: %v", err)
+		}
+		return opaqueConfig.(*Configuration), nil
+	case *Configuration:
+		if opt == nil {
+			return nil, errors.New("no driver config")
+		}
+		return opt, nil
+	case nil:
+		return nil, errors.New("no driver config")
+	default:
+		return nil, fmt.Errorf("invalid driver config type %T", option)
+	}
+}
+
+// parseEndpointOptions returns the endpoint configuration held by option,
+// which is either a *EndpointConfiguration or an options.Generic keyed by its
+// field names. Any other option yields the default configuration.
+func parseEndpointOptions(option interface{}) (*EndpointConfiguration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &EndpointConfiguration{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate endpoint config: %v", err)
+		}
+		return opaqueConfig.(*EndpointConfiguration), nil
+	case *EndpointConfiguration:
+		if opt != nil {
+			return opt, nil
+		}
+	}
+	return &EndpointConfiguration{}, nil
+}
+
 func (d *driver) DeleteNetwork(nid driverapi.UUID) error {
 	var err error
 	d.Lock()
@@ -193,16 +365,61 @@ func (d *driver) DeleteNetwork(nid driverapi.UUID) error {
 		return err
 	}
 
-	err = netlink.LinkDel(n.bridge.Link)
-	return err
+	// A bridge removed behind our back only spares its own deletion, the
+	// rest of the network still needs cleaning up. Failing here would
+	// prevent the caller from ever deleting the network.
+	var bridgeGone bool
+	if _, e := linkByName(n.bridge.Config.BridgeName); e != nil {
+		if _, ok := e.(linkNotFoundError); ok {
+			log.Warnf("Bridge %s of network %s is already gone", n.bridge.Config.BridgeName, n.id)
+			bridgeGone = true
+		}
+	}
+
+	// Remove the iptables rules before the bridge and its address go away.
+	if n.bridge.Config.EnableIPTables {
+		if err = teardownIPTables(n.bridge); err != nil {
+			return err
+		}
+	}
+
+	// The uplink would otherwise go down along with the bridge.
+	if n.bridge.Config.UplinkInterface != "" {
+		if err = releaseUplink(n.bridge); err != nil {
+			return err
+		}
+	}
+
+	if !bridgeGone {
+		if err = netlink.LinkDel(n.bridge.Link); err != nil {
+			return err
+		}
+		n.bridge.debug("deleted bridge", nil)
+	}
+
+	if n.bridge.Config.RestoreBridgeNetfilter {
+		if e := restoreBridgeNetfilter(n.bridge); e != nil {
+			log.Warnf("Network %s: %v", n.id, e)
+		}
+	}
+
+	releasePools(n.bridge)
+	d.deleteStoredAllocations(n.id)
+	d.deleteStoredNetwork(n.id)
+	return nil
 }
 
-func (d *driver) CreateEndpoint(nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+func (d *driver) CreateEndpoint(ctx context.Context, nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
 	var (
 		ipv6Addr net.IPNet
+		epConfig *EndpointConfiguration
 		err      error
 	)
 
+	if epConfig, err = parseEndpointOptions(config); err != nil {
+		return nil, err
+	}
+
 	d.Lock()
 	n := d.network
 	d.Unlock()
@@ -216,6 +433,13 @@ func (d *driver) CreateEndpoint(nid, eid driverapi.UUID, sboxKey string, config
 		return nil, fmt.Errorf("invalid network id %s", nid)
 	}
 
+	// The bridge address is the gateway of the endpoint, so there is no
+	// point going further without one, unless the network is L2 only.
+	if n.bridge == nil || (n.bridge.bridgeIPv4 == nil && !n.bridge.Config.DisableBridgeIPv4) {
+		n.Unlock()
+		return nil, fmt.Errorf("network %s has no bridge IPv4 address to use as gateway", nid)
+	}
+
 	if n.endpoint != nil {
 		n.Unlock()
 		return nil, driverapi.ErrEndpointExists
@@ -231,79 +455,221 @@ func (d *driver) CreateEndpoint(nid, eid driverapi.UUID, sboxKey string, config
 		}
 	}()
 
-	name1, err := generateIfaceName()
-	if err != nil {
-		return nil, err
+	txQLen := n.bridge.Config.VethTxQLen
+	if txQLen == 0 {
+		txQLen = DefaultVethTxQLen
 	}
+	var (
+		host, container netlink.Link
+		adoptedVeth     *vethSettings
+	)
+	if epConfig.AdoptVeth != "" {
+		if host, container, err = adoptVethPair(epConfig.AdoptVeth); err != nil {
+			return nil, err
+		}
+		if adoptedVeth, err = saveVethSettings(host, container); err != nil {
+			return nil, err
+		}
+		n.bridge.debug("adopted veth pair", driverapi.Fields{"host": host.Attrs().Name, "container": container.Attrs().Name})
+		defer func() {
+			if err != nil {
+				netlink.LinkSetMasterByIndex(host, 0)
+				if e := adoptedVeth.restore(host); e != nil {
+					log.Warnf("Failed to restore the settings of adopted veth %s: %v", host.Attrs().Name, e)
+				}
+			}
+		}()
+	} else {
+		var name1, name2 string
+		if name1, name2, err = createVethPair(uint32(txQLen)); err != nil {
+			return nil, err
+		}
+		n.bridge.debug("created veth pair", driverapi.Fields{"host": name1, "container": name2})
 
-	name2, err := generateIfaceName()
-	if err != nil {
-		return nil, err
+		if host, err = netlink.LinkByName(name1); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				netlink.LinkDel(host)
+			}
+		}()
+
+		if container, err = netlink.LinkByName(name2); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				netlink.LinkDel(container)
+			}
+		}()
 	}
+	name1, name2 := host.Attrs().Name, container.Attrs().Name
 
-	veth := &netlink.Veth{
-		LinkAttrs: netlink.LinkAttrs{Name: name1, TxQLen: 0},
-		PeerName:  name2}
-	if err = netlink.LinkAdd(veth); err != nil {
+	// Both ends of the veth pair get the same MTU, the bridge one unless
+	// specified, so that packets don't get dropped on their way to the
+	// container.
+	mtu := n.bridge.Config.VethMTU
+	if mtu == 0 {
+		mtu = n.bridge.Config.Mtu
+	}
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	if err = netlink.LinkSetMTU(host, mtu); err != nil {
 		return nil, err
 	}
-
-	host, err := netlink.LinkByName(name1)
-	if err != nil {
+	if err = setLinkTxQLen(host, uint32(txQLen)); err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err != nil {
-			netlink.LinkDel(host)
-		}
-	}()
-
-	container, err := netlink.LinkByName(name2)
-	if err != nil {
+	if err = netlink.LinkSetMTU(container, mtu); err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err != nil {
-			netlink.LinkDel(container)
+
+	// A routed endpoint stays out of the bridge, its traffic goes through
+	// the host routing table instead.
+	if epConfig.PointToPoint == 0 {
+		if err = netlink.LinkSetMaster(host,
+			&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: n.bridge.Config.BridgeName}}); err != nil {
+			return nil, err
 		}
-	}()
+		n.bridge.debug("attached endpoint", driverapi.Fields{"endpoint": string(eid), "interface": name1, "mtu": mtu})
+	}
 
-	if err = netlink.LinkSetMaster(host,
-		&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: n.bridge.Config.BridgeName}}); err != nil {
-		return nil, err
+	// Hairpin mode lets the bridge send traffic back through the port it
+	// came in from, for containers reaching their own published ports.
+	if n.bridge.Config.EnableHairpinMode && epConfig.PointToPoint == 0 {
+		hairpinMode := filepath.Join(sysClassNet, name1, "brport", "hairpin_mode")
+		if err = ioutil.WriteFile(hairpinMode, []byte{'1', '\n'}, 0644); err != nil {
+			return nil, fmt.Errorf("failed to enable hairpin mode on %s: %v", name1, err)
+		}
+		n.bridge.debug("enabled hairpin mode", driverapi.Fields{"interface": name1})
 	}
 
-	ip4, err := ipAllocator.RequestIP(n.bridge.bridgeIPv4, nil)
-	if err != nil {
+	if err = ctx.Err(); err != nil {
 		return nil, err
 	}
-	ipv4Addr := net.IPNet{IP: ip4, Mask: n.bridge.bridgeIPv4.Mask}
 
-	if n.bridge.Config.EnableIPv6 {
-		ip6, err := ipAllocator.RequestIP(n.bridge.bridgeIPv6, nil)
+	// Endpoints of an L2 only network get no IPv4 address, nor port
+	// mappings which would need one.
+	var (
+		ip4     net.IP
+		gateway net.IP
+		subnet  *net.IPNet
+	)
+	if n.bridge.Config.DisableBridgeIPv4 {
+		if len(epConfig.PortBindings) != 0 || epConfig.RequestedIP != nil || epConfig.PointToPoint != 0 {
+			err = errors.New("port bindings and IPv4 addresses are not supported on an L2 only bridge")
+			return nil, err
+		}
+	} else if epConfig.PointToPoint != 0 {
+		if epConfig.RequestedIP != nil {
+			err = errors.New("a point-to-point endpoint can't request an IPv4 address")
+			return nil, err
+		}
+		if subnet, gateway, ip4, err = requestPointToPoint(n.bridge, epConfig.PointToPoint); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				releasePointToPoint(n.bridge, subnet)
+			}
+		}()
+
+		// The host end is the gateway of the endpoint, its own subnet gets
+		// routed through it once up.
+		if err = addrAdd(host, &netlink.Addr{IPNet: &net.IPNet{IP: gateway, Mask: subnet.Mask}}); err != nil {
+			return nil, err
+		}
+		if err = linkSetUp(host); err != nil {
+			return nil, err
+		}
+		n.bridge.debug("routed endpoint", driverapi.Fields{"endpoint": string(eid), "interface": name1, "subnet": subnet.String()})
+
+		if err = netlink.LinkSetHardwareAddr(container, netutils.GenerateMACFromIP(ip4)); err != nil {
+			return nil, err
+		}
+	} else {
+		if epConfig.RequestedIP != nil {
+			ip4, err = requestSpecificIPv4(n.bridge, epConfig.RequestedIP)
+		} else {
+			ip4, err = requestIPv4(n.bridge)
+		}
 		if err != nil {
 			return nil, err
 		}
+		defer func() {
+			if err != nil {
+				releaseIPv4(n.bridge, ip4)
+			}
+		}()
+
+		// Derive the container MAC address from its IP, so that it stays the
+		// same across restarts of a container keeping the same address.
+		if err = netlink.LinkSetHardwareAddr(container, netutils.GenerateMACFromIP(ip4)); err != nil {
+			return nil, err
+		}
+	}
+
+	if n.bridge.Config.EnableIPv6 {
+		var ip6 net.IP
+		if ip6, err = requestIP(n.bridge.ipam(), n.bridge.bridgeIPv6, n.bridge.bridgeIPv6.IP); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				n.bridge.ipam().ReleaseIP(n.bridge.bridgeIPv6, ip6)
+			}
+		}()
 		ipv6Addr = net.IPNet{IP: ip6, Mask: n.bridge.bridgeIPv6.Mask}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bindings, err := allocatePorts(epConfig.PortBindings, ip4)
+	if err != nil {
+		return nil, err
+	}
+	if len(bindings) != 0 {
+		n.bridge.debug("published ports", driverapi.Fields{"endpoint": string(eid), "ports": len(bindings)})
+	}
+
 	var interfaces []*driverapi.Interface
 	sinfo := &driverapi.SandboxInfo{}
 
 	intf := &driverapi.Interface{}
 	intf.SrcName = name2
 	intf.DstName = "eth0"
-	intf.Address = ipv4Addr.String()
-	sinfo.Gateway = n.bridge.bridgeIPv4.IP.String()
+	if subnet != nil {
+		intf.Address = (&net.IPNet{IP: ip4, Mask: subnet.Mask}).String()
+		sinfo.Gateway = gateway.String()
+	} else if ip4 != nil {
+		// The endpoint lives in the subnet of the bridge address its
+		// IP got allocated from.
+		intf.Address = (&net.IPNet{IP: ip4, Mask: n.bridge.bridgeIPv4For(ip4).Mask}).String()
+		sinfo.Gateway = n.bridge.endpointGatewayIPv4(ip4).String()
+	}
 	if n.bridge.Config.EnableIPv6 {
 		intf.AddressIPv6 = ipv6Addr.String()
 		sinfo.GatewayIPv6 = n.bridge.bridgeIPv6.IP.String()
 	}
 
+	n.endpoint.hostIfName = name1
+	n.endpoint.adopted = epConfig.AdoptVeth != ""
+	n.endpoint.vethSettings = adoptedVeth
 	n.endpoint.addressIPv4 = ip4
+	n.endpoint.pointToPoint = subnet
 	n.endpoint.addressIPv6 = ipv6Addr.IP
 	interfaces = append(interfaces, intf)
 	sinfo.Interfaces = interfaces
+	sinfo.PortBindings = bindings
+	n.endpoint.portBindings = bindings
+	n.endpoint.sandboxKey = sboxKey
+	n.endpoint.sandboxInfo = sinfo
+	d.storeAllocations(n)
+	d.storeNetwork(n)
 	return sinfo, nil
 }
 
@@ -349,27 +715,563 @@ func (d *driver) DeleteEndpoint(nid, eid driverapi.UUID) error {
 		}
 	}()
 
-	err = ipAllocator.ReleaseIP(n.bridge.bridgeIPv4, ep.addressIPv4)
+	// Removing the host end removes the container end of the veth pair as
+	// well, wherever it lives. It may already be gone with its namespace.
+	// An adopted pair belongs to the caller, and leaves the bridge with the
+	// settings it was handed over with.
+	if link, e := netlink.LinkByName(ep.hostIfName); e == nil {
+		if ep.adopted {
+			if err = netlink.LinkSetMasterByIndex(link, 0); err == nil && ep.vethSettings != nil {
+				err = ep.vethSettings.restore(link)
+			}
+		} else {
+			err = netlink.LinkDel(link)
+		}
+		if err != nil && err != syscall.ENODEV {
+			return err
+		}
+		// Nothing changed if the veth pair went away with the namespace.
+		if err == nil {
+			if ep.adopted {
+				n.bridge.debug("detached veth pair", driverapi.Fields{"endpoint": string(eid), "interface": ep.hostIfName})
+			} else {
+				n.bridge.debug("deleted veth pair", driverapi.Fields{"endpoint": string(eid), "interface": ep.hostIfName})
+			}
+		}
+	}
+
+	err = releasePorts(ep.portBindings)
 	if err != nil {
 		return err
 	}
 
+	if ep.pointToPoint != nil {
+		err = releasePointToPoint(n.bridge, ep.pointToPoint)
+		if err != nil {
+			return err
+		}
+	} else if ep.addressIPv4 != nil {
+		err = releaseIPv4(n.bridge, ep.addressIPv4)
+		if err != nil {
+			return err
+		}
+	}
+
 	if n.bridge.Config.EnableIPv6 {
-		err := ipAllocator.ReleaseIP(n.bridge.bridgeIPv6, n.endpoint.addressIPv6)
+		err = n.bridge.ipam().ReleaseIP(n.bridge.bridgeIPv6, ep.addressIPv6)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.storeAllocations(n)
+	d.storeNetwork(n)
+	return nil
+}
+
+// Capabilities returns the features of the bridge driver, which manages a
+// single bridge network.
+func (d *driver) Capabilities() driverapi.Capability {
+	return driverapi.Capability{IPv6: true, PortMapping: true}
+}
+
+// NetworkInfo returns the subnets and gateways of the bridge, whether they were
+// configured or elected when the network got created, along with the address
+// usage of the pools endpoints are allocated from.
+func (d *driver) NetworkInfo(nid driverapi.UUID) (*driverapi.NetworkInfo, error) {
+	d.Lock()
+	n := d.network
+	d.Unlock()
+	if n == nil {
+		return nil, driverapi.ErrNoNetwork
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if n.id != nid {
+		return nil, fmt.Errorf("invalid network id %s", nid)
+	}
+
+	info := &driverapi.NetworkInfo{}
+	if n.bridge == nil {
+		return info, nil
+	}
+	if ip := n.bridge.bridgeIPv4; ip != nil {
+		info.Subnet = &net.IPNet{IP: ip.IP.Mask(ip.Mask), Mask: ip.Mask}
+		info.Gateway = n.bridge.gatewayIPv4()
+	}
+	if ip := n.bridge.bridgeIPv6; ip != nil {
+		info.SubnetIPv6 = &net.IPNet{IP: ip.IP.Mask(ip.Mask), Mask: ip.Mask}
+		info.GatewayIPv6 = ip.IP
+	}
+
+	if ipam, ok := n.bridge.ipam().(utilizationIPAM); ok {
+		pools := ipv4Pools(n.bridge)
+		if n.bridge.bridgeIPv6 != nil {
+			pools = append(pools, n.bridge.bridgeIPv6)
+		}
+		for _, pool := range pools {
+			used, total := ipam.Utilization(pool)
+			subnet := &net.IPNet{IP: pool.IP.Mask(pool.Mask), Mask: pool.Mask}
+			info.Utilization = append(info.Utilization, driverapi.SubnetUtilization{Subnet: subnet, Used: used, Total: total})
+		}
+	}
+	return info, nil
+}
+
+// CheckNetwork verifies that the bridge of the network still exists with its
+// addresses, its uplink and its iptables rules.
+func (d *driver) CheckNetwork(nid driverapi.UUID) error {
+	d.Lock()
+	n := d.network
+	d.Unlock()
+	if n == nil {
+		return driverapi.ErrNoNetwork
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if n.id != nid {
+		return fmt.Errorf("invalid network id %s", nid)
+	}
+
+	i := n.bridge
+	link, err := netlink.LinkByName(i.Config.BridgeName)
+	if err != nil {
+		return fmt.Errorf("bridge %s of network %s is missing: %v", i.Config.BridgeName, nid, err)
+	}
+	if _, ok := link.(*netlink.Bridge); !ok {
+		return fmt.Errorf("interface %s of network %s is no longer a bridge", i.Config.BridgeName, nid)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	expected := i.bridgeIPv4Networks()
+	if i.bridgeIPv6 != nil {
+		expected = append(expected, i.bridgeIPv6)
+	}
+	for _, addr := range expected {
+		if !findAddress(netlink.Addr{IPNet: addr}, addrs) {
+			return fmt.Errorf("bridge %s of network %s lost its address %s", i.Config.BridgeName, nid, addr)
+		}
+	}
+
+	if i.Config.UplinkInterface != "" {
+		uplink, err := netlink.LinkByName(i.Config.UplinkInterface)
+		if err != nil || uplink.Attrs().MasterIndex != link.Attrs().Index {
+			return fmt.Errorf("uplink interface %q is no longer enslaved to bridge %s", i.Config.UplinkInterface, i.Config.BridgeName)
+		}
+	}
+
+	if i.Config.EnableIPTables {
+		if err := checkIPTables(i); err != nil {
+			return fmt.Errorf("bridge %s of network %s: %v", i.Config.BridgeName, nid, err)
+		}
+	}
+	return nil
+}
+
+// EndpointStatistics returns the traffic counters of the host side veth of the
+// endpoint.
+func (d *driver) EndpointStatistics(nid, eid driverapi.UUID) (*driverapi.InterfaceStatistics, error) {
+	n, ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	name := ep.hostIfName
+	n.Unlock()
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("host interface %s of endpoint %s not found: %v", name, eid, err)
+	}
+	return linkStatistics(link)
+}
+
+// Join associates the endpoint with a sandbox. The veth pair of a bridge
+// endpoint can only live in one network namespace, so an endpoint can't be
+// joined to two different sandboxes at the same time.
+func (d *driver) Join(nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+	n, ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if ep.sandboxKey != "" && ep.sandboxKey != sboxKey {
+		return nil, fmt.Errorf("endpoint %s is already joined to sandbox %s", eid, ep.sandboxKey)
+	}
+	ep.sandboxKey = sboxKey
+
+	return ep.sandboxInfo, nil
+}
+
+// Leave dissociates the endpoint from a sandbox. The veth pair and addresses
+// are kept until the endpoint gets deleted.
+func (d *driver) Leave(nid, eid driverapi.UUID, sboxKey string) error {
+	n, ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if ep.sandboxKey != sboxKey {
+		return driverapi.ErrNotJoined
+	}
+	ep.sandboxKey = ""
+
+	return nil
+}
+
+func (d *driver) getEndpoint(nid, eid driverapi.UUID) (*bridgeNetwork, *bridgeEndpoint, error) {
+	d.Lock()
+	n := d.network
+	d.Unlock()
+	if n == nil {
+		return nil, nil, driverapi.ErrNoNetwork
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if n.id != nid {
+		return nil, nil, fmt.Errorf("invalid network id %s", nid)
+	}
+
+	if n.endpoint == nil || n.endpoint.id != eid {
+		return nil, nil, driverapi.ErrNoEndpoint
+	}
+
+	return n, n.endpoint, nil
+}
+
+// requestIP allocates the next available address of the network, skipping
+// the bridge own address. Once handed out, the bridge address stays allocated
+// so that it's never assigned to an endpoint.
+func requestIP(ipam ipallocator.IPAM, network *net.IPNet, bridgeIP net.IP) (net.IP, error) {
+	for {
+		ip, err := ipam.RequestIP(network, nil)
+		if err != nil || !ip.Equal(bridgeIP) {
+			return ip, err
+		}
+	}
+}
+
+// ipv4Pools returns the pools the endpoint IPv4 addresses get allocated from,
+// in order: the fixed ranges if any, the subnets of the bridge addresses
+// otherwise.
+func ipv4Pools(i *bridgeInterface) []*net.IPNet {
+	if len(i.ipv4Ranges) == 0 {
+		return i.bridgeIPv4Networks()
+	}
+	return i.ipv4Ranges
+}
+
+// requestIPv4 allocates an IPv4 address from the first pool of the bridge with
+// a free address.
+func requestIPv4(i *bridgeInterface) (net.IP, error) {
+	for _, pool := range ipv4Pools(i) {
+		ip, err := requestIP(i.ipam(), pool, i.bridgeIPv4For(pool.IP).IP)
+		if err != ipallocator.ErrNoAvailableIPs {
+			return ip, err
+		}
+	}
+	return nil, ipallocator.ErrNoAvailableIPs
+}
+
+// requestSpecificIPv4 allocates ip from the pool of the bridge containing it.
+// The bridge own addresses are never handed out.
+func requestSpecificIPv4(i *bridgeInterface, ip net.IP) (net.IP, error) {
+	for _, addr := range i.bridgeIPv4Networks() {
+		if ip.Equal(addr.IP) {
+			return nil, ipallocator.ErrIPAlreadyAllocated
+		}
+	}
+
+	for _, pool := range ipv4Pools(i) {
+		if pool.Contains(ip) {
+			return i.ipam().RequestIP(pool, ip)
+		}
+	}
+	return nil, ipallocator.ErrIPOutOfRange
+}
+
+// releaseIPv4 releases an address allocated by requestIPv4 or
+// requestSpecificIPv4 to its pool.
+func releaseIPv4(i *bridgeInterface, ip net.IP) error {
+	for _, pool := range ipv4Pools(i) {
+		if pool.Contains(ip) {
+			return i.ipam().ReleaseIP(pool, ip)
+		}
+	}
+	return ipallocator.ErrIPOutOfRange
+}
+
+// requestPointToPoint carves a subnet of prefix length ones out of the first
+// pool of the bridge with room for one, and returns it along with its host and
+// endpoint addresses. As with requestIP, a subnet holding a bridge address
+// stays allocated and another one gets carved.
+func requestPointToPoint(i *bridgeInterface, ones int) (*net.IPNet, net.IP, net.IP, error) {
+	ipam, ok := i.ipam().(ipallocator.PointToPointIPAM)
+	if !ok {
+		return nil, nil, nil, errors.New("the IP address manager of the bridge doesn't support point-to-point subnets")
+	}
+
+	for _, pool := range ipv4Pools(i) {
+		for {
+			subnet, hostIP, ip, err := ipam.RequestPointToPoint(pool, ones)
+			if err == ipallocator.ErrNoAvailableIPs {
+				break
+			}
+			if err != nil || !subnet.Contains(i.bridgeIPv4For(pool.IP).IP) {
+				return subnet, hostIP, ip, err
+			}
+		}
+	}
+	return nil, nil, nil, ipallocator.ErrNoAvailableIPs
+}
+
+// releasePointToPoint releases a subnet allocated by requestPointToPoint to
+// its pool.
+func releasePointToPoint(i *bridgeInterface, subnet *net.IPNet) error {
+	ipam, ok := i.ipam().(ipallocator.PointToPointIPAM)
+	if !ok {
+		return errors.New("the IP address manager of the bridge doesn't support point-to-point subnets")
+	}
+
+	for _, pool := range ipv4Pools(i) {
+		if pool.Contains(subnet.IP) {
+			return ipam.ReleasePointToPoint(pool, subnet)
+		}
+	}
+	return ipallocator.ErrIPOutOfRange
+}
+
+// releasePools releases the IPv4 pools of the bridge, and its fixed IPv6 one.
+// The link-local IPv6 pool is shared by all bridges and kept.
+func releasePools(i *bridgeInterface) {
+	pools := append([]*net.IPNet(nil), i.ipv4Ranges...)
+	pools = append(pools, i.bridgeIPv4Networks()...)
+	if i.Config.FixedCIDRv6 != nil {
+		pools = append(pools, i.Config.FixedCIDRv6)
+	}
+
+	for _, pool := range pools {
+		if err := i.ipam().ReleasePool(pool); err != nil {
+			log.Warnf("Failed to release address pool %s of bridge %s: %v", pool, i.Config.BridgeName, err)
+		}
+	}
+}
+
+// setLinkTxQLen sets the transmit queue length of link. The vendored netlink
+// only applies the TxQLen attribute to the peer end when creating a veth pair.
+func setLinkTxQLen(link netlink.Link, txQLen uint32) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Type = syscall.RTM_SETLINK
+	msg.Flags = syscall.NLM_F_REQUEST
+	msg.Index = int32(link.Attrs().Index)
+	msg.Change = nl.DEFAULT_CHANGE
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(syscall.IFLA_TXQLEN, nl.Uint32Attr(txQLen)))
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// randomIfaceName returns a candidate name for a veth end. It is a variable
+// so tests can force name collisions.
+var randomIfaceName = func() (string, error) {
+	return netutils.GenerateRandomName(vethPrefix, 7)
+}
+
+// iflaStats64 is IFLA_STATS64, which package syscall lacks.
+const iflaStats64 = 23
+
+// linkStatistics reads the 64 bits counters of the link, which the vendored
+// netlink package doesn't report.
+func linkStatistics(link netlink.Link) (*driverapi.InterfaceStatistics, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 || len(msgs[0]) < syscall.SizeofIfInfomsg {
+		return nil, fmt.Errorf("unexpected reply getting link %s", link.Attrs().Name)
+	}
+
+	attrs, err := nl.ParseRouteAttr(msgs[0][syscall.SizeofIfInfomsg:])
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		// struct rtnl_link_stats64 starts with rx_packets, tx_packets,
+		// rx_bytes and tx_bytes.
+		if attr.Attr.Type != iflaStats64 || len(attr.Value) < 32 {
+			continue
+		}
+		native := nl.NativeEndian()
+		return &driverapi.InterfaceStatistics{
+			RxPackets: native.Uint64(attr.Value[0:8]),
+			TxPackets: native.Uint64(attr.Value[8:16]),
+			RxBytes:   native.Uint64(attr.Value[16:24]),
+			TxBytes:   native.Uint64(attr.Value[24:32]),
+		}, nil
+	}
+	return nil, fmt.Errorf("no statistics reported for link %s", link.Attrs().Name)
+}
+
+// createVethPair creates a veth pair with the specified transmit queue length
+// on both ends, and returns the names of its host and container ends. The
+// names are checked before use, but another process may still grab one
+// before the link is added, in which case new names are generated and the
+// creation is retried.
+func createVethPair(txQLen uint32) (string, string, error) {
+	for i := 0; i < maxVethAttempts; i++ {
+		name1, err := generateIfaceName()
 		if err != nil {
+			return "", "", err
+		}
+
+		name2, err := generateIfaceName()
+		if err != nil {
+			return "", "", err
+		}
+
+		veth := &netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: name1, TxQLen: txQLen},
+			PeerName:  name2}
+		err = linkAdd(veth)
+		if err == syscall.EEXIST {
+			log.Debugf("Interface %s or %s already exists, retrying", name1, name2)
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return name1, name2, nil
+	}
+	return "", "", errors.New("Failed to create veth pair: interface names already in use")
+}
+
+// adoptVethPair returns the existing veth whose host end is named name, and its
+// peer, which must both live in the host namespace. The host end must not be
+// enslaved to any bridge yet.
+func adoptVethPair(name string) (netlink.Link, netlink.Link, error) {
+	host, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("veth %q to adopt not found: %v", name, err)
+	}
+	if _, ok := host.(*netlink.Veth); !ok {
+		return nil, nil, fmt.Errorf("interface %q to adopt is not a veth", name)
+	}
+	if host.Attrs().MasterIndex != 0 {
+		return nil, nil, fmt.Errorf("veth %q to adopt is already enslaved", name)
+	}
+
+	// The link of a veth is its peer.
+	container, err := netlink.LinkByIndex(host.Attrs().ParentIndex)
+	if err != nil || container.Attrs().ParentIndex != host.Attrs().Index {
+		return nil, nil, fmt.Errorf("peer of veth %q to adopt is not in the host namespace", name)
+	}
+	return host, container, nil
+}
+
+// vethSettings are the settings of an adopted veth pair which the endpoint
+// creation changes, kept to hand the pair back as it was handed over. The MAC
+// address and MTU of the container end are only put back while it lives in the
+// host namespace.
+type vethSettings struct {
+	HostMTU      int
+	HostTxQLen   uint32
+	HostUp       bool
+	HostAddrs    []netlink.Addr // IPv4 addresses of the host end.
+	ContainerMTU int
+	ContainerMAC net.HardwareAddr
+}
+
+func saveVethSettings(host, container netlink.Link) (*vethSettings, error) {
+	addrs, err := netlink.AddrList(host, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the addresses of veth %q to adopt: %v", host.Attrs().Name, err)
+	}
+
+	return &vethSettings{
+		HostMTU:      host.Attrs().MTU,
+		HostTxQLen:   host.Attrs().TxQLen,
+		HostUp:       host.Attrs().Flags&net.FlagUp != 0,
+		HostAddrs:    addrs,
+		ContainerMTU: container.Attrs().MTU,
+		ContainerMAC: container.Attrs().HardwareAddr,
+	}, nil
+}
+
+// restore puts back the settings of the veth pair whose host end is host.
+func (s *vethSettings) restore(host netlink.Link) error {
+	// The attributes of host may predate the changes.
+	host, err := netlink.LinkByIndex(host.Attrs().Index)
+	if err != nil {
+		return err
+	}
+
+	if err := netlink.LinkSetMTU(host, s.HostMTU); err != nil {
+		return err
+	}
+	if err := setLinkTxQLen(host, s.HostTxQLen); err != nil {
+		return err
+	}
+	addrs, err := netlink.AddrList(host, netlink.FAMILY_V4)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !findAddress(addr, s.HostAddrs) {
+			if err := netlink.AddrDel(host, &addr); err != nil {
+				return err
+			}
+		}
+	}
+	if !s.HostUp && host.Attrs().Flags&net.FlagUp != 0 {
+		if err := netlink.LinkSetDown(host); err != nil {
 			return err
 		}
 	}
 
+	// The peer went to a sandbox, or was moved back under another index.
+	container, err := netlink.LinkByIndex(host.Attrs().ParentIndex)
+	if err != nil || container.Attrs().ParentIndex != host.Attrs().Index {
+		log.Warnf("Peer of adopted veth %s is not in the host namespace, leaving its settings as is", host.Attrs().Name)
+		return nil
+	}
+	if err := netlink.LinkSetMTU(container, s.ContainerMTU); err != nil {
+		return err
+	}
+	if len(s.ContainerMAC) != 0 {
+		return netlink.LinkSetHardwareAddr(container, s.ContainerMAC)
+	}
 	return nil
 }
 
 func generateIfaceName() (string, error) {
 	for i := 0; i < 10; i++ {
-		name, err := utils.GenerateRandomName("veth", 7)
+		name, err := randomIfaceName()
 		if err != nil {
 			continue
 		}
+		if err := validateIfaceName(name); err != nil {
+			return "", err
+		}
 		if _, err := net.InterfaceByName(name); err != nil {
 			if strings.Contains(err.Error(), "no such") {
 				return name, nil
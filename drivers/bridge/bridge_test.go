@@ -2,17 +2,33 @@ package bridge
 
 import (
 	"net"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
 )
 
+func TestMain(m *testing.M) {
+	// Port publishing runs the userland proxy by re-executing the binary.
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
 func TestCreate(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	_, d := New()
 
 	config := &Configuration{BridgeName: DefaultBridgeName}
-	err := d.CreateNetwork("dummy", config)
+	err := d.CreateNetwork(context.Background(), "dummy", config)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
@@ -23,7 +39,7 @@ func TestCreateFail(t *testing.T) {
 	_, d := New()
 
 	config := &Configuration{BridgeName: "dummy0"}
-	if err := d.CreateNetwork("dummy", config); err == nil {
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
 		t.Fatal("Bridge creation was expected to fail")
 	}
 }
@@ -41,8 +57,162 @@ func TestCreateFullOptions(t *testing.T) {
 	}
 	_, config.FixedCIDRv6, _ = net.ParseCIDR("2001:db8::/48")
 
-	err := d.CreateNetwork("dummy", config)
+	err := d.CreateNetwork(context.Background(), "dummy", config)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 }
+
+func TestCreateInvalidBridgeName(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: "averylongbridgename"}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
+		t.Fatal("Bridge creation with an invalid name was expected to fail")
+	}
+}
+
+func TestCreateExistingBridge(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// Leave a bridge behind, as a previous run would.
+	br := &bridgeInterface{Config: &Configuration{BridgeName: DefaultBridgeName}, logger: driverapi.NopLogger{}}
+	if err := setupDevice(br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+	if err := setupBridgeIPv4(br); err != nil {
+		t.Fatalf("Failed to setup bridge IPv4: %v", err)
+	}
+
+	_, d := New()
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: br.bridgeIPv4}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
+		t.Fatal("Bridge creation was expected to fail without AllowExisting")
+	}
+
+	config.AllowExisting = true
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to adopt the existing bridge: %v", err)
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		t.Fatalf("Failed to list links: %v", err)
+	}
+	var count int
+	for _, l := range links {
+		if l.Attrs().Name == DefaultBridgeName {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("Expected exactly one bridge, got %d", count)
+	}
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create an endpoint on the adopted bridge: %v", err)
+	}
+	if sinfo.Gateway != br.bridgeIPv4.IP.String() {
+		t.Fatalf("Expected gateway %s, got %s", br.bridgeIPv4.IP, sinfo.Gateway)
+	}
+}
+
+func TestCreateExistingNonBridge(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: DefaultBridgeName}, PeerName: "veth0"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create veth pair: %v", err)
+	}
+
+	_, d := New()
+	config := &Configuration{BridgeName: DefaultBridgeName, AllowExisting: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
+		t.Fatal("Bridge creation was expected to fail on an existing non bridge interface")
+	}
+}
+
+func TestCreateMalformedOptions(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	for _, option := range []interface{}{
+		options.Generic{"BridgeName": 42},
+		options.Generic{"BridgeName": DefaultBridgeName, "Mtu": "1500"},
+		options.Generic{"BridgeName": DefaultBridgeName, "NoSuchField": true},
+		"not a configuration",
+		nil,
+	} {
+		err := d.CreateNetwork(context.Background(), "dummy", option)
+		if err == nil {
+			t.Fatalf("Expected an error creating a network with options %#v", option)
+		}
+		if !strings.Contains(err.Error(), "driver config") {
+			t.Fatalf("Unexpected error for options %#v: %v", option, err)
+		}
+	}
+
+	// A failed creation must not leave the driver holding a network.
+	opt := options.Generic{"BridgeName": DefaultBridgeName, "Mtu": 1400}
+	if err := d.CreateNetwork(context.Background(), "dummy", opt); err != nil {
+		t.Fatalf("Failed to create bridge from generic options: %v", err)
+	}
+}
+
+func TestCreateEndpointMalformedOptions(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	option := options.Generic{"PortBindings": "80:80"}
+	_, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", option)
+	if err == nil {
+		t.Fatal("Expected an error creating an endpoint with malformed options")
+	}
+	if !strings.Contains(err.Error(), "PortBindings") {
+		t.Fatalf("Expected the error to name the malformed field, got: %v", err)
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := NewWithIPAM(ipallocator.New())
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	if err := d.CheckNetwork("dummy"); err != nil {
+		t.Fatalf("Expected a sound network, got %v", err)
+	}
+	if err := d.CheckNetwork("other"); err == nil {
+		t.Fatal("Expected an error checking an unknown network")
+	}
+
+	link, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatalf("Failed to find bridge: %v", err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil || len(addrs) == 0 {
+		t.Fatalf("Failed to list bridge addresses: %v", err)
+	}
+	if err := netlink.AddrDel(link, &addrs[0]); err != nil {
+		t.Fatalf("Failed to remove bridge address: %v", err)
+	}
+	if err := d.CheckNetwork("dummy"); err == nil || !strings.Contains(err.Error(), "lost its address") {
+		t.Fatalf("Expected the missing address to be reported, got %v", err)
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		t.Fatalf("Failed to remove bridge: %v", err)
+	}
+	if err := d.CheckNetwork("dummy"); err == nil || !strings.Contains(err.Error(), "is missing") {
+		t.Fatalf("Expected the missing bridge to be reported, got %v", err)
+	}
+}
@@ -1,8 +1,13 @@
 package bridge
 
 import (
+	"fmt"
 	"net"
+	"strings"
+	"unicode"
 
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
 	"github.com/vishvananda/netlink"
 )
 
@@ -10,6 +15,18 @@ const (
 	// DefaultBridgeName is the default name for the bridge interface managed
 	// by the driver when unspecified by the caller.
 	DefaultBridgeName = "docker0"
+
+	// DefaultMTU is the MTU of the bridge interface when unspecified by the
+	// caller.
+	DefaultMTU = 1500
+
+	// DefaultVethTxQLen is the transmit queue length of the endpoint veth
+	// pairs when unspecified by the caller.
+	DefaultVethTxQLen = 1000
+
+	// maxIfaceNameLen is the longest interface name accepted by the kernel,
+	// IFNAMSIZ minus the terminating null byte.
+	maxIfaceNameLen = 15
 )
 
 // Interface models the bridge network device.
@@ -18,6 +35,115 @@ type bridgeInterface struct {
 	Link       netlink.Link
 	bridgeIPv4 *net.IPNet
 	bridgeIPv6 *net.IPNet
+
+	// The secondary IPv4 addresses of the bridge, each in a subnet of its
+	// own.
+	secondaryIPv4 []*net.IPNet
+
+	// The ranges of the bridge network endpoint addresses get allocated
+	// from, in order. The whole network is used when empty.
+	ipv4Ranges []*net.IPNet
+
+	// The IPv4 forwarding setting found on the host before the bridge
+	// setup enabled it, so that it can be restored on teardown.
+	prevIPForwarding []byte
+
+	// The bridge-nf-call-iptables setting found on the host before the
+	// bridge setup enabled it.
+	prevBridgeNetfilter []byte
+
+	// The address manager of the bridge, the shared built-in allocator
+	// when nil.
+	allocator ipallocator.IPAM
+
+	// The logger the changes made to the host for the bridge are reported
+	// to, a driverapi.NopLogger unless set.
+	logger driverapi.Logger
+}
+
+// ipam returns the address manager the bridge allocates addresses from.
+func (i *bridgeInterface) ipam() ipallocator.IPAM {
+	if i.allocator == nil {
+		return ipAllocator
+	}
+	return i.allocator
+}
+
+// linkNotFoundError is returned by linkByName when no link has the name it
+// holds.
+type linkNotFoundError string
+
+func (name linkNotFoundError) Error() string {
+	return fmt.Sprintf("link %s not found", string(name))
+}
+
+// linkByName returns the link named name, or a linkNotFoundError if there is
+// none. The vendored netlink reports a missing link with an untyped error, so
+// the links get listed to tell it apart from other failures.
+func linkByName(name string) (netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		return link, nil
+	}
+
+	links, lerr := netlink.LinkList()
+	if lerr != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		if l.Attrs().Name == name {
+			return nil, err
+		}
+	}
+	return nil, linkNotFoundError(name)
+}
+
+// debug reports a change made to the host for the bridge, adding its name to
+// fields.
+func (i *bridgeInterface) debug(msg string, fields driverapi.Fields) {
+	if fields == nil {
+		fields = driverapi.Fields{}
+	}
+	fields["bridge"] = i.Config.BridgeName
+	i.logger.Debug(msg, fields)
+}
+
+// gatewayIPv4 returns the default gateway of the endpoints, which is the bridge
+// itself unless an external one was configured.
+func (i *bridgeInterface) gatewayIPv4() net.IP {
+	if i.Config.DefaultGatewayIPv4 != nil {
+		return i.Config.DefaultGatewayIPv4
+	}
+	return i.bridgeIPv4.IP
+}
+
+// bridgeIPv4Networks returns the primary IPv4 address of the bridge followed by
+// the secondary ones, none for an L2 only bridge.
+func (i *bridgeInterface) bridgeIPv4Networks() []*net.IPNet {
+	if i.bridgeIPv4 == nil {
+		return nil
+	}
+	return append([]*net.IPNet{i.bridgeIPv4}, i.secondaryIPv4...)
+}
+
+// bridgeIPv4For returns the bridge address whose subnet contains ip, the
+// primary one if none does.
+func (i *bridgeInterface) bridgeIPv4For(ip net.IP) *net.IPNet {
+	for _, addr := range i.secondaryIPv4 {
+		if addr.Contains(ip) {
+			return addr
+		}
+	}
+	return i.bridgeIPv4
+}
+
+// endpointGatewayIPv4 returns the gateway of an endpoint addressed with ip: the
+// bridge address of its subnet, or the default gateway in the primary one.
+func (i *bridgeInterface) endpointGatewayIPv4(ip net.IP) net.IP {
+	if addr := i.bridgeIPv4For(ip); addr != i.bridgeIPv4 {
+		return addr.IP
+	}
+	return i.gatewayIPv4()
 }
 
 // NewInterface creates a new bridge interface structure. It attempts to find
@@ -27,6 +153,7 @@ type bridgeInterface struct {
 func newInterface(config *Configuration) *bridgeInterface {
 	i := &bridgeInterface{
 		Config: config,
+		logger: driverapi.NopLogger{},
 	}
 
 	// Initialize the bridge name to the default if unspecified.
@@ -62,3 +189,19 @@ func (i *bridgeInterface) addresses() (netlink.Addr, []netlink.Addr, error) {
 	}
 	return v4addr[0], v6addr, nil
 }
+
+// validateIfaceName checks that name is acceptable as a network interface
+// name, following the same rules as the kernel does.
+func validateIfaceName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("interface name can't be empty")
+	case len(name) > maxIfaceNameLen:
+		return fmt.Errorf("interface name %q is longer than %d bytes", name, maxIfaceNameLen)
+	case name == "." || name == "..":
+		return fmt.Errorf("invalid interface name %q", name)
+	case strings.IndexFunc(name, func(r rune) bool { return r == '/' || r == ':' || unicode.IsSpace(r) }) != -1:
+		return fmt.Errorf("interface name %q can't contain slashes, colons or spaces", name)
+	}
+	return nil
+}
@@ -30,3 +30,22 @@ func TestAddressesEmptyInterface(t *testing.T) {
 		t.Fatalf("Default interface has unexpected IPv6: %v", addrsv6)
 	}
 }
+
+func TestValidateIfaceName(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		valid bool
+	}{
+		{"docker0", true},
+		{"abcdefghijklmno", true},
+		{"abcdefghijklmnop", false},
+		{"", false},
+		{"br/0", false},
+		{"br 0", false},
+		{"..", false},
+	} {
+		if err := validateIfaceName(c.name); (err == nil) != c.valid {
+			t.Fatalf("Unexpected validation result for %q: %v", c.name, err)
+		}
+	}
+}
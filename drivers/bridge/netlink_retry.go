@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// The netlink calls retried by the bridge setup, replaced in tests.
+var (
+	linkAdd   = netlink.LinkAdd
+	linkSetUp = netlink.LinkSetUp
+	addrAdd   = netlink.AddrAdd
+)
+
+// Attempts made by retryNetlink, and delay before the first retry. The delay
+// doubles with every further retry.
+var (
+	netlinkRetryAttempts = 3
+	netlinkRetryDelay    = 50 * time.Millisecond
+)
+
+// isTransientNetlinkError tells whether err may go away on its own, as the
+// kernel finishes tearing down a link which went away during container churn.
+func isTransientNetlinkError(err error) bool {
+	switch err {
+	case syscall.EBUSY, syscall.EEXIST, syscall.EAGAIN:
+		return true
+	}
+	return false
+}
+
+// retryNetlink calls fn until it succeeds, fails with a non transient error,
+// or netlinkRetryAttempts are exhausted, and returns the last error.
+func retryNetlink(fn func() error) error {
+	var err error
+
+	delay := netlinkRetryDelay
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !isTransientNetlinkError(err) || attempt >= netlinkRetryAttempts {
+			return err
+		}
+		log.Debugf("Retrying netlink operation in %v after attempt %d failed: %v", delay, attempt, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+func withFastNetlinkRetries() func() {
+	attempts, delay := netlinkRetryAttempts, netlinkRetryDelay
+	netlinkRetryAttempts, netlinkRetryDelay = 3, time.Millisecond
+	return func() {
+		netlinkRetryAttempts, netlinkRetryDelay = attempts, delay
+	}
+}
+
+func TestRetryNetlink(t *testing.T) {
+	defer withFastNetlinkRetries()()
+
+	calls := 0
+	err := retryNetlink(func() error {
+		calls++
+		return syscall.EBUSY
+	})
+	if err != syscall.EBUSY || calls != 3 {
+		t.Fatalf("Expected the last error after 3 attempts, got %v after %d", err, calls)
+	}
+
+	calls = 0
+	permanent := errors.New("permanent failure")
+	err = retryNetlink(func() error {
+		calls++
+		return permanent
+	})
+	if err != permanent || calls != 1 {
+		t.Fatalf("Expected no retry on a permanent error, got %v after %d attempts", err, calls)
+	}
+}
+
+func TestSetupDeviceTransientFailure(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	defer withFastNetlinkRetries()()
+
+	// The kernel reports the bridge busy twice before letting it go.
+	calls := 0
+	defer func() { linkAdd = netlink.LinkAdd }()
+	linkAdd = func(link netlink.Link) error {
+		if calls++; calls <= 2 {
+			return syscall.EBUSY
+		}
+		return netlink.LinkAdd(link)
+	}
+
+	br := &bridgeInterface{Config: &Configuration{BridgeName: DefaultBridgeName}, logger: driverapi.NopLogger{}}
+	if err := setupDevice(br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("Expected bridge creation to succeed at the third attempt, got %d", calls)
+	}
+	if _, err := netlink.LinkByName(DefaultBridgeName); err != nil {
+		t.Fatalf("Failed to retrieve bridge device: %v", err)
+	}
+}
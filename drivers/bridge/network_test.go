@@ -1,12 +1,22 @@
 package bridge
 
 import (
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/pkg/iptables"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/sandbox"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
 )
 
 func TestLinkCreate(t *testing.T) {
@@ -17,12 +27,12 @@ func TestLinkCreate(t *testing.T) {
 	config := &Configuration{
 		BridgeName: DefaultBridgeName,
 		EnableIPv6: true}
-	err := d.CreateNetwork("dummy", config)
+	err := d.CreateNetwork(context.Background(), "dummy", config)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
-	sinfo, err := d.CreateEndpoint("dummy", "ep", "", "")
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
 	if err != nil {
 		t.Fatalf("Failed to create a link: %v", err)
 	}
@@ -36,7 +46,7 @@ func TestLinkCreate(t *testing.T) {
 		t.Fatal("Invalid Dstname returned")
 	}
 
-	_, err = netlink.LinkByName(interfaces[0].SrcName)
+	lnk, err := netlink.LinkByName(interfaces[0].SrcName)
 	if err != nil {
 		t.Fatalf("Could not find source link %s: %v", interfaces[0].SrcName, err)
 	}
@@ -46,6 +56,10 @@ func TestLinkCreate(t *testing.T) {
 		t.Fatalf("Invalid IPv4 address returned, ip = %s: %v", interfaces[0].Address, err)
 	}
 
+	if mac := netutils.GenerateMACFromIP(ip); lnk.Attrs().HardwareAddr.String() != mac.String() {
+		t.Fatalf("Expected MAC address %s, got %s", mac, lnk.Attrs().HardwareAddr)
+	}
+
 	n := dr.network
 	if !n.bridge.bridgeIPv4.Contains(ip) {
 		t.Fatalf("IP %s is not a valid ip in the subnet %s", ip.String(), n.bridge.bridgeIPv4.String())
@@ -71,6 +85,82 @@ func TestLinkCreate(t *testing.T) {
 	}
 }
 
+func TestLinkCreateGateway(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, addr, _ := net.ParseCIDR("192.168.1.1/24")
+	addr.IP = ip
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: addr}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+	if sinfo.Gateway != "192.168.1.1" {
+		t.Fatalf("Expected gateway 192.168.1.1, got %s", sinfo.Gateway)
+	}
+}
+
+func TestLinkCreateL2Only(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{BridgeName: DefaultBridgeName, DisableBridgeIPv4: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	addrv4, _, err := dr.network.bridge.addresses()
+	if err != nil {
+		t.Fatalf("Failed to list the bridge addresses: %v", err)
+	}
+	if addrv4.IPNet != nil {
+		t.Fatalf("Expected no IPv4 address on the bridge, got %s", addrv4.IPNet)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+	if len(sinfo.Interfaces) != 1 {
+		t.Fatalf("Expected exactly one interface, got %d", len(sinfo.Interfaces))
+	}
+	if sinfo.Interfaces[0].Address != "" || sinfo.Gateway != "" {
+		t.Fatalf("Expected no IPv4 address nor gateway, got %q and %q", sinfo.Interfaces[0].Address, sinfo.Gateway)
+	}
+
+	host, err := netlink.LinkByName(dr.network.endpoint.hostIfName)
+	if err != nil {
+		t.Fatalf("Could not find host link: %v", err)
+	}
+	if host.Attrs().MasterIndex != dr.network.bridge.Link.Attrs().Index {
+		t.Fatal("Host end of the veth pair is not attached to the bridge")
+	}
+
+	// The interface goes into a sandbox without an IPv4 address.
+	key, err := netutils.GenerateRandomName("netns", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sandbox.NewSandbox(filepath.Join(os.TempDir(), key))
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	defer s.Destroy()
+	if err := s.AddInterface(sinfo.Interfaces[0]); err != nil {
+		t.Fatalf("Failed to add the L2 only interface to the sandbox: %v", err)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete the link: %v", err)
+	}
+}
+
 func TestLinkCreateTwo(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	_, d := New()
@@ -78,17 +168,17 @@ func TestLinkCreateTwo(t *testing.T) {
 	config := &Configuration{
 		BridgeName: DefaultBridgeName,
 		EnableIPv6: true}
-	err := d.CreateNetwork("dummy", config)
+	err := d.CreateNetwork(context.Background(), "dummy", config)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
-	_, err = d.CreateEndpoint("dummy", "ep", "", "")
+	_, err = d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
 	if err != nil {
 		t.Fatalf("Failed to create a link: %v", err)
 	}
 
-	_, err = d.CreateEndpoint("dummy", "ep1", "", "")
+	_, err = d.CreateEndpoint(context.Background(), "dummy", "ep1", "", "")
 	if err != nil {
 		if err != driverapi.ErrEndpointExists {
 			t.Fatalf("Failed with a wrong error :%v", err)
@@ -104,12 +194,12 @@ func TestLinkCreateNoEnableIPv6(t *testing.T) {
 
 	config := &Configuration{
 		BridgeName: DefaultBridgeName}
-	err := d.CreateNetwork("dummy", config)
+	err := d.CreateNetwork(context.Background(), "dummy", config)
 	if err != nil {
 		t.Fatalf("Failed to create bridge: %v", err)
 	}
 
-	sinfo, err := d.CreateEndpoint("dummy", "ep", "", "")
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
 	if err != nil {
 		t.Fatalf("Failed to create a link: %v", err)
 	}
@@ -121,3 +211,798 @@ func TestLinkCreateNoEnableIPv6(t *testing.T) {
 			interfaces[0].AddressIPv6, sinfo.GatewayIPv6)
 	}
 }
+
+func TestLinkJoinLeave(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{
+		BridgeName: DefaultBridgeName}
+	err := d.CreateNetwork(context.Background(), "dummy", config)
+	if err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	jinfo, err := d.Join("dummy", "ep", "sbox1", nil)
+	if err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+	if jinfo != sinfo {
+		t.Fatal("Join returned different sandbox info than CreateEndpoint")
+	}
+
+	if _, err := d.Join("dummy", "ep", "sbox2", nil); err == nil {
+		t.Fatal("Expected joining a second sandbox to fail")
+	}
+
+	if err := d.Leave("dummy", "ep", "sbox1"); err != nil {
+		t.Fatalf("Failed to leave the sandbox: %v", err)
+	}
+
+	if err := d.Leave("dummy", "ep", "sbox1"); err != driverapi.ErrNotJoined {
+		t.Fatalf("Expected ErrNotJoined on second leave, got %v", err)
+	}
+}
+
+func TestLinkCreateAddressIPv6(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, prefix, _ := net.ParseCIDR("2001:db8:2::/64")
+	config := &Configuration{
+		BridgeName:  DefaultBridgeName,
+		EnableIPv6:  true,
+		AddressIPv6: &net.IPNet{IP: net.ParseIP("2001:db8:2::1"), Mask: prefix.Mask}}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	ip6, _, err := net.ParseCIDR(sinfo.Interfaces[0].AddressIPv6)
+	if err != nil {
+		t.Fatalf("Invalid IPv6 address returned, ip = %s: %v", sinfo.Interfaces[0].AddressIPv6, err)
+	}
+	if !prefix.Contains(ip6) {
+		t.Fatalf("IP %s is not a valid ip in the prefix %s", ip6, prefix)
+	}
+
+	if sinfo.GatewayIPv6 != "2001:db8:2::1" {
+		t.Fatalf("Invalid default gateway for IPv6. Expected 2001:db8:2::1. Got %s", sinfo.GatewayIPv6)
+	}
+}
+
+func TestLinkCreateMTU(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{
+		BridgeName: DefaultBridgeName,
+		Mtu:        1400}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	lnk, err := netlink.LinkByName(sinfo.Interfaces[0].SrcName)
+	if err != nil {
+		t.Fatalf("Could not find source link %s: %v", sinfo.Interfaces[0].SrcName, err)
+	}
+	if lnk.Attrs().MTU != 1400 {
+		t.Fatalf("Expected endpoint MTU 1400, got %d", lnk.Attrs().MTU)
+	}
+}
+
+func TestLinkCreateVethSettings(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{
+		BridgeName: DefaultBridgeName,
+		Mtu:        1400,
+		VethMTU:    1450,
+		VethTxQLen: 5000}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	for _, name := range []string{dr.network.endpoint.hostIfName, sinfo.Interfaces[0].SrcName} {
+		lnk, err := netlink.LinkByName(name)
+		if err != nil {
+			t.Fatalf("Could not find link %s: %v", name, err)
+		}
+		if lnk.Attrs().TxQLen != 5000 {
+			t.Fatalf("Expected txqueuelen 5000 on %s, got %d", name, lnk.Attrs().TxQLen)
+		}
+		if lnk.Attrs().MTU != 1450 {
+			t.Fatalf("Expected MTU 1450 on %s, got %d", name, lnk.Attrs().MTU)
+		}
+	}
+}
+
+func TestLinkDeleteReleasesIP(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{
+		BridgeName: DefaultBridgeName,
+		EnableIPv6: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+	ip, _, _ := net.ParseCIDR(sinfo.Interfaces[0].Address)
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete the link: %v", err)
+	}
+
+	// The address of the deleted endpoint must be available again.
+	if _, err := ipAllocator.RequestIP(dr.network.bridge.bridgeIPv4, ip); err != nil {
+		t.Fatalf("Address %s was not released: %v", ip, err)
+	}
+}
+
+func TestLinkCreateSkipsBridgeIP(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{
+		BridgeName:  DefaultBridgeName,
+		AddressIPv4: &net.IPNet{IP: net.ParseIP("192.168.5.1"), Mask: net.CIDRMask(24, 32)},
+		EnableIPv6:  true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	if ip, _, _ := net.ParseCIDR(sinfo.Interfaces[0].Address); ip.String() == sinfo.Gateway {
+		t.Fatalf("Endpoint was assigned the bridge address %s", ip)
+	}
+	if ip6, _, _ := net.ParseCIDR(sinfo.Interfaces[0].AddressIPv6); ip6.String() == sinfo.GatewayIPv6 {
+		t.Fatalf("Endpoint was assigned the bridge IPv6 address %s", ip6)
+	}
+}
+
+func TestLinkCreatePortBindings(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{
+		BridgeName:     DefaultBridgeName,
+		EnableIPTables: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epConfig := &EndpointConfiguration{
+		PortBindings: []driverapi.PortBinding{{Proto: driverapi.TCP, Port: 80, HostPort: 8080}}}
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	ip, _, _ := net.ParseCIDR(sinfo.Interfaces[0].Address)
+	dnatArgs := []string{"-p", "tcp", "-d", "0/0", "--dport", "8080", "!", "-i", DefaultBridgeName,
+		"-j", "DNAT", "--to-destination", net.JoinHostPort(ip.String(), "80")}
+	if !iptables.Exists(iptables.Nat, DockerChain, dnatArgs...) {
+		t.Fatal("DNAT rule not found after endpoint creation")
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+
+	if iptables.Exists(iptables.Nat, DockerChain, dnatArgs...) {
+		t.Fatal("DNAT rule still present after endpoint deletion")
+	}
+}
+
+func TestLinkCreateEphemeralPortBinding(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epConfig := &EndpointConfiguration{
+		PortBindings: []driverapi.PortBinding{{Proto: driverapi.UDP, Port: 53}}}
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	if len(sinfo.PortBindings) != 1 {
+		t.Fatalf("Expected one port binding, got %v", sinfo.PortBindings)
+	}
+	b := sinfo.PortBindings[0]
+	if b.HostPort == 0 || b.Port != 53 || b.Proto != driverapi.UDP {
+		t.Fatalf("Port binding not resolved: %v", b)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+
+	// The host port is available again once the endpoint is deleted.
+	if _, err := portMapper.Allocator.RequestPort(b.HostIP, string(b.Proto), b.HostPort); err != nil {
+		t.Fatalf("Host port %d not released: %v", b.HostPort, err)
+	}
+	portMapper.Allocator.ReleasePort(b.HostIP, string(b.Proto), b.HostPort)
+}
+
+func TestLinkDeleteRemovesVeth(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+	hostIfName := dr.network.endpoint.hostIfName
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete the link: %v", err)
+	}
+
+	for _, name := range []string{hostIfName, sinfo.Interfaces[0].SrcName} {
+		if _, err := netlink.LinkByName(name); err == nil {
+			t.Fatalf("Veth end %s still exists after endpoint deletion", name)
+		}
+	}
+}
+
+func TestLinkCreateNameCollision(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	taken := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "vethtaken0"}, PeerName: "vethtaken1"}
+	if err := netlink.LinkAdd(taken); err != nil {
+		t.Fatalf("Failed to create colliding link: %v", err)
+	}
+
+	// Hand out the names of the existing link first, then random ones.
+	collisions := []string{"vethtaken0", "vethtaken1"}
+	defer func(orig func() (string, error)) { randomIfaceName = orig }(randomIfaceName)
+	randomIfaceName = func() (string, error) {
+		if len(collisions) > 0 {
+			name := collisions[0]
+			collisions = collisions[1:]
+			return name, nil
+		}
+		return netutils.GenerateRandomName(vethPrefix, 7)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create a link despite the name collision: %v", err)
+	}
+	if len(collisions) != 0 {
+		t.Fatalf("Expected the colliding names to be tried first")
+	}
+
+	for _, name := range []string{dr.network.endpoint.hostIfName, sinfo.Interfaces[0].SrcName} {
+		if strings.HasPrefix(name, "vethtaken") {
+			t.Fatalf("Endpoint reused existing interface name %s", name)
+		}
+		if len(name) > maxIfaceNameLen {
+			t.Fatalf("Interface name %s is longer than %d bytes", name, maxIfaceNameLen)
+		}
+	}
+	if sinfo.Interfaces[0].DstName != "eth0" {
+		t.Fatalf("Expected container interface to be named eth0, got %s", sinfo.Interfaces[0].DstName)
+	}
+}
+
+func TestLinkCreateNameRace(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// Another process grabs the names of the first pair between their
+	// check and the creation of the link.
+	var tried []string
+	defer func() { linkAdd = netlink.LinkAdd }()
+	linkAdd = func(link netlink.Link) error {
+		tried = append(tried, link.Attrs().Name)
+		if len(tried) == 1 {
+			return syscall.EEXIST
+		}
+		return netlink.LinkAdd(link)
+	}
+
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil); err != nil {
+		t.Fatalf("Failed to create a link despite the name race: %v", err)
+	}
+	if len(tried) != 2 || tried[0] == tried[1] {
+		t.Fatalf("Expected the creation to be retried once with new names, got attempts for %v", tried)
+	}
+	if hostIfName := d.(*driver).network.endpoint.hostIfName; hostIfName != tried[1] {
+		t.Fatalf("Expected the endpoint to use the names of the retry, got %s", hostIfName)
+	}
+}
+
+func TestLinkCreateRequestedIP(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+	dr := d.(*driver)
+
+	ip, addr, _ := net.ParseCIDR("192.168.2.1/24")
+	addr.IP = ip
+	_, fixed, _ := net.ParseCIDR("192.168.2.0/24")
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: addr, FixedCIDR: fixed}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epConfig := &EndpointConfiguration{RequestedIP: net.ParseIP("192.168.2.50")}
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig)
+	if err != nil {
+		t.Fatalf("Failed to create a link with a requested address: %v", err)
+	}
+	if sinfo.Interfaces[0].Address != "192.168.2.50/24" {
+		t.Fatalf("Expected address 192.168.2.50/24, got %s", sinfo.Interfaces[0].Address)
+	}
+	if !dr.network.endpoint.addressIPv4.Equal(epConfig.RequestedIP) {
+		t.Fatalf("Expected endpoint address 192.168.2.50, got %s", dr.network.endpoint.addressIPv4)
+	}
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete the link: %v", err)
+	}
+}
+
+func TestLinkCreateRequestedIPConflict(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, addr, _ := net.ParseCIDR("192.168.2.1/24")
+	addr.IP = ip
+	_, fixed, _ := net.ParseCIDR("192.168.2.0/24")
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: addr, FixedCIDR: fixed}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	taken := net.ParseIP("192.168.2.60")
+	if _, err := ipAllocator.RequestIP(fixed, taken); err != nil {
+		t.Fatalf("Failed to allocate %s: %v", taken, err)
+	}
+	defer ipAllocator.ReleaseIP(fixed, taken)
+
+	for _, requested := range []string{"192.168.2.60", "192.168.2.1", "10.0.0.5"} {
+		epConfig := &EndpointConfiguration{RequestedIP: net.ParseIP(requested)}
+		if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig); err == nil {
+			t.Fatalf("Expected requesting %s to fail", requested)
+		}
+	}
+
+	// The failed requests didn't leave an endpoint behind.
+	epConfig := &EndpointConfiguration{RequestedIP: net.ParseIP("192.168.2.70")}
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig); err != nil {
+		t.Fatalf("Failed to create a link after failed requests: %v", err)
+	}
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete the link: %v", err)
+	}
+}
+
+// recordingIPAM is an ipallocator.IPAM delegating to a private allocator and
+// recording the calls made to it.
+type recordingIPAM struct {
+	ipallocator.IPAM
+	calls []string
+}
+
+func (r *recordingIPAM) RequestPool(network *net.IPNet, subnet *net.IPNet) error {
+	r.calls = append(r.calls, "RequestPool")
+	return r.IPAM.RequestPool(network, subnet)
+}
+
+func (r *recordingIPAM) RequestIP(network *net.IPNet, ip net.IP) (net.IP, error) {
+	r.calls = append(r.calls, "RequestIP")
+	return r.IPAM.RequestIP(network, ip)
+}
+
+func (r *recordingIPAM) ReleaseIP(network *net.IPNet, ip net.IP) error {
+	r.calls = append(r.calls, "ReleaseIP")
+	return r.IPAM.ReleaseIP(network, ip)
+}
+
+func (r *recordingIPAM) ReleasePool(network *net.IPNet) error {
+	r.calls = append(r.calls, "ReleasePool")
+	return r.IPAM.ReleasePool(network)
+}
+
+func TestLinkCreateCustomIPAM(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	ipam := &recordingIPAM{IPAM: ipallocator.New()}
+	_, d := NewWithIPAM(ipam)
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil); err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete the link: %v", err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete the bridge: %v", err)
+	}
+
+	expected := []string{"RequestIP", "ReleaseIP", "ReleasePool"}
+	if strings.Join(ipam.calls, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected IPAM calls %v, got %v", expected, ipam.calls)
+	}
+}
+
+func TestLinkCreateHairpinMode(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// A sysfs mounted from the test namespace shows its devices.
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := syscall.Mount("sysfs", dir, "sysfs", 0, ""); err != nil {
+		t.Fatalf("Failed to mount sysfs: %v", err)
+	}
+	defer syscall.Unmount(dir, 0)
+
+	orig := sysClassNet
+	defer func() { sysClassNet = orig }()
+	sysClassNet = filepath.Join(dir, "class", "net")
+
+	_, d := New()
+	dr := d.(*driver)
+
+	config := &Configuration{BridgeName: DefaultBridgeName, EnableHairpinMode: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil); err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	hairpinMode, err := ioutil.ReadFile(filepath.Join(sysClassNet, dr.network.endpoint.hostIfName, "brport", "hairpin_mode"))
+	if err != nil {
+		t.Fatalf("Failed to read hairpin mode: %v", err)
+	}
+	if strings.TrimSpace(string(hairpinMode)) != "1" {
+		t.Fatalf("Expected hairpin mode to be enabled, got %q", hairpinMode)
+	}
+}
+
+func TestLinkCreateStatistics(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges")
+	}
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+
+	// Move the container side to a namespace of its own, so that the
+	// traffic goes through the veth pair.
+	key, err := netutils.GenerateRandomName("netns", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sandbox.NewSandbox(filepath.Join(os.TempDir(), key))
+	if err != nil {
+		t.Fatalf("Failed to create sandbox: %v", err)
+	}
+	defer s.Destroy()
+	if err := s.AddInterface(sinfo.Interfaces[0]); err != nil {
+		t.Fatalf("Failed to add interface to the sandbox: %v", err)
+	}
+
+	// Resolving the endpoint address is enough to get traffic both ways.
+	ip, _, _ := net.ParseCIDR(sinfo.Interfaces[0].Address)
+	conn, err := net.Dial("udp", net.JoinHostPort(ip.String(), "9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		conn.Write([]byte("ping"))
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	st, err := d.(*driver).EndpointStatistics("dummy", "ep")
+	if err != nil {
+		t.Fatalf("Failed to get endpoint statistics: %v", err)
+	}
+	if st.RxBytes == 0 || st.RxPackets == 0 || st.TxBytes == 0 || st.TxPackets == 0 {
+		t.Fatalf("Expected traffic on the host side veth, got %+v", st)
+	}
+
+	// The counters of a host side veth gone behind our back are unknown.
+	link, err := netlink.LinkByName(d.(*driver).network.endpoint.hostIfName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.(*driver).EndpointStatistics("dummy", "ep"); err == nil {
+		t.Fatal("Expected an error getting the statistics of a missing interface")
+	}
+}
+
+func TestLinkCreateExternalGateway(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	ip, addr, _ := net.ParseCIDR("10.220.0.1/24")
+	addr.IP = ip
+
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: addr, DefaultGatewayIPv4: net.ParseIP("10.221.0.254")}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
+		t.Fatal("Expected an error creating a network with a gateway outside of the bridge network")
+	}
+	if link, err := netlink.LinkByName(DefaultBridgeName); err == nil {
+		netlink.LinkDel(link)
+	}
+
+	config = &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: addr, DefaultGatewayIPv4: net.ParseIP("10.220.0.2")}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create a link: %v", err)
+	}
+	defer d.DeleteEndpoint("dummy", "ep")
+
+	if sinfo.Gateway != "10.220.0.2" {
+		t.Fatalf("Expected the external gateway 10.220.0.2, got %s", sinfo.Gateway)
+	}
+	// The gateway address is never handed out.
+	if sinfo.Interfaces[0].Address != "10.220.0.3/24" {
+		t.Fatalf("Expected address 10.220.0.3/24, got %s", sinfo.Interfaces[0].Address)
+	}
+}
+
+func TestLinkCreateSecondaryAddress(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := NewWithIPAM(ipallocator.New())
+
+	config := &Configuration{
+		BridgeName:             DefaultBridgeName,
+		AddressIPv4:            &net.IPNet{IP: net.ParseIP("10.230.0.1"), Mask: net.CIDRMask(24, 32)},
+		SecondaryAddressesIPv4: []*net.IPNet{{IP: net.ParseIP("10.231.0.1"), Mask: net.CIDRMask(24, 32)}},
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	// Each endpoint gets the bridge address of its own subnet as gateway.
+	for _, tc := range []struct {
+		requested, address, gateway string
+	}{
+		{"10.230.0.20", "10.230.0.20/24", "10.230.0.1"},
+		{"10.231.0.20", "10.231.0.20/24", "10.231.0.1"},
+	} {
+		epConfig := &EndpointConfiguration{RequestedIP: net.ParseIP(tc.requested)}
+		sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig)
+		if err != nil {
+			t.Fatalf("Failed to create a link with address %s: %v", tc.requested, err)
+		}
+		if sinfo.Interfaces[0].Address != tc.address || sinfo.Gateway != tc.gateway {
+			t.Fatalf("Expected address %s and gateway %s, got %s and %s", tc.address, tc.gateway, sinfo.Interfaces[0].Address, sinfo.Gateway)
+		}
+		if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+			t.Fatalf("Failed to delete the link: %v", err)
+		}
+	}
+
+	// The secondary bridge address is never handed out.
+	epConfig := &EndpointConfiguration{RequestedIP: net.ParseIP("10.231.0.1")}
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig); err == nil {
+		t.Fatal("Expected an error requesting the secondary bridge address")
+	}
+}
+
+func TestLinkCreateAdoptVeth(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := NewWithIPAM(ipallocator.New())
+
+	config := &Configuration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	bridge, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatalf("Failed to find bridge: %v", err)
+	}
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "cnihost0"}, PeerName: "cnipeer0"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create veth pair: %v", err)
+	}
+	peer, err := netlink.LinkByName("cnipeer0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetMTU(peer, 1400); err != nil {
+		t.Fatal(err)
+	}
+	mac := peer.Attrs().HardwareAddr.String()
+
+	// The pair comes back as it was handed over, whether the adoption fails
+	// or the endpoint gets deleted.
+	assertHandedBack := func() {
+		host, err := netlink.LinkByName("cnihost0")
+		if err != nil {
+			t.Fatalf("Adopted veth removed: %v", err)
+		}
+		if host.Attrs().MasterIndex != 0 {
+			t.Fatalf("Expected cnihost0 detached from the bridge, got master index %d", host.Attrs().MasterIndex)
+		}
+		peer, err := netlink.LinkByName("cnipeer0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peer.Attrs().MTU != 1400 || peer.Attrs().HardwareAddr.String() != mac {
+			t.Fatalf("Expected cnipeer0 back with MTU 1400 and MAC %s, got %d and %s", mac, peer.Attrs().MTU, peer.Attrs().HardwareAddr)
+		}
+	}
+
+	epConfig := &EndpointConfiguration{AdoptVeth: "cnihost0", PortBindings: []driverapi.PortBinding{{Proto: "sctp", Port: 80}}}
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", epConfig); err == nil {
+		t.Fatal("Expected an error publishing an sctp port")
+	}
+	assertHandedBack()
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", &EndpointConfiguration{AdoptVeth: "cnihost0"})
+	if err != nil {
+		t.Fatalf("Failed to adopt veth: %v", err)
+	}
+	if sinfo.Interfaces[0].SrcName != "cnipeer0" || sinfo.Interfaces[0].Address == "" {
+		t.Fatalf("Expected an address for the peer cnipeer0, got %+v", sinfo.Interfaces[0])
+	}
+	host, err := netlink.LinkByName("cnihost0")
+	if err != nil {
+		t.Fatalf("Failed to find adopted veth: %v", err)
+	}
+	if host.Attrs().MasterIndex != bridge.Attrs().Index {
+		t.Fatalf("Expected cnihost0 enslaved to %s, got master index %d", DefaultBridgeName, host.Attrs().MasterIndex)
+	}
+
+	if peer, err := netlink.LinkByName("cnipeer0"); err != nil || peer.Attrs().HardwareAddr.String() == mac {
+		t.Fatalf("Expected the MAC address of cnipeer0 to be derived from its IP, got %v", err)
+	}
+
+	// The adopted pair is left in place, out of the bridge.
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete the link: %v", err)
+	}
+	assertHandedBack()
+
+	// Neither an interface other than a veth, nor an enslaved one, is
+	// adopted.
+	for _, name := range []string{"nosuchveth", DefaultBridgeName} {
+		if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", &EndpointConfiguration{AdoptVeth: name}); err == nil {
+			t.Fatalf("Expected an error adopting %s", name)
+		}
+	}
+	if err := netlink.LinkSetMaster(host, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: DefaultBridgeName}}); err != nil {
+		t.Fatalf("Failed to enslave cnihost0: %v", err)
+	}
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", &EndpointConfiguration{AdoptVeth: "cnihost0"}); err == nil {
+		t.Fatal("Expected an error adopting an enslaved veth")
+	}
+}
+
+func TestLinkCreatePointToPoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := NewWithIPAM(ipallocator.New())
+	dr := d.(*driver)
+
+	// The subnet holding the bridge address is never routed to an endpoint.
+	config := &Configuration{
+		BridgeName:  DefaultBridgeName,
+		AddressIPv4: &net.IPNet{IP: net.ParseIP("10.232.0.5"), Mask: net.CIDRMask(24, 32)},
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	for i := 0; i < 2; i++ {
+		sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", &EndpointConfiguration{PointToPoint: 30})
+		if err != nil {
+			t.Fatalf("Failed to create a point-to-point link: %v", err)
+		}
+		if sinfo.Interfaces[0].Address != "10.232.0.10/30" || sinfo.Gateway != "10.232.0.9" {
+			t.Fatalf("Expected address 10.232.0.10/30 and gateway 10.232.0.9, got %s and %s", sinfo.Interfaces[0].Address, sinfo.Gateway)
+		}
+
+		host, err := netlink.LinkByName(dr.network.endpoint.hostIfName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host.Attrs().MasterIndex != 0 {
+			t.Fatal("Expected the host end of a point-to-point link to stay out of the bridge")
+		}
+		addrs, err := netlink.AddrList(host, netlink.FAMILY_V4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gateway := &net.IPNet{IP: net.ParseIP("10.232.0.9").To4(), Mask: net.CIDRMask(30, 32)}
+		if !findAddress(netlink.Addr{IPNet: gateway}, addrs) {
+			t.Fatalf("Expected the host end to have address %s, got %v", gateway, addrs)
+		}
+
+		// Deleting the link releases its subnet for the next one.
+		if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+			t.Fatalf("Failed to delete the link: %v", err)
+		}
+	}
+
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", &EndpointConfiguration{PointToPoint: 29}); err != ipallocator.ErrBadPrefixLength {
+		t.Fatalf("Expected ErrBadPrefixLength for a /29 point-to-point link, got %v", err)
+	}
+	if _, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", &EndpointConfiguration{PointToPoint: 30, RequestedIP: net.ParseIP("10.232.0.20")}); err == nil {
+		t.Fatal("Expected an error requesting an address for a point-to-point link")
+	}
+}
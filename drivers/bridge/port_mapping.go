@@ -0,0 +1,83 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+)
+
+// allocatePorts publishes the requested bindings for the container address
+// and returns them with the host ports resolved. On failure, the bindings
+// published so far are released.
+func allocatePorts(bindings []driverapi.PortBinding, containerIP net.IP) ([]driverapi.PortBinding, error) {
+	var bs []driverapi.PortBinding
+
+	for _, b := range bindings {
+		rb, err := allocatePort(b, containerIP)
+		if err != nil {
+			if cuErr := releasePorts(bs); cuErr != nil {
+				log.Warnf("Failed to release port bindings after failure: %v", cuErr)
+			}
+			return nil, err
+		}
+		bs = append(bs, rb)
+	}
+
+	return bs, nil
+}
+
+func allocatePort(b driverapi.PortBinding, containerIP net.IP) (driverapi.PortBinding, error) {
+	var container net.Addr
+
+	switch b.Proto {
+	case driverapi.TCP:
+		container = &net.TCPAddr{IP: containerIP, Port: b.Port}
+	case driverapi.UDP:
+		container = &net.UDPAddr{IP: containerIP, Port: b.Port}
+	default:
+		return b, fmt.Errorf("unsupported protocol %q for port binding", b.Proto)
+	}
+
+	if b.HostIP == nil {
+		b.HostIP = net.IPv4zero
+	}
+
+	host, err := portMapper.Map(container, b.HostIP, b.HostPort)
+	if err != nil {
+		return b, fmt.Errorf("failed to publish port %d/%s: %v", b.Port, b.Proto, err)
+	}
+
+	switch a := host.(type) {
+	case *net.TCPAddr:
+		b.HostPort = a.Port
+	case *net.UDPAddr:
+		b.HostPort = a.Port
+	}
+
+	return b, nil
+}
+
+// releasePorts removes the bindings previously returned by allocatePorts.
+// All bindings are attempted, and the first error is returned.
+func releasePorts(bindings []driverapi.PortBinding) error {
+	var err error
+
+	for _, b := range bindings {
+		var host net.Addr
+
+		switch b.Proto {
+		case driverapi.TCP:
+			host = &net.TCPAddr{IP: b.HostIP, Port: b.HostPort}
+		case driverapi.UDP:
+			host = &net.UDPAddr{IP: b.HostIP, Port: b.HostPort}
+		}
+
+		if e := portMapper.Unmap(host); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
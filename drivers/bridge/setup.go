@@ -1,5 +1,7 @@
 package bridge
 
+import "golang.org/x/net/context"
+
 type setupStep func(*bridgeInterface) error
 
 type bridgeSetup struct {
@@ -11,8 +13,13 @@ func newBridgeSetup(i *bridgeInterface) *bridgeSetup {
 	return &bridgeSetup{bridge: i}
 }
 
-func (b *bridgeSetup) apply() error {
+// apply runs the queued steps in order, stopping at the first error or as
+// soon as ctx is done.
+func (b *bridgeSetup) apply(ctx context.Context) error {
 	for _, fn := range b.steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := fn(b.bridge); err != nil {
 			return err
 		}
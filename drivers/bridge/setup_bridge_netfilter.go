@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const bridgeNFCallIPTablesPerm = 0644
+
+// bridgeNFCallIPTables is the sysctl deciding whether bridged traffic goes
+// through iptables. It only exists once the br_netfilter module is loaded.
+var bridgeNFCallIPTables = "/proc/sys/net/bridge/bridge-nf-call-iptables"
+
+// setupBridgeNetfilter makes sure the iptables rules of the bridge apply to
+// the traffic between its endpoints. Unless asked to enable it, it only warns
+// when bridged traffic bypasses iptables.
+func setupBridgeNetfilter(i *bridgeInterface) error {
+	// Sanity check.
+	if i.Config.EnableIPTables == false {
+		return fmt.Errorf("Unexpected request to set up bridge netfilter for interface: %s", i.Config.BridgeName)
+	}
+
+	prev, err := ioutil.ReadFile(bridgeNFCallIPTables)
+	if os.IsNotExist(err) && i.Config.EnableBridgeNetfilter {
+		if out, err := exec.Command("modprobe", "br_netfilter").CombinedOutput(); err != nil {
+			return fmt.Errorf("Setup bridge netfilter failed: cannot load br_netfilter: %v: %s", err, bytes.TrimSpace(out))
+		}
+		prev, err = ioutil.ReadFile(bridgeNFCallIPTables)
+	}
+	if err != nil {
+		if i.Config.EnableBridgeNetfilter {
+			return fmt.Errorf("Setup bridge netfilter failed: cannot read current setting: %v", err)
+		}
+		log.Warnf("Cannot read %s, bridged traffic may bypass iptables: %v", bridgeNFCallIPTables, err)
+		return nil
+	}
+
+	if !i.Config.EnableBridgeNetfilter {
+		if bytes.Equal(bytes.TrimSpace(prev), []byte("0")) {
+			log.Warnf("%s is disabled, the iptables rules of bridge %s don't apply to bridged traffic", bridgeNFCallIPTables, i.Config.BridgeName)
+		}
+		return nil
+	}
+
+	// Record the current setting before overriding it.
+	i.prevBridgeNetfilter = prev
+
+	if err := ioutil.WriteFile(bridgeNFCallIPTables, []byte{'1', '\n'}, bridgeNFCallIPTablesPerm); err != nil {
+		return fmt.Errorf("Setup bridge netfilter failed: %v", err)
+	}
+	i.debug("enabled bridge netfilter", nil)
+
+	return nil
+}
+
+// restoreBridgeNetfilter puts back the setting found by setupBridgeNetfilter.
+func restoreBridgeNetfilter(i *bridgeInterface) error {
+	if i.prevBridgeNetfilter == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile(bridgeNFCallIPTables, i.prevBridgeNetfilter, bridgeNFCallIPTablesPerm); err != nil {
+		return fmt.Errorf("Failed to restore %s: %v", bridgeNFCallIPTables, err)
+	}
+	i.prevBridgeNetfilter = nil
+	i.debug("restored bridge netfilter", nil)
+	return nil
+}
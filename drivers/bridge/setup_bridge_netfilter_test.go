@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+)
+
+func TestSetupBridgeNetfilter(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Test requires root privileges")
+	}
+
+	procSetting, err := ioutil.ReadFile(bridgeNFCallIPTables)
+	if err != nil {
+		t.Skipf("Bridge netfilter is not available: %v", err)
+	}
+	defer ioutil.WriteFile(bridgeNFCallIPTables, procSetting, bridgeNFCallIPTablesPerm)
+
+	// Disable bridge netfilter to check the setup enables it.
+	if err := ioutil.WriteFile(bridgeNFCallIPTables, []byte{'0', '\n'}, bridgeNFCallIPTablesPerm); err != nil {
+		t.Skipf("Cannot toggle bridge netfilter: %v", err)
+	}
+
+	br := &bridgeInterface{
+		Config: &Configuration{
+			BridgeName:            DefaultBridgeName,
+			EnableIPTables:        true,
+			EnableBridgeNetfilter: true,
+		},
+		logger: driverapi.NopLogger{},
+	}
+	if err := setupBridgeNetfilter(br); err != nil {
+		t.Fatalf("Failed to setup bridge netfilter: %v", err)
+	}
+	if setting, _ := ioutil.ReadFile(bridgeNFCallIPTables); !bytes.Equal(setting, []byte("1\n")) {
+		t.Fatalf("Failed to effectively enable bridge netfilter, got %q", setting)
+	}
+	if !bytes.Equal(br.prevBridgeNetfilter, []byte("0\n")) {
+		t.Fatalf("Previous bridge netfilter setting not recorded: %q", br.prevBridgeNetfilter)
+	}
+
+	if err := restoreBridgeNetfilter(br); err != nil {
+		t.Fatalf("Failed to restore bridge netfilter: %v", err)
+	}
+	if setting, _ := ioutil.ReadFile(bridgeNFCallIPTables); !bytes.Equal(setting, []byte("0\n")) {
+		t.Fatalf("Failed to restore bridge netfilter, got %q", setting)
+	}
+}
+
+func TestSetupBridgeNetfilterUnavailable(t *testing.T) {
+	orig := bridgeNFCallIPTables
+	defer func() { bridgeNFCallIPTables = orig }()
+	bridgeNFCallIPTables = filepath.Join(os.TempDir(), "no-such-dir", "bridge-nf-call-iptables")
+
+	// Without being asked to enable it, a missing bridge netfilter is not
+	// an error.
+	br := &bridgeInterface{Config: &Configuration{BridgeName: DefaultBridgeName, EnableIPTables: true}, logger: driverapi.NopLogger{}}
+	if err := setupBridgeNetfilter(br); err != nil {
+		t.Fatalf("Unexpected error checking bridge netfilter: %v", err)
+	}
+	if br.prevBridgeNetfilter != nil {
+		t.Fatalf("Unexpected previous bridge netfilter setting %q", br.prevBridgeNetfilter)
+	}
+}
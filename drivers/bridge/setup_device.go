@@ -2,13 +2,19 @@ package bridge
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/parsers/kernel"
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
 )
 
+// sysClassNet is where sysfs exposes the network devices.
+var sysClassNet = "/sys/class/net"
+
 // SetupDevice create a new bridge interface/
 func setupDevice(i *bridgeInterface) error {
 	// We only attempt to create the bridge when the requested device name is
@@ -17,10 +23,23 @@ func setupDevice(i *bridgeInterface) error {
 		return fmt.Errorf("bridge device with non default name %q must be created manually", i.Config.BridgeName)
 	}
 
+	// A user specified MAC address must not clash with vendor assigned ones.
+	if mac := i.Config.BridgeMAC; mac != nil {
+		if len(mac) != 6 || mac[0]&0x01 != 0 || mac[0]&0x02 == 0 {
+			return fmt.Errorf("bridge MAC address %s must be a unicast, locally administered address", mac)
+		}
+	}
+
+	mtu := i.Config.Mtu
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+
 	// Set the bridgeInterface netlink.Bridge.
 	i.Link = &netlink.Bridge{
 		LinkAttrs: netlink.LinkAttrs{
 			Name: i.Config.BridgeName,
+			MTU:  mtu,
 		},
 	}
 
@@ -32,16 +51,40 @@ func setupDevice(i *bridgeInterface) error {
 		log.Debugf("Setting bridge mac address to %s", i.Link.Attrs().HardwareAddr)
 	}
 
-	// Call out to netlink to create the device.
-	return netlink.LinkAdd(i.Link)
+	// Call out to netlink to create the device, the name may still be held
+	// by a bridge being torn down.
+	if err := retryNetlink(func() error { return linkAdd(i.Link) }); err != nil {
+		return err
+	}
+	i.debug("created bridge", driverapi.Fields{"mtu": mtu})
+
+	if i.Config.BridgeMAC != nil {
+		if err := netlink.LinkSetHardwareAddr(i.Link, i.Config.BridgeMAC); err != nil {
+			return fmt.Errorf("failed to set bridge MAC address %s: %v", i.Config.BridgeMAC, err)
+		}
+		i.Link.Attrs().HardwareAddr = i.Config.BridgeMAC
+		i.debug("set bridge MAC address", driverapi.Fields{"mac": i.Config.BridgeMAC.String()})
+	}
+
+	// Spanning Tree Protocol is off on new bridges.
+	if i.Config.EnableSTP {
+		stpState := filepath.Join(sysClassNet, i.Config.BridgeName, "bridge", "stp_state")
+		if err := ioutil.WriteFile(stpState, []byte{'1', '\n'}, 0644); err != nil {
+			return fmt.Errorf("failed to enable STP on bridge %s: %v", i.Config.BridgeName, err)
+		}
+		i.debug("enabled STP", nil)
+	}
+
+	return nil
 }
 
 // SetupDeviceUp ups the given bridge interface.
 func setupDeviceUp(i *bridgeInterface) error {
-	err := netlink.LinkSetUp(i.Link)
+	err := retryNetlink(func() error { return linkSetUp(i.Link) })
 	if err != nil {
 		return err
 	}
+	i.debug("set bridge up", nil)
 
 	// Attempt to update the bridge interface to refresh the flags status,
 	// ignoring any failure to do so.
@@ -2,10 +2,15 @@ package bridge
 
 import (
 	"bytes"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
 )
@@ -17,6 +22,7 @@ func TestSetupNewBridge(t *testing.T) {
 		Config: &Configuration{
 			BridgeName: DefaultBridgeName,
 		},
+		logger: driverapi.NopLogger{},
 	}
 	if err := setupDevice(br); err != nil {
 		t.Fatalf("Bridge creation failed: %v", err)
@@ -39,6 +45,7 @@ func TestSetupNewNonDefaultBridge(t *testing.T) {
 		Config: &Configuration{
 			BridgeName: "test0",
 		},
+		logger: driverapi.NopLogger{},
 	}
 	if err := setupDevice(br); err == nil || !strings.Contains(err.Error(), "non default name") {
 		t.Fatalf("Expected bridge creation failure with \"non default name\", got: %v", err)
@@ -52,6 +59,7 @@ func TestSetupDeviceUp(t *testing.T) {
 		Config: &Configuration{
 			BridgeName: DefaultBridgeName,
 		},
+		logger: driverapi.NopLogger{},
 	}
 	if err := setupDevice(br); err != nil {
 		t.Fatalf("Bridge creation failed: %v", err)
@@ -66,6 +74,29 @@ func TestSetupDeviceUp(t *testing.T) {
 	}
 }
 
+func TestSetupDeviceMTU(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	br := &bridgeInterface{
+		Config: &Configuration{
+			BridgeName: DefaultBridgeName,
+			Mtu:        1400,
+		},
+		logger: driverapi.NopLogger{},
+	}
+	if err := setupDevice(br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+
+	lnk, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatalf("Failed to retrieve bridge device: %v", err)
+	}
+	if lnk.Attrs().MTU != 1400 {
+		t.Fatalf("Expected bridge MTU 1400, got %d", lnk.Attrs().MTU)
+	}
+}
+
 func TestGenerateRandomMAC(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 
@@ -75,3 +106,83 @@ func TestGenerateRandomMAC(t *testing.T) {
 		t.Fatalf("Generated twice the same MAC address %v", mac1)
 	}
 }
+
+func TestSetupDeviceBridgeMAC(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	mac, _ := net.ParseMAC("02:42:ac:11:00:01")
+	br := &bridgeInterface{
+		Config: &Configuration{
+			BridgeName: DefaultBridgeName,
+			BridgeMAC:  mac,
+		},
+		logger: driverapi.NopLogger{},
+	}
+	if err := setupDevice(br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+
+	lnk, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatalf("Failed to retrieve bridge device: %v", err)
+	}
+	if !bytes.Equal(lnk.Attrs().HardwareAddr, mac) {
+		t.Fatalf("Expected bridge MAC address %s, got %s", mac, lnk.Attrs().HardwareAddr)
+	}
+}
+
+func TestSetupDeviceInvalidBridgeMAC(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	for _, m := range []string{"01:42:ac:11:00:01", "00:42:ac:11:00:01"} {
+		mac, _ := net.ParseMAC(m)
+		br := &bridgeInterface{
+			Config: &Configuration{
+				BridgeName: DefaultBridgeName,
+				BridgeMAC:  mac,
+			},
+			logger: driverapi.NopLogger{},
+		}
+		if err := setupDevice(br); err == nil {
+			t.Fatalf("Bridge creation with MAC address %s was expected to fail", m)
+		}
+	}
+}
+
+func TestSetupDeviceSTP(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// A sysfs mounted from the test namespace shows its devices.
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := syscall.Mount("sysfs", dir, "sysfs", 0, ""); err != nil {
+		t.Fatalf("Failed to mount sysfs: %v", err)
+	}
+	defer syscall.Unmount(dir, 0)
+
+	orig := sysClassNet
+	defer func() { sysClassNet = orig }()
+	sysClassNet = filepath.Join(dir, "class", "net")
+
+	br := &bridgeInterface{
+		Config: &Configuration{
+			BridgeName: DefaultBridgeName,
+			EnableSTP:  true,
+		},
+		logger: driverapi.NopLogger{},
+	}
+	if err := setupDevice(br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+
+	stpState, err := ioutil.ReadFile(filepath.Join(sysClassNet, DefaultBridgeName, "bridge", "stp_state"))
+	if err != nil {
+		t.Fatalf("Failed to read STP state: %v", err)
+	}
+	if strings.TrimSpace(string(stpState)) != "1" {
+		t.Fatalf("Expected STP to be enabled, got state %q", stpState)
+	}
+}
@@ -2,20 +2,38 @@ package bridge
 
 import (
 	"fmt"
+	"net"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/netutils"
 )
 
 func setupFixedCIDRv4(i *bridgeInterface) error {
-	addrv4, _, err := i.addresses()
-	if err != nil {
-		return err
+	// FixedCIDR is kept as an alias for a single allocation range.
+	var ranges []*net.IPNet
+	if i.Config.FixedCIDR != nil {
+		ranges = append(ranges, i.Config.FixedCIDR)
 	}
+	ranges = append(ranges, i.Config.FixedCIDRs...)
 
-	log.Debugf("Using IPv4 subnet: %v", i.Config.FixedCIDR)
-	if err := ipAllocator.RegisterSubnet(addrv4.IPNet, i.Config.FixedCIDR); err != nil {
-		return fmt.Errorf("Setup FixedCIDRv4 failed for subnet %s in %s: %v", i.Config.FixedCIDR, addrv4.IPNet, err)
+	// Each range lies within the subnet of one of the bridge addresses.
+	for _, r := range ranges {
+		if !netutils.NetworkContains(i.bridgeIPv4For(r.IP), r) {
+			return fmt.Errorf("fixed CIDR %s is not within bridge network %s", r, joinNetworks(i.bridgeIPv4Networks()))
+		}
 	}
 
+	log.Debugf("Using IPv4 subnets: %v", ranges)
+	i.ipv4Ranges = ranges
+
 	return nil
 }
+
+func joinNetworks(networks []*net.IPNet) string {
+	s := make([]string, len(networks))
+	for i, n := range networks {
+		s[i] = n.String()
+	}
+	return strings.Join(s, ", ")
+}
@@ -4,6 +4,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
 )
 
@@ -16,6 +17,7 @@ func TestSetupFixedCIDRv4(t *testing.T) {
 			AddressIPv4: &net.IPNet{IP: net.ParseIP("192.168.1.1"), Mask: net.CIDRMask(16, 32)},
 			FixedCIDR:   &net.IPNet{IP: net.ParseIP("192.168.2.0"), Mask: net.CIDRMask(24, 32)},
 		},
+		logger: driverapi.NopLogger{},
 	}
 	if err := setupDevice(br); err != nil {
 		t.Fatalf("Bridge creation failed: %v", err)
@@ -44,6 +46,82 @@ func TestSetupBadFixedCIDRv4(t *testing.T) {
 			AddressIPv4: &net.IPNet{IP: net.ParseIP("192.168.1.1"), Mask: net.CIDRMask(24, 32)},
 			FixedCIDR:   &net.IPNet{IP: net.ParseIP("192.168.2.0"), Mask: net.CIDRMask(24, 32)},
 		},
+		logger: driverapi.NopLogger{},
+	}
+	if err := setupDevice(br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+	if err := setupBridgeIPv4(br); err != nil {
+		t.Fatalf("Assign IPv4 to bridge failed: %v", err)
+	}
+
+	expected := "fixed CIDR 192.168.2.0/24 is not within bridge network 192.168.1.1/24"
+	if err := setupFixedCIDRv4(br); err == nil || err.Error() != expected {
+		t.Fatalf("Setup bridge FixedCIDRv4 should have failed with %q, got %v", expected, err)
+	}
+}
+
+func TestSetupFixedCIDRsv4(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	br := &bridgeInterface{
+		Config: &Configuration{
+			BridgeName:  DefaultBridgeName,
+			AddressIPv4: &net.IPNet{IP: net.ParseIP("192.168.77.1"), Mask: net.CIDRMask(24, 32)},
+			FixedCIDRs: []*net.IPNet{
+				{IP: net.ParseIP("192.168.77.0"), Mask: net.CIDRMask(25, 32)},
+				{IP: net.ParseIP("192.168.77.128"), Mask: net.CIDRMask(25, 32)},
+			},
+		},
+		logger: driverapi.NopLogger{},
+	}
+	if err := setupDevice(br); err != nil {
+		t.Fatalf("Bridge creation failed: %v", err)
+	}
+	if err := setupBridgeIPv4(br); err != nil {
+		t.Fatalf("Assign IPv4 to bridge failed: %v", err)
+	}
+	if err := setupFixedCIDRv4(br); err != nil {
+		t.Fatalf("Failed to setup bridge FixedCIDRv4: %v", err)
+	}
+
+	// The first range holds 126 addresses, one of which is the bridge's.
+	for i := 0; i < 125; i++ {
+		ip, err := requestIPv4(br)
+		if err != nil {
+			t.Fatalf("Failed to request IP: %v", err)
+		}
+		if !br.Config.FixedCIDRs[0].Contains(ip) {
+			t.Fatalf("Expected IP %s to be allocated from %s", ip, br.Config.FixedCIDRs[0])
+		}
+	}
+
+	ip, err := requestIPv4(br)
+	if err != nil {
+		t.Fatalf("Failed to request IP: %v", err)
+	}
+	if expected := "192.168.77.129"; ip.String() != expected {
+		t.Fatalf("Expected allocation to spill to %s, got %s", expected, ip)
+	}
+
+	if err := releaseIPv4(br, ip); err != nil {
+		t.Fatalf("Failed to release IP: %v", err)
+	}
+}
+
+func TestSetupBadFixedCIDRsv4(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	br := &bridgeInterface{
+		Config: &Configuration{
+			BridgeName:  DefaultBridgeName,
+			AddressIPv4: &net.IPNet{IP: net.ParseIP("192.168.78.1"), Mask: net.CIDRMask(24, 32)},
+			FixedCIDRs: []*net.IPNet{
+				{IP: net.ParseIP("192.168.78.0"), Mask: net.CIDRMask(25, 32)},
+				{IP: net.ParseIP("192.168.78.0"), Mask: net.CIDRMask(23, 32)},
+			},
+		},
+		logger: driverapi.NopLogger{},
 	}
 	if err := setupDevice(br); err != nil {
 		t.Fatalf("Bridge creation failed: %v", err)
@@ -53,6 +131,6 @@ func TestSetupBadFixedCIDRv4(t *testing.T) {
 	}
 
 	if err := setupFixedCIDRv4(br); err == nil {
-		t.Fatal("Setup bridge FixedCIDRv4 should have failed")
+		t.Fatal("Setup bridge FixedCIDRv4 should have failed with a range larger than the bridge network")
 	}
 }
@@ -0,0 +1,27 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/ipallocator"
+)
+
+// setupGatewayIPv4 checks the external gateway is within the bridge network,
+// and keeps it from being allocated to an endpoint.
+func setupGatewayIPv4(i *bridgeInterface) error {
+	gw := i.Config.DefaultGatewayIPv4
+	if i.bridgeIPv4 == nil || !i.bridgeIPv4.Contains(gw) {
+		return fmt.Errorf("default gateway %s is not within bridge network %s", gw, i.bridgeIPv4)
+	}
+
+	// The bridge address is never handed out already.
+	if gw.Equal(i.bridgeIPv4.IP) {
+		return nil
+	}
+
+	// Nor is an address outside of the allocation ranges.
+	if _, err := requestSpecificIPv4(i, gw); err != nil && err != ipallocator.ErrIPOutOfRange {
+		return fmt.Errorf("failed to reserve default gateway %s: %v", gw, err)
+	}
+	return nil
+}
@@ -16,10 +16,18 @@ func setupIPForwarding(i *bridgeInterface) error {
 		return fmt.Errorf("Unexpected request to enable IP Forwarding for: %v", *i)
 	}
 
+	// Record the current setting before overriding it.
+	prev, err := ioutil.ReadFile(ipv4ForwardConf)
+	if err != nil {
+		return fmt.Errorf("Setup IP forwarding failed: cannot read current setting: %v", err)
+	}
+	i.prevIPForwarding = prev
+
 	// Enable IPv4 forwarding
 	if err := ioutil.WriteFile(ipv4ForwardConf, []byte{'1', '\n'}, ipv4ForwardConfPerm); err != nil {
 		return fmt.Errorf("Setup IP forwarding failed: %v", err)
 	}
+	i.debug("enabled IP forwarding", nil)
 
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
 )
 
 func TestSetupIPForwarding(t *testing.T) {
@@ -23,6 +25,7 @@ func TestSetupIPForwarding(t *testing.T) {
 			BridgeName:         DefaultBridgeName,
 			EnableIPForwarding: true,
 		},
+		logger: driverapi.NopLogger{},
 	}
 
 	// Set IP Forwarding
@@ -35,6 +38,11 @@ func TestSetupIPForwarding(t *testing.T) {
 	if bytes.Compare(procSetting, []byte("1\n")) != 0 {
 		t.Fatalf("Failed to effectively setup IP forwarding")
 	}
+
+	// The disabled setting found before the setup must have been recorded.
+	if bytes.Compare(br.prevIPForwarding, []byte("0\n")) != 0 {
+		t.Fatalf("Previous IP forwarding setting not recorded: %q", br.prevIPForwarding)
+	}
 }
 
 func TestUnexpectedSetupIPForwarding(t *testing.T) {
@@ -48,6 +56,7 @@ func TestUnexpectedSetupIPForwarding(t *testing.T) {
 			BridgeName:         DefaultBridgeName,
 			EnableIPForwarding: false,
 		},
+		logger: driverapi.NopLogger{},
 	}
 
 	// Attempt Set IP Forwarding
@@ -5,39 +5,141 @@ import (
 	"net"
 
 	"github.com/docker/docker/pkg/iptables"
-	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/driverapi"
 )
 
 // DockerChain: DOCKER iptable chain name
 const (
 	DockerChain = "DOCKER"
+
+	// networkChainPrefix prefixes the chains holding the rules of each
+	// bridge network, which the built-in chains jump to.
+	networkChainPrefix = "LN-"
 )
 
+// networkChain returns the name of the chains holding the rules of the bridge,
+// in both the filter and nat tables. The chains are named after the bridge
+// rather than the network, so that adopting a bridge left behind takes over
+// its rules instead of stacking new ones.
+func networkChain(bridgeIface string) string {
+	return networkChainPrefix + bridgeIface
+}
+
 func setupIPTables(i *bridgeInterface) error {
 	// Sanity check.
 	if i.Config.EnableIPTables == false {
 		return fmt.Errorf("Unexpected request to set IP tables for interface: %s", i.Config.BridgeName)
 	}
 
-	addrv4, _, err := netutils.GetIfaceAddr(i.Config.BridgeName)
-	if err != nil {
-		return fmt.Errorf("Failed to setup IP tables, cannot acquire Interface address: %s", err.Error())
+	addrs := i.bridgeIPv4Networks()
+	if len(addrs) == 0 {
+		return fmt.Errorf("Failed to setup IP tables, bridge %s has no IPv4 address", i.Config.BridgeName)
 	}
-	if err = setupIPTablesInternal(i.Config.BridgeName, addrv4, i.Config.EnableICC, i.Config.EnableIPMasquerade, true); err != nil {
+
+	// The jumps to the network chains get inserted before the ones to the
+	// DOCKER chains, which thus come first.
+	chain := networkChain(i.Config.BridgeName)
+	if err := setupNetworkChain(iptables.Filter, "FORWARD", chain); err != nil {
+		return fmt.Errorf("Failed to setup IP tables: %s", err.Error())
+	}
+	if err := setupNetworkChain(iptables.Nat, "POSTROUTING", chain); err != nil {
+		return fmt.Errorf("Failed to setup IP tables: %s", err.Error())
+	}
+
+	if err := setupIPTablesInternal(i.Config.BridgeName, addrs[0], i.Config.EnableICC, i.Config.EnableIPMasquerade, i.Config.EnableHairpinMode, true); err != nil {
 		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 	}
 
-	_, err = iptables.NewChain(DockerChain, i.Config.BridgeName, iptables.Nat)
+	// The secondary subnets get masqueraded just like the primary one. Rules
+	// get inserted at the top of the chain, so the exclusions come after the
+	// MASQUERADE rule of their subnet to precede it.
+	if i.Config.EnableIPMasquerade {
+		for n, addr := range addrs {
+			if n > 0 {
+				natRule, _, _, _ := networkRules(i.Config.BridgeName, addr)
+				if err := programChainRule(natRule, "NAT", true); err != nil {
+					return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+				}
+			}
+			for _, dst := range i.Config.MasqueradeExclusions {
+				if err := programChainRule(masqueradeExclusionRule(i.Config.BridgeName, addr, dst), "MASQUERADE EXCLUSION", true); err != nil {
+					return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+				}
+			}
+		}
+	}
+
+	_, err := iptables.NewChain(DockerChain, i.Config.BridgeName, iptables.Nat)
 	if err != nil {
 		return fmt.Errorf("Failed to create NAT chain: %s", err.Error())
 	}
 
-	chain, err := iptables.NewChain(DockerChain, i.Config.BridgeName, iptables.Filter)
+	dockerChain, err := iptables.NewChain(DockerChain, i.Config.BridgeName, iptables.Filter)
 	if err != nil {
 		return fmt.Errorf("Failed to create FILTER chain: %s", err.Error())
 	}
 
-	portMapper.SetIptablesChain(chain)
+	portMapper.SetIptablesChain(dockerChain)
+	i.debug("set up iptables rules", driverapi.Fields{"chain": chain})
+
+	return nil
+}
+
+// teardownIPTables removes the rules installed by setupIPTables for the
+// bridge interface, by removing the network chains as a whole.
+func teardownIPTables(i *bridgeInterface) error {
+	chain := networkChain(i.Config.BridgeName)
+	if err := removeNetworkChain(iptables.Filter, "FORWARD", chain); err != nil {
+		return fmt.Errorf("Failed to remove IP tables: %s", err.Error())
+	}
+	if err := removeNetworkChain(iptables.Nat, "POSTROUTING", chain); err != nil {
+		return fmt.Errorf("Failed to remove IP tables: %s", err.Error())
+	}
+	i.debug("removed iptables rules", driverapi.Fields{"chain": chain})
+
+	return nil
+}
+
+func chainExists(table iptables.Table, name string) bool {
+	_, err := iptables.Raw("-t", string(table), "-n", "-L", name)
+	return err == nil
+}
+
+func jumpRule(table iptables.Table, builtin, name string) iptRule {
+	return iptRule{table: table, chain: builtin, preArgs: []string{"-t", string(table)}, args: []string{"-j", name}}
+}
+
+// setupNetworkChain creates the chain name in table, unless it exists
+// already, and makes the built-in chain jump to it.
+func setupNetworkChain(table iptables.Table, builtin, name string) error {
+	if !chainExists(table, name) {
+		if output, err := iptables.Raw("-t", string(table), "-N", name); err != nil {
+			return fmt.Errorf("Unable to create %s/%s chain: %s", table, name, err.Error())
+		} else if len(output) != 0 {
+			return &iptables.ChainError{Chain: name, Output: output}
+		}
+	}
+
+	return programChainRule(jumpRule(table, builtin, name), "JUMP "+name, true)
+}
+
+// removeNetworkChain removes the jump from the built-in chain to the chain
+// name of table, then flushes and deletes the chain.
+func removeNetworkChain(table iptables.Table, builtin, name string) error {
+	if err := programChainRule(jumpRule(table, builtin, name), "JUMP "+name, false); err != nil {
+		return err
+	}
+	if !chainExists(table, name) {
+		return nil
+	}
+
+	for _, op := range []string{"-F", "-X"} {
+		if output, err := iptables.Raw("-t", string(table), op, name); err != nil {
+			return fmt.Errorf("Unable to remove %s/%s chain: %s", table, name, err.Error())
+		} else if len(output) != 0 {
+			return &iptables.ChainError{Chain: name, Output: output}
+		}
+	}
 
 	return nil
 }
@@ -49,15 +151,30 @@ type iptRule struct {
 	args    []string
 }
 
-func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, enable bool) error {
-
+// networkRules returns the rules of the network chains of the bridge with
+// address addr.
+func networkRules(bridgeIface string, addr net.Addr) (natRule, hairpinRule, outRule, inRule iptRule) {
 	var (
 		address = addr.String()
-		natRule = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		outRule = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
-		inRule  = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", bridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}
+		chain   = networkChain(bridgeIface)
 	)
 
+	natRule = iptRule{table: iptables.Nat, chain: chain, preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
+	hairpinRule = iptRule{table: iptables.Nat, chain: chain, preArgs: []string{"-t", "nat"}, args: []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}}
+	outRule = iptRule{table: iptables.Filter, chain: chain, args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
+	inRule = iptRule{table: iptables.Filter, chain: chain, args: []string{"-o", bridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}
+	return
+}
+
+// masqueradeExclusionRule returns the rule keeping the traffic from the bridge
+// network with address addr to dst from being masqueraded.
+func masqueradeExclusionRule(bridgeIface string, addr net.Addr, dst *net.IPNet) iptRule {
+	return iptRule{table: iptables.Nat, chain: networkChain(bridgeIface), preArgs: []string{"-t", "nat"}, args: []string{"-s", addr.String(), "-d", dst.String(), "-j", "RETURN"}}
+}
+
+func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairpin, enable bool) error {
+	natRule, hairpinRule, outRule, inRule := networkRules(bridgeIface, addr)
+
 	// Set NAT.
 	if ipmasq {
 		if err := programChainRule(natRule, "NAT", enable); err != nil {
@@ -65,6 +182,14 @@ func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, enabl
 		}
 	}
 
+	// Set the loopback masquerading needed for hairpinned traffic to leave
+	// the bridge with a source the container can answer to.
+	if hairpin {
+		if err := programChainRule(hairpinRule, "HAIRPIN MASQUERADE", enable); err != nil {
+			return err
+		}
+	}
+
 	// Set Inter Container Communication.
 	if err := setIcc(bridgeIface, icc, enable); err != nil {
 		return err
@@ -83,6 +208,53 @@ func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, enabl
 	return nil
 }
 
+// checkIPTables verifies that the jumps to the network chains and the rules
+// setupIPTables programs in them are all present.
+func checkIPTables(i *bridgeInterface) error {
+	bridgeIface := i.Config.BridgeName
+	chain := networkChain(bridgeIface)
+	addrs := i.bridgeIPv4Networks()
+	if len(addrs) == 0 {
+		return fmt.Errorf("bridge %s has no IPv4 address to check the IP tables rules of", bridgeIface)
+	}
+	_, hairpinRule, outRule, inRule := networkRules(bridgeIface, addrs[0])
+
+	iccTarget := "DROP"
+	if i.Config.EnableICC {
+		iccTarget = "ACCEPT"
+	}
+	iccRule := iptRule{table: iptables.Filter, chain: chain, args: []string{"-i", bridgeIface, "-o", bridgeIface, "-j", iccTarget}}
+
+	rules := []struct {
+		rule      iptRule
+		ruleDescr string
+		enabled   bool
+	}{
+		{jumpRule(iptables.Filter, "FORWARD", chain), "JUMP " + chain, true},
+		{jumpRule(iptables.Nat, "POSTROUTING", chain), "JUMP " + chain, true},
+		{hairpinRule, "HAIRPIN MASQUERADE", i.Config.EnableHairpinMode},
+		{iccRule, "ICC", true},
+		{outRule, "ACCEPT NON_ICC OUTGOING", true},
+		{inRule, "ACCEPT INCOMING", true},
+	}
+	for _, r := range rules {
+		if r.enabled && !iptables.Exists(r.rule.table, r.rule.chain, r.rule.args...) {
+			return fmt.Errorf("%s rule is missing from the %s/%s chain", r.ruleDescr, r.rule.table, r.rule.chain)
+		}
+	}
+	for _, addr := range addrs {
+		if natRule, _, _, _ := networkRules(bridgeIface, addr); i.Config.EnableIPMasquerade && !iptables.Exists(natRule.table, natRule.chain, natRule.args...) {
+			return fmt.Errorf("NAT rule for %s is missing from the %s/%s chain", addr, natRule.table, natRule.chain)
+		}
+		for _, dst := range i.Config.MasqueradeExclusions {
+			if rule := masqueradeExclusionRule(bridgeIface, addr, dst); !iptables.Exists(rule.table, rule.chain, rule.args...) {
+				return fmt.Errorf("MASQUERADE EXCLUSION rule from %s to %s is missing from the %s/%s chain", addr, dst, rule.table, rule.chain)
+			}
+		}
+	}
+	return nil
+}
+
 func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 	var (
 		prefix    []string
@@ -118,7 +290,7 @@ func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 func setIcc(bridgeIface string, iccEnable, insert bool) error {
 	var (
 		table      = iptables.Filter
-		chain      = "FORWARD"
+		chain      = networkChain(bridgeIface)
 		args       = []string{"-i", bridgeIface, "-o", bridgeIface, "-j"}
 		acceptArgs = append(args, "ACCEPT")
 		dropArgs   = append(args, "DROP")
@@ -2,10 +2,14 @@ package bridge
 
 import (
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/pkg/iptables"
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -56,12 +60,149 @@ func TestSetupIPTables(t *testing.T) {
 	assertBridgeConfig(br, t)
 }
 
+func TestIPMasqueradeRule(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{
+		BridgeName:         DefaultBridgeName,
+		AddressIPv4:        &net.IPNet{IP: net.ParseIP(iptablesTestBridgeIP), Mask: net.CIDRMask(16, 32)},
+		EnableIPTables:     true,
+		EnableIPMasquerade: true,
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	chain := networkChain(DefaultBridgeName)
+	natArgs := []string{"-s", config.AddressIPv4.String(), "!", "-o", DefaultBridgeName, "-j", "MASQUERADE"}
+	if !iptables.Exists(iptables.Nat, chain, natArgs...) {
+		t.Fatal("MASQUERADE rule not found after network creation")
+	}
+
+	// Running the setup again must not stack a duplicate rule.
+	if err := setupIPTables(d.(*driver).network.bridge); err != nil {
+		t.Fatalf("Failed to setup IP tables again: %v", err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete bridge: %v", err)
+	}
+
+	if iptables.Exists(iptables.Nat, chain, natArgs...) || chainExists(iptables.Nat, chain) {
+		t.Fatal("MASQUERADE rule still present after network deletion")
+	}
+}
+
+func TestICCRule(t *testing.T) {
+	iccArgs := []string{"-i", DefaultBridgeName, "-o", DefaultBridgeName, "-j"}
+	acceptArgs := append(iccArgs, "ACCEPT")
+	dropArgs := append(iccArgs, "DROP")
+
+	for _, c := range []struct {
+		icc             bool
+		present, absent []string
+	}{
+		{true, acceptArgs, dropArgs},
+		{false, dropArgs, acceptArgs},
+	} {
+		func() {
+			defer netutils.SetupTestNetNS(t)()
+			_, d := New()
+
+			config := &Configuration{
+				BridgeName:     DefaultBridgeName,
+				EnableIPTables: true,
+				EnableICC:      c.icc,
+			}
+			if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+				t.Fatalf("Failed to create bridge: %v", err)
+			}
+
+			chain := networkChain(DefaultBridgeName)
+			if !iptables.Exists(iptables.Filter, chain, c.present...) {
+				t.Fatalf("ICC rule %v not found with EnableICC=%v", c.present, c.icc)
+			}
+			if iptables.Exists(iptables.Filter, chain, c.absent...) {
+				t.Fatalf("Unexpected ICC rule %v with EnableICC=%v", c.absent, c.icc)
+			}
+
+			if err := d.DeleteNetwork("dummy"); err != nil {
+				t.Fatalf("Failed to delete bridge: %v", err)
+			}
+
+			if chainExists(iptables.Filter, chain) {
+				t.Fatalf("ICC rule %v still present after network deletion", c.present)
+			}
+		}()
+	}
+}
+
+func TestNetworkChains(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// The simplebridge driver manages a single network, so use a driver per
+	// network. Only the default bridge gets created by the driver.
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "testbr1"}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	addr := &net.IPNet{IP: net.ParseIP("192.168.43.1"), Mask: net.CIDRMask(24, 32)}
+	if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: addr}); err != nil {
+		t.Fatalf("Failed to add address to bridge: %v", err)
+	}
+	var drivers []driverapi.Driver
+	for _, config := range []*Configuration{
+		{BridgeName: DefaultBridgeName, EnableIPTables: true, EnableIPMasquerade: true},
+		{
+			BridgeName:         "testbr1",
+			AddressIPv4:        addr,
+			AllowExisting:      true,
+			EnableIPTables:     true,
+			EnableIPMasquerade: true,
+		},
+	} {
+		_, d := New()
+		if err := d.CreateNetwork(context.Background(), driverapi.UUID(config.BridgeName), config); err != nil {
+			t.Fatalf("Failed to create network on %s: %v", config.BridgeName, err)
+		}
+		drivers = append(drivers, d)
+	}
+	defer drivers[1].DeleteNetwork("testbr1")
+
+	for _, name := range []string{DefaultBridgeName, "testbr1"} {
+		chain := networkChain(name)
+		for _, jump := range []iptRule{jumpRule(iptables.Filter, "FORWARD", chain), jumpRule(iptables.Nat, "POSTROUTING", chain)} {
+			if !iptables.Exists(jump.table, jump.chain, jump.args...) {
+				t.Fatalf("No jump from %s to %s in table %s", jump.chain, chain, jump.table)
+			}
+		}
+	}
+
+	if err := drivers[0].DeleteNetwork(DefaultBridgeName); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+	for _, table := range []iptables.Table{iptables.Filter, iptables.Nat} {
+		if chainExists(table, networkChain(DefaultBridgeName)) {
+			t.Fatalf("Chain of the deleted network still present in table %s", table)
+		}
+		if !chainExists(table, networkChain("testbr1")) {
+			t.Fatalf("Chain of the remaining network removed from table %s", table)
+		}
+	}
+	outArgs := []string{"-i", "testbr1", "!", "-o", "testbr1", "-j", "ACCEPT"}
+	if !iptables.Exists(iptables.Filter, networkChain("testbr1"), outArgs...) {
+		t.Fatal("Rules of the remaining network removed")
+	}
+}
+
 func getBasicTestConfig() *bridgeInterface {
 	return &bridgeInterface{
 		Config: &Configuration{
 			BridgeName:  DefaultBridgeName,
 			AddressIPv4: &net.IPNet{IP: net.ParseIP(iptablesTestBridgeIP), Mask: net.CIDRMask(16, 32)},
 		},
+		logger: driverapi.NopLogger{},
 	}
 }
 
@@ -101,3 +242,105 @@ func assertBridgeConfig(br *bridgeInterface, t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestMasqueradeExclusions(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	excluded := &net.IPNet{IP: net.ParseIP("10.10.0.0").To4(), Mask: net.CIDRMask(16, 32)}
+	config := &Configuration{
+		BridgeName:           DefaultBridgeName,
+		AddressIPv4:          &net.IPNet{IP: net.ParseIP(iptablesTestBridgeIP), Mask: net.CIDRMask(16, 32)},
+		EnableIPTables:       true,
+		EnableIPMasquerade:   true,
+		MasqueradeExclusions: []*net.IPNet{excluded},
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	chain := networkChain(DefaultBridgeName)
+	output, err := iptables.Raw("-t", string(iptables.Nat), "-S", chain)
+	if err != nil {
+		t.Fatalf("Failed to list the rules of %s: %v", chain, err)
+	}
+	returnRule, masqRule := -1, -1
+	for i, line := range strings.Split(string(output), "\n") {
+		switch {
+		case strings.Contains(line, "-d "+excluded.String()) && strings.HasSuffix(line, "-j RETURN"):
+			returnRule = i
+		case strings.Contains(line, "! -o "+DefaultBridgeName) && strings.HasSuffix(line, "-j MASQUERADE"):
+			masqRule = i
+		}
+	}
+	if returnRule == -1 || masqRule == -1 || returnRule > masqRule {
+		t.Fatalf("Expected a RETURN rule for %s before the MASQUERADE rule, got:\n%s", excluded, output)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete bridge: %v", err)
+	}
+	if chainExists(iptables.Nat, chain) {
+		t.Fatal("Masquerade exclusions still present after network deletion")
+	}
+}
+
+func TestMasqueradeExclusionsWithoutMasquerade(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	config := &Configuration{
+		BridgeName:           DefaultBridgeName,
+		EnableIPTables:       true,
+		MasqueradeExclusions: []*net.IPNet{{IP: net.ParseIP("10.10.0.0"), Mask: net.CIDRMask(16, 32)}},
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
+		t.Fatal("Expected an error setting masquerade exclusions without IP masquerading")
+	}
+}
+
+func TestSecondaryIPv4Masquerade(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	excluded := &net.IPNet{IP: net.ParseIP("10.10.0.0").To4(), Mask: net.CIDRMask(16, 32)}
+	secondary := &net.IPNet{IP: net.ParseIP("192.168.43.1").To4(), Mask: net.CIDRMask(24, 32)}
+	config := &Configuration{
+		BridgeName:             DefaultBridgeName,
+		AddressIPv4:            &net.IPNet{IP: net.ParseIP("192.168.42.1").To4(), Mask: net.CIDRMask(24, 32)},
+		SecondaryAddressesIPv4: []*net.IPNet{secondary},
+		EnableIPTables:         true,
+		EnableIPMasquerade:     true,
+		MasqueradeExclusions:   []*net.IPNet{excluded},
+	}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	chain := networkChain(DefaultBridgeName)
+	natRule, _, _, _ := networkRules(DefaultBridgeName, secondary)
+	if !iptables.Exists(natRule.table, natRule.chain, natRule.args...) {
+		t.Fatalf("MASQUERADE rule for the secondary subnet %s not found", secondary)
+	}
+	if rule := masqueradeExclusionRule(DefaultBridgeName, secondary, excluded); !iptables.Exists(rule.table, rule.chain, rule.args...) {
+		t.Fatalf("MASQUERADE exclusion rule for the secondary subnet %s not found", secondary)
+	}
+	if err := checkIPTables(d.(*driver).network.bridge); err != nil {
+		t.Fatal(err)
+	}
+
+	// Losing the rule of the secondary subnet must not go unnoticed.
+	if err := programChainRule(natRule, "NAT", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkIPTables(d.(*driver).network.bridge); err == nil {
+		t.Fatal("Expected the missing MASQUERADE rule of the secondary subnet to be reported")
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete bridge: %v", err)
+	}
+	if chainExists(iptables.Nat, chain) {
+		t.Fatal("MASQUERADE rules still present after network deletion")
+	}
+}
@@ -1,10 +1,12 @@
 package bridge
 
 import (
+	"errors"
 	"fmt"
 	"net"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
 )
@@ -48,18 +50,46 @@ func setupBridgeIPv4(i *bridgeInterface) error {
 	}
 
 	log.Debugf("Creating bridge interface %q with network %s", i.Config.BridgeName, bridgeIPv4)
-	if err := netlink.AddrAdd(i.Link, &netlink.Addr{IPNet: bridgeIPv4}); err != nil {
+	if err := retryNetlink(func() error { return addrAdd(i.Link, &netlink.Addr{IPNet: bridgeIPv4}) }); err != nil {
 		return fmt.Errorf("Failed to add IPv4 address %s to bridge: %v", bridgeIPv4, err)
 	}
+	i.debug("added address", driverapi.Fields{"address": bridgeIPv4.String()})
 
 	i.bridgeIPv4 = bridgeIPv4
 
+	return setupSecondaryIPv4(i)
+}
+
+// setupSecondaryIPv4 adds the secondary addresses to the bridge. Their subnets
+// can't overlap with each other, nor with the primary one.
+func setupSecondaryIPv4(i *bridgeInterface) error {
+	for _, addr := range i.Config.SecondaryAddressesIPv4 {
+		for _, other := range i.bridgeIPv4Networks() {
+			if netutils.NetworkOverlaps(addr, other) {
+				return fmt.Errorf("secondary bridge network %s overlaps with bridge network %s", addr, other)
+			}
+		}
+		if err := netutils.CheckRouteOverlaps(addr); err != nil {
+			return fmt.Errorf("requested secondary bridge network %s: %v", addr, err)
+		}
+
+		log.Debugf("Adding secondary network %s to bridge interface %q", addr, i.Config.BridgeName)
+		if err := retryNetlink(func() error { return addrAdd(i.Link, &netlink.Addr{IPNet: addr}) }); err != nil {
+			return fmt.Errorf("Failed to add IPv4 address %s to bridge: %v", addr, err)
+		}
+		i.debug("added address", driverapi.Fields{"address": addr.String()})
+		i.secondaryIPv4 = append(i.secondaryIPv4, addr)
+	}
 	return nil
 }
 
 func electBridgeIPv4(config *Configuration) (*net.IPNet, error) {
-	// Use the requested IPv4 CIDR when available.
+	// Use the requested IPv4 CIDR when available, as long as it doesn't
+	// overlap with a network already routed on the host.
 	if config.AddressIPv4 != nil {
+		if err := netutils.CheckRouteOverlaps(config.AddressIPv4); err != nil {
+			return nil, fmt.Errorf("requested bridge network %s: %v", config.AddressIPv4, err)
+		}
 		return config.AddressIPv4, nil
 	}
 
@@ -72,6 +102,17 @@ func electBridgeIPv4(config *Configuration) (*net.IPNet, error) {
 	}
 
 	// Try to automatically elect appropriate brige IPv4 settings.
+	n, err := findAvailableNetwork(nameservers)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't find an address range for interface %q: %v", config.BridgeName, err)
+	}
+	return n, nil
+}
+
+// findAvailableNetwork returns the first of the candidate bridge networks
+// which overlaps neither with the nameservers nor with the networks routed on
+// the host, including the ones of other bridges.
+func findAvailableNetwork(nameservers []string) (*net.IPNet, error) {
 	for _, n := range bridgeNetworks {
 		if err := netutils.CheckNameserverOverlaps(nameservers, n); err == nil {
 			if err := netutils.CheckRouteOverlaps(n); err == nil {
@@ -80,5 +121,15 @@ func electBridgeIPv4(config *Configuration) (*net.IPNet, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("'t find an address range for interface %q", config.BridgeName)
+	// Fall back to a random private network, with the bridge on its first
+	// address.
+	n, err := netutils.GenerateRandomCIDR(24)
+	if err != nil {
+		return nil, fmt.Errorf("all candidate networks overlap with existing routes or nameservers: %v", err)
+	}
+	if err := netutils.CheckNameserverOverlaps(nameservers, n); err != nil {
+		return nil, errors.New("all candidate networks overlap with existing routes or nameservers")
+	}
+	n.IP[len(n.IP)-1] = 1
+	return n, nil
 }
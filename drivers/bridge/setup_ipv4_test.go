@@ -4,6 +4,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
 )
@@ -13,6 +14,7 @@ func setupTestInterface(t *testing.T) *bridgeInterface {
 		Config: &Configuration{
 			BridgeName: DefaultBridgeName,
 		},
+		logger: driverapi.NopLogger{},
 	}
 	if err := setupDevice(br); err != nil {
 		t.Fatalf("Bridge creation failed: %v", err)
@@ -77,3 +79,97 @@ func TestSetupBridgeIPv4Auto(t *testing.T) {
 		t.Fatalf("Bridge device does not have the automatic IPv4 address %v", bridgeNetworks[0].String())
 	}
 }
+
+func TestSetupBridgeIPv4Overlap(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// Route 10.10.0.0/16 through an interface created outside of the driver.
+	setupTestRoute(t, "veth0", "10.10.0.1/16")
+
+	for _, c := range []struct {
+		address string
+		overlap bool
+	}{
+		{"10.10.1.1/24", true},  // contained
+		{"10.0.0.1/8", true},    // containing
+		{"10.10.42.1/16", true}, // same network
+		{"10.20.0.1/16", false}, // disjoint
+	} {
+		ip, netw, _ := net.ParseCIDR(c.address)
+		config := &Configuration{
+			BridgeName:  DefaultBridgeName,
+			AddressIPv4: &net.IPNet{IP: ip, Mask: netw.Mask},
+		}
+		_, err := electBridgeIPv4(config)
+		if c.overlap && err == nil {
+			t.Fatalf("Bridge network %s was expected to overlap", c.address)
+		}
+		if !c.overlap && err != nil {
+			t.Fatalf("Bridge network %s was not expected to overlap: %v", c.address, err)
+		}
+	}
+}
+
+func TestSetupBridgeIPv4AutoSkipsRoutedNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// Make the first candidate network unavailable.
+	setupTestRoute(t, "veth0", "172.17.0.1/16")
+
+	br := setupTestInterface(t)
+	if err := setupBridgeIPv4(br); err != nil {
+		t.Fatalf("Failed to setup bridge IPv4: %v", err)
+	}
+
+	if br.bridgeIPv4.String() != bridgeNetworks[1].String() {
+		t.Fatalf("Expected bridge network %v, got %v", bridgeNetworks[1], br.bridgeIPv4)
+	}
+}
+
+// setupTestRoute routes the network of address through a veth interface
+// created outside of the driver.
+func setupTestRoute(t *testing.T, name, address string) {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create veth pair: %v", err)
+	}
+	ip, netw, _ := net.ParseCIDR(address)
+	if err := netlink.AddrAdd(veth, &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: netw.Mask}}); err != nil {
+		t.Fatalf("Failed to add address: %v", err)
+	}
+	if err := netlink.LinkSetUp(veth); err != nil {
+		t.Fatalf("Failed to set link up: %v", err)
+	}
+}
+
+func TestSetupBridgeIPv4Secondary(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	br := setupTestInterface(t)
+	br.Config.AddressIPv4 = &net.IPNet{IP: net.ParseIP("192.168.1.1"), Mask: net.CIDRMask(24, 32)}
+	br.Config.SecondaryAddressesIPv4 = []*net.IPNet{{IP: net.ParseIP("192.168.2.1"), Mask: net.CIDRMask(24, 32)}}
+	if err := setupBridgeIPv4(br); err != nil {
+		t.Fatalf("Failed to setup bridge IPv4: %v", err)
+	}
+
+	addrsv4, err := netlink.AddrList(br.Link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("Failed to list device IPv4 addresses: %v", err)
+	}
+	for _, expected := range []*net.IPNet{br.Config.AddressIPv4, br.Config.SecondaryAddressesIPv4[0]} {
+		if !findAddress(netlink.Addr{IPNet: expected}, addrsv4) {
+			t.Fatalf("Bridge device does not have requested IPv4 address %v", expected)
+		}
+	}
+}
+
+func TestSetupBridgeIPv4SecondaryOverlap(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	br := setupTestInterface(t)
+	br.Config.AddressIPv4 = &net.IPNet{IP: net.ParseIP("192.168.1.1"), Mask: net.CIDRMask(24, 32)}
+	br.Config.SecondaryAddressesIPv4 = []*net.IPNet{{IP: net.ParseIP("192.168.1.129"), Mask: net.CIDRMask(25, 32)}}
+	if err := setupBridgeIPv4(br); err == nil {
+		t.Fatal("Expected an error with a secondary address overlapping the primary one")
+	}
+}
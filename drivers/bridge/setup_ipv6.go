@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"path/filepath"
 
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/vishvananda/netlink"
 )
 
@@ -12,6 +14,10 @@ var bridgeIPv6 *net.IPNet
 
 const bridgeIPv6Str = "fe80::1/64"
 
+// ipv6ConfDir holds the per-interface IPv6 settings, for the network namespace
+// of the calling thread.
+const ipv6ConfDir = "/proc/sys/net/ipv6/conf"
+
 func init() {
 	// We allow ourselves to panic in this special case because we indicate a
 	// failure to parse a compile-time define constant.
@@ -24,16 +30,39 @@ func init() {
 
 func setupBridgeIPv6(i *bridgeInterface) error {
 	// Enable IPv6 on the bridge
-	procFile := "/proc/sys/net/ipv6/conf/" + i.Config.BridgeName + "/disable_ipv6"
+	procFile := filepath.Join(ipv6ConfDir, i.Config.BridgeName, "disable_ipv6")
 	if err := ioutil.WriteFile(procFile, []byte{'0', '\n'}, 0644); err != nil {
 		return fmt.Errorf("Unable to enable IPv6 addresses on bridge: %v", err)
 	}
+	i.debug("enabled IPv6", nil)
 
-	if err := netlink.AddrAdd(i.Link, &netlink.Addr{IPNet: bridgeIPv6}); err != nil {
+	if err := retryNetlink(func() error { return addrAdd(i.Link, &netlink.Addr{IPNet: bridgeIPv6}) }); err != nil {
 		return fmt.Errorf("Failed to add IPv6 address %s to bridge: %v", bridgeIPv6, err)
 	}
+	i.debug("added address", driverapi.Fields{"address": bridgeIPv6.String()})
 
 	i.bridgeIPv6 = bridgeIPv6
 
+	// Assign the requested global IPv6 address, if any: endpoints are then
+	// allocated IPv6 addresses from its prefix instead of the link-local one.
+	if i.Config.AddressIPv6 != nil {
+		if err := retryNetlink(func() error { return addrAdd(i.Link, &netlink.Addr{IPNet: i.Config.AddressIPv6}) }); err != nil {
+			return fmt.Errorf("Failed to add IPv6 address %s to bridge: %v", i.Config.AddressIPv6, err)
+		}
+		i.debug("added address", driverapi.Fields{"address": i.Config.AddressIPv6.String()})
+		i.bridgeIPv6 = i.Config.AddressIPv6
+	}
+
+	return nil
+}
+
+// setupDisableBridgeIPv6 turns IPv6 off on the bridge, which drops its
+// link-local address and stops it from sending router solicitations.
+func setupDisableBridgeIPv6(i *bridgeInterface) error {
+	procFile := filepath.Join(ipv6ConfDir, i.Config.BridgeName, "disable_ipv6")
+	if err := ioutil.WriteFile(procFile, []byte{'1', '\n'}, 0644); err != nil {
+		return fmt.Errorf("failed to disable IPv6 on bridge %s: %v", i.Config.BridgeName, err)
+	}
+	i.debug("disabled IPv6", nil)
 	return nil
 }
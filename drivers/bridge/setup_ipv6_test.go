@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"path/filepath"
 	"testing"
 
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
 )
 
 func TestSetupIPv6(t *testing.T) {
@@ -45,3 +48,74 @@ func TestSetupIPv6(t *testing.T) {
 	}
 
 }
+
+func TestSetupBridgeIPv6Address(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	ip, netw, err := net.ParseCIDR("2001:db8:1::1/64")
+	if err != nil {
+		t.Fatalf("Failed to parse bridge IPv6: %v", err)
+	}
+
+	br := setupTestInterface(t)
+	br.Config.EnableIPv6 = true
+	br.Config.AddressIPv6 = &net.IPNet{IP: ip, Mask: netw.Mask}
+	if err := setupBridgeIPv6(br); err != nil {
+		t.Fatalf("Failed to setup bridge IPv6: %v", err)
+	}
+
+	addrsv6, err := netlink.AddrList(br.Link, netlink.FAMILY_V6)
+	if err != nil {
+		t.Fatalf("Failed to list device IPv6 addresses: %v", err)
+	}
+	if !findAddress(netlink.Addr{IPNet: br.Config.AddressIPv6}, addrsv6) {
+		t.Fatalf("Bridge device does not have requested IPv6 address %v", br.Config.AddressIPv6)
+	}
+
+	if ip, err := ipAllocator.RequestIP(br.bridgeIPv6, nil); err != nil {
+		t.Fatalf("Failed to request IPv6 to allocator: %v", err)
+	} else if !netw.Contains(ip) {
+		t.Fatalf("Allocated IPv6 %s is not in the bridge prefix %s", ip, netw)
+	}
+}
+
+func TestSetupDisableIPv6(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	_, d := New()
+	config := &Configuration{BridgeName: DefaultBridgeName, DisableIPv6: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	procSetting, err := ioutil.ReadFile(filepath.Join(ipv6ConfDir, DefaultBridgeName, "disable_ipv6"))
+	if err != nil {
+		t.Fatalf("Failed to read disable_ipv6 kernel setting: %v", err)
+	}
+	if expected := []byte("1\n"); !bytes.Equal(expected, procSetting) {
+		t.Fatalf("Invalid kernel setting disable_ipv6: expected %q, got %q", string(expected), string(procSetting))
+	}
+
+	link, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrsv6, err := netlink.AddrList(link, netlink.FAMILY_V6)
+	if err != nil {
+		t.Fatalf("Failed to list device IPv6 addresses: %v", err)
+	}
+	if len(addrsv6) != 0 {
+		t.Fatalf("Expected no IPv6 address on the bridge, got %v", addrsv6)
+	}
+}
+
+func TestSetupDisableIPv6Conflict(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	_, d := New()
+	config := &Configuration{BridgeName: DefaultBridgeName, DisableIPv6: true, EnableIPv6: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
+		t.Fatal("Expected IPv6 to be both enabled and disabled to fail")
+	}
+}
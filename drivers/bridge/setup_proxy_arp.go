@@ -0,0 +1,22 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// ipv4ConfDir holds the per-interface IPv4 settings, for the network namespace
+// of the calling thread.
+const ipv4ConfDir = "/proc/sys/net/ipv4/conf"
+
+// setupProxyARP makes the bridge answer ARP requests for the addresses it can
+// route to, such as the endpoint addresses in routed setups.
+func setupProxyARP(i *bridgeInterface) error {
+	proxyARP := filepath.Join(ipv4ConfDir, i.Config.BridgeName, "proxy_arp")
+	if err := ioutil.WriteFile(proxyARP, []byte{'1', '\n'}, ipv4ForwardConfPerm); err != nil {
+		return fmt.Errorf("failed to enable proxy ARP on bridge %s: %v", i.Config.BridgeName, err)
+	}
+	i.debug("enabled proxy ARP", nil)
+	return nil
+}
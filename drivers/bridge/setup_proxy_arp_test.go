@@ -0,0 +1,29 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+	"golang.org/x/net/context"
+)
+
+func TestSetupProxyARP(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	_, d := New()
+	config := &Configuration{BridgeName: DefaultBridgeName, EnableProxyARP: true}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	proxyARP, err := ioutil.ReadFile(filepath.Join(ipv4ConfDir, DefaultBridgeName, "proxy_arp"))
+	if err != nil {
+		t.Fatalf("Failed to read the proxy ARP setting: %v", err)
+	}
+	if string(proxyARP) != "1\n" {
+		t.Fatalf("Expected proxy ARP to be enabled, got %q", proxyARP)
+	}
+}
@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSetupCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var lastStep int
+	setup := newBridgeSetup(&bridgeInterface{})
+	setup.queueStep(func(*bridgeInterface) error {
+		lastStep = 1
+		return nil
+	})
+	setup.queueStep(func(*bridgeInterface) error {
+		lastStep = 2
+		cancel()
+		return nil
+	})
+	setup.queueStep(func(*bridgeInterface) error {
+		lastStep = 3
+		return nil
+	})
+
+	if err := setup.apply(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if lastStep != 2 {
+		t.Fatalf("Expected the setup to stop after step 2, last step run was %d", lastStep)
+	}
+}
@@ -0,0 +1,83 @@
+package bridge
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+// setupUplink enslaves the uplink interface to the bridge, giving the
+// endpoints direct access to the network it is connected to.
+func setupUplink(i *bridgeInterface) error {
+	uplink, err := netlink.LinkByName(i.Config.UplinkInterface)
+	if err != nil {
+		return fmt.Errorf("uplink interface %q not found: %v", i.Config.UplinkInterface, err)
+	}
+	if _, ok := uplink.(*netlink.Bridge); ok {
+		return fmt.Errorf("uplink interface %q is a bridge", i.Config.UplinkInterface)
+	}
+
+	// An adopted bridge may have the uplink enslaved already.
+	master := uplink.Attrs().MasterIndex
+	if master == i.Link.Attrs().Index {
+		return nil
+	}
+	if master != 0 {
+		return fmt.Errorf("uplink interface %q is already enslaved to interface index %d", i.Config.UplinkInterface, master)
+	}
+
+	if err := netlink.LinkSetMasterByIndex(uplink, i.Link.Attrs().Index); err != nil {
+		return fmt.Errorf("failed to attach uplink interface %q to bridge %s: %v", i.Config.UplinkInterface, i.Config.BridgeName, err)
+	}
+	i.debug("attached uplink", driverapi.Fields{"uplink": i.Config.UplinkInterface})
+	return netlink.LinkSetUp(uplink)
+}
+
+// releaseUplink detaches the uplink interface from the bridge, leaving the
+// interface itself in place. An uplink removed out of band is as good as
+// released.
+func releaseUplink(i *bridgeInterface) error {
+	uplink, err := linkByName(i.Config.UplinkInterface)
+	if _, ok := err.(linkNotFoundError); ok {
+		log.Warnf("Uplink interface %q of bridge %s is already gone", i.Config.UplinkInterface, i.Config.BridgeName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find uplink interface %q: %v", i.Config.UplinkInterface, err)
+	}
+	if uplink.Attrs().MasterIndex != i.Link.Attrs().Index {
+		return nil
+	}
+	if err := netlink.LinkSetMasterByIndex(uplink, 0); err != nil {
+		return err
+	}
+	i.debug("released uplink", driverapi.Fields{"uplink": i.Config.UplinkInterface})
+	return nil
+}
+
+// checkUplinkMTU verifies that the MTU requested for the bridge doesn't exceed
+// the one of the uplink interface, which would silently drop the larger
+// frames. The mismatch is only an error with StrictMTU.
+func checkUplinkMTU(config *Configuration) error {
+	uplinkMTU, err := netutils.InterfaceMTU(config.UplinkInterface)
+	if err != nil {
+		return fmt.Errorf("uplink interface %q not found: %v", config.UplinkInterface, err)
+	}
+
+	mtu := config.Mtu
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	if mtu <= uplinkMTU {
+		return nil
+	}
+
+	if config.StrictMTU {
+		return fmt.Errorf("MTU %d of bridge %s exceeds MTU %d of uplink interface %q", mtu, config.BridgeName, uplinkMTU, config.UplinkInterface)
+	}
+	log.Warnf("MTU %d of bridge %s exceeds MTU %d of uplink interface %q, larger frames will be dropped", mtu, config.BridgeName, uplinkMTU, config.UplinkInterface)
+	return nil
+}
@@ -0,0 +1,131 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+)
+
+func masterIndex(t *testing.T, name string) int {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatalf("Failed to find %s: %v", name, err)
+	}
+	return link.Attrs().MasterIndex
+}
+
+func TestSetupUplink(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create veth pair: %v", err)
+	}
+
+	_, d := New()
+	config := &Configuration{BridgeName: DefaultBridgeName, UplinkInterface: "uplink0"}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	bridge, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatalf("Failed to find bridge: %v", err)
+	}
+	if idx := masterIndex(t, "uplink0"); idx != bridge.Attrs().Index {
+		t.Fatalf("Expected uplink0 enslaved to %s, got master index %d", DefaultBridgeName, idx)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+	if idx := masterIndex(t, "uplink0"); idx != 0 {
+		t.Fatalf("Expected uplink0 released on deletion, got master index %d", idx)
+	}
+}
+
+func TestDeleteNetworkMissingUplink(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create veth pair: %v", err)
+	}
+
+	_, d := New()
+	config := &Configuration{BridgeName: DefaultBridgeName, UplinkInterface: "uplink0"}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// Remove the uplink out of band.
+	if err := netlink.LinkDel(veth); err != nil {
+		t.Fatalf("Failed to delete uplink0: %v", err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Deleting a network whose uplink is gone failed: %v", err)
+	}
+	if _, err := netlink.LinkByName(DefaultBridgeName); err == nil {
+		t.Fatal("Expected the bridge to be deleted")
+	}
+}
+
+func TestSetupUplinkInvalid(t *testing.T) {
+	for _, uplink := range []string{"nosuchlink", "otherbr0", "uplink0"} {
+		func() {
+			defer netutils.SetupTestNetNS(t)()
+
+			other := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "otherbr0"}}
+			if err := netlink.LinkAdd(other); err != nil {
+				t.Fatalf("Failed to create bridge: %v", err)
+			}
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}
+			if err := netlink.LinkAdd(veth); err != nil {
+				t.Fatalf("Failed to create veth pair: %v", err)
+			}
+			if err := netlink.LinkSetMaster(veth, other); err != nil {
+				t.Fatalf("Failed to enslave uplink0: %v", err)
+			}
+
+			_, d := New()
+			config := &Configuration{BridgeName: DefaultBridgeName, UplinkInterface: uplink}
+			err := d.CreateNetwork(context.Background(), "dummy", config)
+			if err == nil || !strings.Contains(err.Error(), "uplink interface") {
+				t.Fatalf("Expected an uplink error with %s, got %v", uplink, err)
+			}
+		}()
+	}
+}
+
+func TestUplinkMTU(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		func() {
+			defer netutils.SetupTestNetNS(t)()
+
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0", MTU: 1400}, PeerName: "uplink1"}
+			if err := netlink.LinkAdd(veth); err != nil {
+				t.Fatalf("Failed to create veth pair: %v", err)
+			}
+
+			_, d := New()
+			config := &Configuration{BridgeName: DefaultBridgeName, UplinkInterface: "uplink0", Mtu: 1500, StrictMTU: strict}
+			err := d.CreateNetwork(context.Background(), "dummy", config)
+			if strict {
+				if err == nil || !strings.Contains(err.Error(), "exceeds MTU 1400") {
+					t.Fatalf("Expected an MTU mismatch error, got %v", err)
+				}
+				if _, err := netlink.LinkByName(DefaultBridgeName); err == nil {
+					t.Fatal("Expected no bridge to be created on an MTU mismatch")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected only a warning without StrictMTU, got %v", err)
+			}
+		}()
+	}
+}
@@ -13,28 +13,58 @@ func setupVerifyConfiguredAddresses(i *bridgeInterface) error {
 		return err
 	}
 
-	// Verify that the bridge does have an IPv4 address.
-	if addrv4.IPNet == nil {
-		return fmt.Errorf("Bridge has no IPv4 address configured")
-	}
+	// Verify that the bridge has an IPv4 address exactly when expected.
+	if i.Config.DisableBridgeIPv4 {
+		if addrv4.IPNet != nil {
+			return fmt.Errorf("Bridge has IPv4 address %s configured, but IPv4 is disabled", addrv4.IPNet)
+		}
+	} else {
+		if addrv4.IPNet == nil {
+			return fmt.Errorf("Bridge has no IPv4 address configured")
+		}
+
+		// Verify that the bridge IPv4 address matches the requested configuration.
+		if i.Config.AddressIPv4 != nil && !addrv4.IP.Equal(i.Config.AddressIPv4.IP) {
+			return fmt.Errorf("Bridge IPv4 (%s) does not match requested configuration %s", addrv4.IP, i.Config.AddressIPv4.IP)
+		}
 
-	// Verify that the bridge IPv4 address matches the requested configuration.
-	if i.Config.AddressIPv4 != nil && !addrv4.IP.Equal(i.Config.AddressIPv4.IP) {
-		return fmt.Errorf("Bridge IPv4 (%s) does not match requested configuration %s", addrv4.IP, i.Config.AddressIPv4.IP)
+		// Endpoints of an existing bridge get their addresses in its network.
+		i.bridgeIPv4 = addrv4.IPNet
+
+		// Verify that the requested secondary addresses are assigned too.
+		if len(i.Config.SecondaryAddressesIPv4) != 0 {
+			addrsv4, err := netlink.AddrList(i.Link, netlink.FAMILY_V4)
+			if err != nil {
+				return err
+			}
+			for _, addr := range i.Config.SecondaryAddressesIPv4 {
+				if !findAddress(netlink.Addr{IPNet: addr}, addrsv4) {
+					return fmt.Errorf("Bridge IPv4 addresses do not match the requested secondary address %s", addr)
+				}
+			}
+			i.secondaryIPv4 = i.Config.SecondaryAddressesIPv4
+		}
 	}
 
 	// Verify that one of the bridge IPv6 addresses matches the requested
 	// configuration.
-	if i.Config.EnableIPv6 && !findIPv6Address(netlink.Addr{IPNet: bridgeIPv6}, addrsv6) {
+	if i.Config.EnableIPv6 && !findAddress(netlink.Addr{IPNet: bridgeIPv6}, addrsv6) {
 		return fmt.Errorf("Bridge IPv6 addresses do not match the expected bridge configuration %s", bridgeIPv6)
 	}
 
+	// Verify that the requested global IPv6 address is assigned as well.
+	if i.Config.EnableIPv6 && i.Config.AddressIPv6 != nil && !findAddress(netlink.Addr{IPNet: i.Config.AddressIPv6}, addrsv6) {
+		return fmt.Errorf("Bridge IPv6 addresses do not match the requested configuration %s", i.Config.AddressIPv6)
+	}
+
 	return nil
 }
 
-func findIPv6Address(addr netlink.Addr, addresses []netlink.Addr) bool {
-	for _, addrv6 := range addresses {
-		if addrv6.String() == addr.String() {
+// findAddress tells whether addr is among addresses, regardless of their
+// labels.
+func findAddress(addr netlink.Addr, addresses []netlink.Addr) bool {
+	for _, a := range addresses {
+		if a.IPNet.String() == addr.IPNet.String() {
 			return true
 		}
 	}
@@ -4,12 +4,13 @@ import (
 	"net"
 	"testing"
 
+	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
 )
 
 func setupVerifyTest(t *testing.T) *bridgeInterface {
-	inf := &bridgeInterface{Config: &Configuration{}}
+	inf := &bridgeInterface{Config: &Configuration{}, logger: driverapi.NopLogger{}}
 
 	br := netlink.Bridge{}
 	br.LinkAttrs.Name = "default0"
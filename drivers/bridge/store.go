@@ -0,0 +1,312 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"golang.org/x/net/context"
+)
+
+// allocationsKeyPrefix is where the address allocations of the networks get
+// persisted in the datastore, keyed by network id.
+const allocationsKeyPrefix = networkType + "/allocations/"
+
+// networksKeyPrefix is where the configuration and the endpoint of the
+// networks get persisted in the datastore, keyed by network id.
+const networksKeyPrefix = networkType + "/networks/"
+
+// networkRecord is the persisted form of a network, from which it gets
+// re-created, or its bridge adopted, on restore.
+type networkRecord struct {
+	Config   *Configuration
+	Endpoint *endpointRecord
+}
+
+// endpointRecord is the persisted form of the endpoint of a network.
+type endpointRecord struct {
+	ID           driverapi.UUID
+	HostIfName   string
+	AddressIPv4  net.IP
+	AddressIPv6  net.IP
+	SandboxKey   string
+	SandboxInfo  *driverapi.SandboxInfo
+	PortBindings []driverapi.PortBinding
+	Adopted      bool
+	PointToPoint *net.IPNet
+	VethSettings *vethSettings
+}
+
+// stateIPAM is implemented by the address managers whose allocations can be
+// persisted, such as ipallocator.IPAllocator.
+type stateIPAM interface {
+	ExportState(network *net.IPNet) (*ipallocator.NetworkState, error)
+	ImportState(state *ipallocator.NetworkState, inUse []net.IP) error
+}
+
+// utilizationIPAM is implemented by the address managers which can report the
+// address usage of their pools, such as ipallocator.IPAllocator.
+type utilizationIPAM interface {
+	Utilization(network *net.IPNet) (used, total int)
+}
+
+func allocationsKey(nid driverapi.UUID) string {
+	return allocationsKeyPrefix + string(nid)
+}
+
+func networkKey(nid driverapi.UUID) string {
+	return networksKeyPrefix + string(nid)
+}
+
+// SetStore makes the driver persist the address allocations of its network to
+// store, so that they survive restarts.
+func (d *driver) SetStore(store datastore.DataStore) {
+	d.Lock()
+	defer d.Unlock()
+	d.store = store
+}
+
+// getStore returns the datastore the driver persists its networks to, nil if
+// none.
+func (d *driver) getStore() datastore.DataStore {
+	d.Lock()
+	defer d.Unlock()
+	return d.store
+}
+
+// stateStore returns the datastore and the address manager to persist the
+// allocations with, or nils if they can't be.
+func (d *driver) stateStore() (datastore.DataStore, stateIPAM) {
+	d.Lock()
+	defer d.Unlock()
+	ipam, ok := d.ipam.(stateIPAM)
+	if d.store == nil || !ok {
+		return nil, nil
+	}
+	return d.store, ipam
+}
+
+// allocationPools returns the pools the addresses of the bridge and its
+// endpoints are allocated from.
+func allocationPools(i *bridgeInterface) []*net.IPNet {
+	pools := append([]*net.IPNet(nil), i.ipv4Ranges...)
+	pools = append(pools, i.bridgeIPv4Networks()...)
+	if i.bridgeIPv6 != nil {
+		pools = append(pools, i.bridgeIPv6)
+	}
+	if i.Config.FixedCIDRv6 != nil {
+		pools = append(pools, i.Config.FixedCIDRv6)
+	}
+	return pools
+}
+
+// storeAllocations persists the allocations of the network pools. Failures
+// are only logged, as the allocations remain valid until a restart.
+func (d *driver) storeAllocations(n *bridgeNetwork) {
+	store, ipam := d.stateStore()
+	if store == nil {
+		return
+	}
+
+	var states []*ipallocator.NetworkState
+	for _, pool := range allocationPools(n.bridge) {
+		state, err := ipam.ExportState(pool)
+		if err == ipallocator.ErrNetworkNotRegistered {
+			// Nothing got allocated from the pool yet.
+			continue
+		}
+		if err != nil {
+			log.Warnf("Failed to export the allocations of pool %s of network %s: %v", pool, n.id, err)
+			return
+		}
+		states = append(states, state)
+	}
+
+	value, err := json.Marshal(states)
+	if err == nil {
+		err = store.Put(allocationsKey(n.id), value)
+	}
+	if err != nil {
+		log.Warnf("Failed to persist the allocations of network %s: %v", n.id, err)
+	}
+}
+
+func (d *driver) deleteStoredAllocations(nid driverapi.UUID) {
+	store, _ := d.stateStore()
+	if store == nil {
+		return
+	}
+	if err := store.Delete(allocationsKey(nid)); err != nil {
+		log.Warnf("Failed to remove the allocations of network %s from the store: %v", nid, err)
+	}
+}
+
+// storeNetwork persists the configuration and the endpoint of the network.
+// The elected bridge address is persisted as the configured one, for the
+// network to be re-created alike. Failures are only logged, as for the
+// allocations.
+func (d *driver) storeNetwork(n *bridgeNetwork) {
+	store := d.getStore()
+	if store == nil {
+		return
+	}
+
+	n.Lock()
+	config := *n.bridge.Config
+	if config.AddressIPv4 == nil {
+		config.AddressIPv4 = n.bridge.bridgeIPv4
+	}
+	record := &networkRecord{Config: &config}
+	// An endpoint being created has no sandbox info yet.
+	if ep := n.endpoint; ep != nil && ep.sandboxInfo != nil {
+		record.Endpoint = &endpointRecord{
+			ID:           ep.id,
+			HostIfName:   ep.hostIfName,
+			AddressIPv4:  ep.addressIPv4,
+			AddressIPv6:  ep.addressIPv6,
+			SandboxKey:   ep.sandboxKey,
+			SandboxInfo:  ep.sandboxInfo,
+			PortBindings: ep.portBindings,
+			Adopted:      ep.adopted,
+			PointToPoint: ep.pointToPoint,
+			VethSettings: ep.vethSettings,
+		}
+	}
+	n.Unlock()
+
+	value, err := json.Marshal(record)
+	if err == nil {
+		err = store.Put(networkKey(n.id), value)
+	}
+	if err != nil {
+		log.Warnf("Failed to persist network %s: %v", n.id, err)
+	}
+}
+
+func (d *driver) deleteStoredNetwork(nid driverapi.UUID) {
+	store := d.getStore()
+	if store == nil {
+		return
+	}
+	if err := store.Delete(networkKey(nid)); err != nil {
+		log.Warnf("Failed to remove network %s from the store: %v", nid, err)
+	}
+}
+
+// RestoreNetwork re-creates the network persisted in the store, adopting its
+// bridge if still there, along with its endpoint. It then reloads the address
+// allocations persisted for the network. The addresses of the endpoints are
+// accounted as allocated too, in case they got assigned after the
+// allocations were last persisted.
+func (d *driver) RestoreNetwork(nid driverapi.UUID, endpoints []*driverapi.SandboxInfo) error {
+	store := d.getStore()
+	if store == nil {
+		return nil
+	}
+
+	// Read the allocations before re-creating the network, which persists
+	// fresh ones.
+	var states []*ipallocator.NetworkState
+	value, err := store.Get(allocationsKey(nid))
+	if err != nil && err != datastore.ErrKeyNotFound {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(value, &states); err != nil {
+			return err
+		}
+	}
+
+	var record *networkRecord
+	value, err = store.Get(networkKey(nid))
+	if err != nil && err != datastore.ErrKeyNotFound {
+		return err
+	}
+	if err == nil {
+		record = &networkRecord{}
+		if err := json.Unmarshal(value, record); err != nil {
+			return err
+		}
+		config := *record.Config
+		config.AllowExisting = true
+		if err := d.CreateNetwork(context.Background(), nid, &config); err != nil {
+			return fmt.Errorf("failed to re-create network %s: %v", nid, err)
+		}
+	}
+
+	if err := d.restoreAllocations(states, endpoints); err != nil {
+		return err
+	}
+
+	if record != nil {
+		d.Lock()
+		n := d.network
+		d.Unlock()
+		if record.Endpoint != nil {
+			restoreEndpoint(n, record.Endpoint)
+		}
+		d.storeAllocations(n)
+		d.storeNetwork(n)
+	}
+	return nil
+}
+
+// restoreEndpoint makes the endpoint of a previous run the endpoint of the
+// restored network. Its port mappings went away with the previous run, and
+// are mapped again.
+func restoreEndpoint(n *bridgeNetwork, record *endpointRecord) {
+	ep := &bridgeEndpoint{
+		id:           record.ID,
+		hostIfName:   record.HostIfName,
+		addressIPv4:  record.AddressIPv4,
+		addressIPv6:  record.AddressIPv6,
+		sandboxKey:   record.SandboxKey,
+		sandboxInfo:  record.SandboxInfo,
+		adopted:      record.Adopted,
+		pointToPoint: record.PointToPoint,
+		vethSettings: record.VethSettings,
+	}
+	if len(record.PortBindings) != 0 {
+		bindings, err := allocatePorts(record.PortBindings, record.AddressIPv4)
+		if err != nil {
+			log.Warnf("Failed to map the ports of restored endpoint %s again: %v", record.ID, err)
+		} else {
+			ep.portBindings = bindings
+		}
+	}
+
+	n.Lock()
+	n.endpoint = ep
+	n.Unlock()
+}
+
+// restoreAllocations imports the allocations read from the store, with the
+// addresses of endpoints accounted as allocated.
+func (d *driver) restoreAllocations(states []*ipallocator.NetworkState, endpoints []*driverapi.SandboxInfo) error {
+	_, ipam := d.stateStore()
+	if ipam == nil || len(states) == 0 {
+		return nil
+	}
+
+	var inUse []net.IP
+	for _, sinfo := range endpoints {
+		for _, intf := range sinfo.Interfaces {
+			for _, addr := range []string{intf.Address, intf.AddressIPv6} {
+				if ip, _, err := net.ParseCIDR(addr); err == nil {
+					inUse = append(inUse, ip)
+				}
+			}
+		}
+	}
+
+	for _, state := range states {
+		if err := ipam.ImportState(state, inUse); err != nil {
+			return err
+		}
+	}
+	return nil
+}
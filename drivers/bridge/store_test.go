@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/netutils"
+	"golang.org/x/net/context"
+)
+
+func TestRestoreAllocations(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	dir, err := ioutil.TempDir("", "bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := datastore.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, d := NewWithIPAM(ipallocator.New())
+	d.(driverapi.StoreUser).SetStore(store)
+
+	_, bridgeNet, _ := net.ParseCIDR("10.221.0.1/24")
+	bridgeNet.IP = net.ParseIP("10.221.0.1")
+	config := &Configuration{BridgeName: DefaultBridgeName, AddressIPv4: bridgeNet}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	ip, _, _ := net.ParseCIDR(sinfo.Interfaces[0].Address)
+
+	// A restarted driver starts off with an empty allocator.
+	allocator := ipallocator.New()
+	_, restarted := NewWithIPAM(allocator)
+	restarted.(driverapi.StoreUser).SetStore(store)
+	inUse := &driverapi.SandboxInfo{Interfaces: []*driverapi.Interface{{Address: "10.221.0.100/24"}}}
+	if err := restarted.(driverapi.StoreUser).RestoreNetwork("dummy", []*driverapi.SandboxInfo{inUse}); err != nil {
+		t.Fatalf("Failed to restore the network: %v", err)
+	}
+
+	for _, addr := range []net.IP{ip, net.ParseIP("10.221.0.100")} {
+		if _, err := allocator.RequestIP(bridgeNet, addr); err != ipallocator.ErrIPAlreadyAllocated {
+			t.Fatalf("Expected %s to be allocated after the restore, got %v", addr, err)
+		}
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+	if _, err := store.Get(allocationsKey("dummy")); err != datastore.ErrKeyNotFound {
+		t.Fatalf("Expected the allocations to be removed from the store, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package host
+
+import (
+	"errors"
+
+	"github.com/docker/libnetwork/driverapi"
+	"golang.org/x/net/context"
+)
+
+const networkType = "host"
+
+type driver struct{}
+
+// New provides a new instance of host driver
+func New() (string, driverapi.Driver) {
+	return networkType, &driver{}
+}
+
+// CreateNetwork is a no-op, endpoints of a host network share the host
+// network stack.
+func (d *driver) CreateNetwork(ctx context.Context, id driverapi.UUID, option interface{}) error {
+	return nil
+}
+
+func (d *driver) DeleteNetwork(nid driverapi.UUID) error {
+	return nil
+}
+
+// CreateEndpoint returns a SandboxInfo without interfaces, as containers on a
+// host network don't get a network namespace of their own.
+func (d *driver) CreateEndpoint(ctx context.Context, nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+	return &driverapi.SandboxInfo{}, nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid driverapi.UUID) error {
+	return nil
+}
+
+func (d *driver) Join(nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+	return &driverapi.SandboxInfo{}, nil
+}
+
+func (d *driver) Leave(nid, eid driverapi.UUID, sboxKey string) error {
+	return nil
+}
+
+func (d *driver) Capabilities() driverapi.Capability {
+	return driverapi.Capability{MultipleNetworks: true}
+}
+
+func (d *driver) NetworkInfo(nid driverapi.UUID) (*driverapi.NetworkInfo, error) {
+	return &driverapi.NetworkInfo{}, nil
+}
+
+func (d *driver) EndpointStatistics(nid, eid driverapi.UUID) (*driverapi.InterfaceStatistics, error) {
+	return nil, errors.New("host endpoints have no interface of their own")
+}
+
+// CheckNetwork always succeeds, host networks have no resources of their own.
+func (d *driver) CheckNetwork(nid driverapi.UUID) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package host
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDriver(t *testing.T) {
+	name, d := New()
+	if name != networkType {
+		t.Fatalf("Unexpected network type %q", name)
+	}
+
+	if err := d.CreateNetwork(context.Background(), "dummy", nil); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	if len(sinfo.Interfaces) != 0 {
+		t.Fatalf("Expected no interfaces, got %v", sinfo.Interfaces)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package ipvlan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/pkg/options"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+)
+
+const (
+	networkType = "ipvlan"
+	ifacePrefix = "ipvl"
+)
+
+var ipAllocator = ipallocator.New()
+
+// Configuration info for the "ipvlan" driver.
+//
+// When passed to CreateNetwork as an options.Generic, the keys of the map are
+// the names of the fields below, and each value must be of the field's type.
+type Configuration struct {
+	Parent  string     // Name of the host interface the ipvlan links hang off, required.
+	Subnet  *net.IPNet // Network endpoint addresses are allocated from, required.
+	Gateway net.IP     // Default gateway of the endpoints, none if nil.
+}
+
+type ipvlanEndpoint struct {
+	id          driverapi.UUID
+	ifName      string
+	address     net.IP
+	sandboxKey  string
+	sandboxInfo *driverapi.SandboxInfo
+}
+
+type ipvlanNetwork struct {
+	id        driverapi.UUID
+	config    *Configuration
+	endpoints map[driverapi.UUID]*ipvlanEndpoint
+	sync.Mutex
+}
+
+type driver struct {
+	networks map[driverapi.UUID]*ipvlanNetwork
+	sync.Mutex
+}
+
+// New provides a new instance of ipvlan driver
+func New() (string, driverapi.Driver) {
+	return networkType, &driver{networks: make(map[driverapi.UUID]*ipvlanNetwork)}
+}
+
+func parseNetworkOptions(option interface{}) (*Configuration, error) {
+	switch opt := option.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &Configuration{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate driver config: %v", err)
+		}
+		return opaqueConfig.(*Configuration), nil
+	case *Configuration:
+		if opt != nil {
+			return opt, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid driver config type %T", option)
+}
+
+// CreateNetwork creates a network of ipvlan links in L2 mode off the parent
+// interface, which must exist.
+func (d *driver) CreateNetwork(ctx context.Context, id driverapi.UUID, option interface{}) error {
+	config, err := parseNetworkOptions(option)
+	if err != nil {
+		return err
+	}
+	if config.Subnet == nil {
+		return errors.New("ipvlan network requires a subnet")
+	}
+	if config.Gateway != nil && !config.Subnet.Contains(config.Gateway) {
+		return fmt.Errorf("gateway %s is not within subnet %s", config.Gateway, config.Subnet)
+	}
+	if _, err := netlink.LinkByName(config.Parent); err != nil {
+		return fmt.Errorf("invalid parent interface %q: %v", config.Parent, err)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.networks[id]; ok {
+		return fmt.Errorf("network %s already exists", id)
+	}
+
+	// The gateway address is never handed out to an endpoint.
+	if config.Gateway != nil {
+		if _, err := ipAllocator.RequestIP(config.Subnet, config.Gateway); err != nil {
+			return fmt.Errorf("failed to reserve gateway %s: %v", config.Gateway, err)
+		}
+	}
+
+	d.networks[id] = &ipvlanNetwork{id: id, config: config, endpoints: make(map[driverapi.UUID]*ipvlanEndpoint)}
+	return nil
+}
+
+func (d *driver) DeleteNetwork(nid driverapi.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+	n, ok := d.networks[nid]
+	if !ok {
+		return driverapi.ErrNoNetwork
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if len(n.endpoints) != 0 {
+		return fmt.Errorf("network %s has %d active endpoints", nid, len(n.endpoints))
+	}
+
+	if n.config.Gateway != nil {
+		ipAllocator.ReleaseIP(n.config.Subnet, n.config.Gateway)
+	}
+	delete(d.networks, nid)
+	return nil
+}
+
+// CreateEndpoint creates an ipvlan link off the parent interface, sharing its
+// MAC address, with an address allocated from the network subnet.
+func (d *driver) CreateEndpoint(ctx context.Context, nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+	var err error
+
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	if _, ok := n.endpoints[eid]; ok {
+		n.Unlock()
+		return nil, driverapi.ErrEndpointExists
+	}
+	ep := &ipvlanEndpoint{id: eid}
+	n.endpoints[eid] = ep
+	n.Unlock()
+	defer func() {
+		if err != nil {
+			n.Lock()
+			delete(n.endpoints, eid)
+			n.Unlock()
+		}
+	}()
+
+	parent, err := netlink.LinkByName(n.config.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parent interface %q: %v", n.config.Parent, err)
+	}
+
+	name, err := generateIfaceName()
+	if err != nil {
+		return nil, err
+	}
+
+	link := &netlink.IPVlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Attrs().Index},
+		Mode:      netlink.IPVLAN_MODE_L2,
+	}
+	if err = netlink.LinkAdd(link); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			netlink.LinkDel(link)
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ip, err := ipAllocator.RequestIP(n.config.Subnet, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	intf := &driverapi.Interface{
+		SrcName: name,
+		DstName: "eth0",
+		Address: (&net.IPNet{IP: ip, Mask: n.config.Subnet.Mask}).String(),
+	}
+	sinfo := &driverapi.SandboxInfo{Interfaces: []*driverapi.Interface{intf}}
+	if n.config.Gateway != nil {
+		sinfo.Gateway = n.config.Gateway.String()
+	}
+
+	n.Lock()
+	ep.ifName = name
+	ep.address = ip
+	ep.sandboxKey = sboxKey
+	ep.sandboxInfo = sinfo
+	n.Unlock()
+	return sinfo, nil
+}
+
+func (d *driver) DeleteEndpoint(nid, eid driverapi.UUID) error {
+	n, ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return err
+	}
+
+	// Once moved to a sandbox, the link goes away with its namespace.
+	if link, err := netlink.LinkByName(ep.ifName); err == nil {
+		if err := netlink.LinkDel(link); err != nil {
+			return err
+		}
+	}
+
+	if err := ipAllocator.ReleaseIP(n.config.Subnet, ep.address); err != nil {
+		return err
+	}
+
+	n.Lock()
+	delete(n.endpoints, eid)
+	n.Unlock()
+	return nil
+}
+
+// Join associates the endpoint with a sandbox. An ipvlan link can only live in
+// one network namespace.
+func (d *driver) Join(nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+	n, ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if ep.sandboxKey != "" && ep.sandboxKey != sboxKey {
+		return nil, fmt.Errorf("endpoint %s is already joined to sandbox %s", eid, ep.sandboxKey)
+	}
+	ep.sandboxKey = sboxKey
+
+	return ep.sandboxInfo, nil
+}
+
+func (d *driver) Leave(nid, eid driverapi.UUID, sboxKey string) error {
+	n, ep, err := d.getEndpoint(nid, eid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if ep.sandboxKey != sboxKey {
+		return driverapi.ErrNotJoined
+	}
+	ep.sandboxKey = ""
+
+	return nil
+}
+
+// Capabilities returns the features of the ipvlan driver, which can manage
+// several networks, possibly off the same parent interface.
+func (d *driver) Capabilities() driverapi.Capability {
+	return driverapi.Capability{MultipleNetworks: true}
+}
+
+// NetworkInfo returns the subnet and gateway the network was configured with,
+// along with the address usage of the subnet.
+func (d *driver) NetworkInfo(nid driverapi.UUID) (*driverapi.NetworkInfo, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+	used, total := ipAllocator.Utilization(n.config.Subnet)
+	return &driverapi.NetworkInfo{
+		Subnet:      n.config.Subnet,
+		Gateway:     n.config.Gateway,
+		Utilization: []driverapi.SubnetUtilization{{Subnet: n.config.Subnet, Used: used, Total: total}},
+	}, nil
+}
+
+// EndpointStatistics fails, as the ipvlan link of an endpoint has no peer left
+// in the host namespace once moved to the sandbox.
+func (d *driver) EndpointStatistics(nid, eid driverapi.UUID) (*driverapi.InterfaceStatistics, error) {
+	if _, _, err := d.getEndpoint(nid, eid); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("ipvlan endpoints have no interface in the host namespace")
+}
+
+// CheckNetwork verifies that the parent interface of the network still exists.
+func (d *driver) CheckNetwork(nid driverapi.UUID) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	if _, err := netlink.LinkByName(n.config.Parent); err != nil {
+		return fmt.Errorf("parent interface %q of network %s is missing: %v", n.config.Parent, nid, err)
+	}
+	return nil
+}
+
+func (d *driver) getNetwork(nid driverapi.UUID) (*ipvlanNetwork, error) {
+	d.Lock()
+	defer d.Unlock()
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil, driverapi.ErrNoNetwork
+	}
+	return n, nil
+}
+
+func (d *driver) getEndpoint(nid, eid driverapi.UUID) (*ipvlanNetwork, *ipvlanEndpoint, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	ep, ok := n.endpoints[eid]
+	if !ok {
+		return nil, nil, driverapi.ErrNoEndpoint
+	}
+	return n, ep, nil
+}
+
+func generateIfaceName() (string, error) {
+	for i := 0; i < 10; i++ {
+		name, err := netutils.GenerateRandomName(ifacePrefix, 7)
+		if err != nil {
+			continue
+		}
+		if _, err := net.InterfaceByName(name); err != nil {
+			if strings.Contains(err.Error(), "no such") {
+				return name, nil
+			}
+			return "", err
+		}
+	}
+	return "", errors.New("Failed to find name for new interface")
+}
@@ -0,0 +1,74 @@
+package ipvlan
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+)
+
+func newParent(t *testing.T) string {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "ipvlparent0"}, PeerName: "ipvlparent1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create parent interface: %v", err)
+	}
+	return veth.Name
+}
+
+func TestCreateNetworkMissingParent(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, subnet, _ := net.ParseCIDR("10.10.0.0/24")
+	config := &Configuration{Parent: "nosuchparent", Subnet: subnet}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err == nil {
+		t.Fatal("Expected an error creating a network off a missing parent")
+	}
+}
+
+func TestCreateEndpoint(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	_, d := New()
+
+	_, subnet, _ := net.ParseCIDR("10.10.0.0/24")
+	config := &Configuration{Parent: newParent(t), Subnet: subnet, Gateway: net.ParseIP("10.10.0.1")}
+	if err := d.CreateNetwork(context.Background(), "dummy", config); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	sinfo, err := d.CreateEndpoint(context.Background(), "dummy", "ep", "", nil)
+	if err == syscall.EOPNOTSUPP {
+		t.Skip("Kernel doesn't support ipvlan links")
+	}
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+
+	link, err := netlink.LinkByName(sinfo.Interfaces[0].SrcName)
+	if err != nil {
+		t.Fatalf("Could not find endpoint link: %v", err)
+	}
+	if link.Type() != "ipvlan" {
+		t.Fatalf("Expected an ipvlan link, got %s", link.Type())
+	}
+	if sinfo.Gateway != "10.10.0.1" {
+		t.Fatalf("Expected gateway 10.10.0.1, got %s", sinfo.Gateway)
+	}
+	if sinfo.Interfaces[0].Address != "10.10.0.2/24" {
+		t.Fatalf("Expected address 10.10.0.2/24, got %s", sinfo.Interfaces[0].Address)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+	if _, err := netlink.LinkByName(sinfo.Interfaces[0].SrcName); err == nil {
+		t.Fatal("Endpoint link still exists after deletion")
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Failed to delete network: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+// Package test provides an in-memory driver, through which the controller can
+// be exercised regardless of the privileges of the caller, as it never touches
+// the kernel.
+package test
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/docker/libnetwork/driverapi"
+	"golang.org/x/net/context"
+)
+
+// NetworkType is the network type the driver is meant to be registered for.
+const NetworkType = "test"
+
+// Method identifies a method of driverapi.Driver.
+type Method string
+
+// The methods of driverapi.Driver, whose calls the driver counts.
+const (
+	MethodCreateNetwork      Method = "CreateNetwork"
+	MethodDeleteNetwork      Method = "DeleteNetwork"
+	MethodCreateEndpoint     Method = "CreateEndpoint"
+	MethodDeleteEndpoint     Method = "DeleteEndpoint"
+	MethodJoin               Method = "Join"
+	MethodLeave              Method = "Leave"
+	MethodNetworkInfo        Method = "NetworkInfo"
+	MethodEndpointStatistics Method = "EndpointStatistics"
+	MethodCheckNetwork       Method = "CheckNetwork"
+)
+
+// Driver is a driverapi.Driver keeping track of its networks and endpoints in
+// memory. Each method fails with the error set for it, if any, leaving the
+// networks and endpoints alone. It is safe for concurrent use.
+type Driver struct {
+	capability driverapi.Capability
+	calls      map[Method]int
+	errs       map[Method]error
+	networks   map[driverapi.UUID]map[driverapi.UUID]struct{}
+	sync.Mutex
+}
+
+// New returns a driver with no network, supporting all the capabilities.
+func New() *Driver {
+	return &Driver{
+		capability: driverapi.Capability{IPv6: true, PortMapping: true, MultipleNetworks: true},
+		calls:      make(map[Method]int),
+		errs:       make(map[Method]error),
+		networks:   make(map[driverapi.UUID]map[driverapi.UUID]struct{}),
+	}
+}
+
+// SetCapability sets the capabilities the driver reports.
+func (d *Driver) SetCapability(capability driverapi.Capability) {
+	d.Lock()
+	defer d.Unlock()
+	d.capability = capability
+}
+
+// SetError makes the calls to method fail with err, or succeed again if err
+// is nil.
+func (d *Driver) SetError(method Method, err error) {
+	d.Lock()
+	defer d.Unlock()
+	d.errs[method] = err
+}
+
+// Calls returns the number of times method got called, whether it failed or
+// not.
+func (d *Driver) Calls(method Method) int {
+	d.Lock()
+	defer d.Unlock()
+	return d.calls[method]
+}
+
+// HasNetwork reports whether the network was created and not deleted since.
+func (d *Driver) HasNetwork(nid driverapi.UUID) bool {
+	d.Lock()
+	defer d.Unlock()
+	_, ok := d.networks[nid]
+	return ok
+}
+
+// HasEndpoint reports whether the endpoint was created and not deleted since.
+func (d *Driver) HasEndpoint(nid, eid driverapi.UUID) bool {
+	d.Lock()
+	defer d.Unlock()
+	_, ok := d.networks[nid][eid]
+	return ok
+}
+
+// call counts a call to method, and returns the error set for it. It must be
+// called with the lock held.
+func (d *Driver) call(method Method) error {
+	d.calls[method]++
+	return d.errs[method]
+}
+
+// endpoints returns the endpoints of network nid. It must be called with the
+// lock held.
+func (d *Driver) endpoints(nid driverapi.UUID) (map[driverapi.UUID]struct{}, error) {
+	eps, ok := d.networks[nid]
+	if !ok {
+		return nil, driverapi.ErrNoNetwork
+	}
+	return eps, nil
+}
+
+// checkEndpoint fails unless the endpoint exists. It must be called with the
+// lock held.
+func (d *Driver) checkEndpoint(nid, eid driverapi.UUID) error {
+	eps, err := d.endpoints(nid)
+	if err != nil {
+		return err
+	}
+	if _, ok := eps[eid]; !ok {
+		return driverapi.ErrNoEndpoint
+	}
+	return nil
+}
+
+// CreateNetwork records the network, ignoring config.
+func (d *Driver) CreateNetwork(ctx context.Context, nid driverapi.UUID, config interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodCreateNetwork); err != nil {
+		return err
+	}
+	if _, ok := d.networks[nid]; ok {
+		return fmt.Errorf("network %s already exists", nid)
+	}
+	d.networks[nid] = make(map[driverapi.UUID]struct{})
+	return nil
+}
+
+// DeleteNetwork forgets the network, which must have no endpoints left.
+func (d *Driver) DeleteNetwork(nid driverapi.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodDeleteNetwork); err != nil {
+		return err
+	}
+	eps, err := d.endpoints(nid)
+	if err != nil {
+		return err
+	}
+	if len(eps) != 0 {
+		return fmt.Errorf("network %s has %d active endpoints", nid, len(eps))
+	}
+	delete(d.networks, nid)
+	return nil
+}
+
+// CreateEndpoint records the endpoint, returning a SandboxInfo without
+// interfaces.
+func (d *Driver) CreateEndpoint(ctx context.Context, nid, eid driverapi.UUID, key string, config interface{}) (*driverapi.SandboxInfo, error) {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodCreateEndpoint); err != nil {
+		return nil, err
+	}
+	eps, err := d.endpoints(nid)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := eps[eid]; ok {
+		return nil, driverapi.ErrEndpointExists
+	}
+	eps[eid] = struct{}{}
+	return &driverapi.SandboxInfo{}, nil
+}
+
+// DeleteEndpoint forgets the endpoint.
+func (d *Driver) DeleteEndpoint(nid, eid driverapi.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodDeleteEndpoint); err != nil {
+		return err
+	}
+	eps, err := d.endpoints(nid)
+	if err != nil {
+		return err
+	}
+	if _, ok := eps[eid]; !ok {
+		return driverapi.ErrNoEndpoint
+	}
+	delete(eps, eid)
+	return nil
+}
+
+// Join returns a SandboxInfo without interfaces for an existing endpoint.
+func (d *Driver) Join(nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodJoin); err != nil {
+		return nil, err
+	}
+	if err := d.checkEndpoint(nid, eid); err != nil {
+		return nil, err
+	}
+	return &driverapi.SandboxInfo{}, nil
+}
+
+// Leave succeeds for any existing endpoint.
+func (d *Driver) Leave(nid, eid driverapi.UUID, sboxKey string) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodLeave); err != nil {
+		return err
+	}
+	return d.checkEndpoint(nid, eid)
+}
+
+// Capabilities returns the capabilities set with SetCapability.
+func (d *Driver) Capabilities() driverapi.Capability {
+	d.Lock()
+	defer d.Unlock()
+	return d.capability
+}
+
+// NetworkInfo returns an empty NetworkInfo for an existing network.
+func (d *Driver) NetworkInfo(nid driverapi.UUID) (*driverapi.NetworkInfo, error) {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodNetworkInfo); err != nil {
+		return nil, err
+	}
+	if _, err := d.endpoints(nid); err != nil {
+		return nil, err
+	}
+	return &driverapi.NetworkInfo{}, nil
+}
+
+// EndpointStatistics returns zero counters for an existing endpoint.
+func (d *Driver) EndpointStatistics(nid, eid driverapi.UUID) (*driverapi.InterfaceStatistics, error) {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodEndpointStatistics); err != nil {
+		return nil, err
+	}
+	if err := d.checkEndpoint(nid, eid); err != nil {
+		return nil, err
+	}
+	return &driverapi.InterfaceStatistics{}, nil
+}
+
+// CheckNetwork succeeds for an existing network.
+func (d *Driver) CheckNetwork(nid driverapi.UUID) error {
+	d.Lock()
+	defer d.Unlock()
+	if err := d.call(MethodCheckNetwork); err != nil {
+		return err
+	}
+	_, err := d.endpoints(nid)
+	return err
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"golang.org/x/net/context"
+)
+
+func TestDriver(t *testing.T) {
+	d := New()
+
+	if _, err := d.CreateEndpoint(context.Background(), "net1", "ep1", "", nil); err != driverapi.ErrNoNetwork {
+		t.Fatalf("Expected ErrNoNetwork, got %v", err)
+	}
+	if err := d.CreateNetwork(context.Background(), "net1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateEndpoint(context.Background(), "net1", "ep1", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !d.HasEndpoint("net1", "ep1") {
+		t.Fatal("Expected the endpoint to be recorded")
+	}
+	if err := d.DeleteNetwork("net1"); err == nil {
+		t.Fatal("Expected deleting a network with endpoints to fail")
+	}
+
+	injected := errors.New("injected")
+	d.SetError(MethodDeleteEndpoint, injected)
+	if err := d.DeleteEndpoint("net1", "ep1"); err != injected {
+		t.Fatalf("Expected the injected error, got %v", err)
+	}
+	if !d.HasEndpoint("net1", "ep1") {
+		t.Fatal("Expected a failed deletion to keep the endpoint")
+	}
+	d.SetError(MethodDeleteEndpoint, nil)
+	if err := d.DeleteEndpoint("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := d.Calls(MethodDeleteEndpoint); c != 2 {
+		t.Fatalf("Expected 2 endpoint deletions, got %d", c)
+	}
+	if c := d.Calls(MethodCreateEndpoint); c != 2 {
+		t.Fatalf("Expected 2 endpoint creations, got %d", c)
+	}
+}
@@ -0,0 +1,30 @@
+package libnetwork
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/bridge"
+)
+
+func TestBuildDriverTableDuplicate(t *testing.T) {
+	if _, err := buildDriverTable(bridge.New, bridge.New); err == nil {
+		t.Fatal("Expected registering two bridge drivers to fail")
+	}
+}
+
+func TestBuildDriverTableInvalidName(t *testing.T) {
+	invalid := func() (string, driverapi.Driver) { return "Bridge", nil }
+	if _, err := buildDriverTable(invalid); err == nil {
+		t.Fatal("Expected an uppercase driver name to be rejected")
+	}
+}
+
+func TestEnumerateDrivers(t *testing.T) {
+	drivers := enumerateDrivers()
+	for _, name := range []string{"simplebridge", "host", "ipvlan", "null"} {
+		if _, ok := drivers[name]; !ok {
+			t.Fatalf("Expected built-in driver %q to be registered", name)
+		}
+	}
+}
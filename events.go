@@ -0,0 +1,105 @@
+package libnetwork
+
+import "sync"
+
+// EventType identifies the lifecycle change an Event describes.
+type EventType string
+
+const (
+	// EventNetworkCreate is emitted once a network got created.
+	EventNetworkCreate EventType = "network-create"
+	// EventNetworkDelete is emitted once a network got deleted.
+	EventNetworkDelete EventType = "network-delete"
+	// EventEndpointCreate is emitted once an endpoint got created.
+	EventEndpointCreate EventType = "endpoint-create"
+	// EventEndpointDelete is emitted once an endpoint got deleted.
+	EventEndpointDelete EventType = "endpoint-delete"
+)
+
+// eventBufferSize is the number of events a subscriber can lag behind before
+// the oldest ones get dropped.
+const eventBufferSize = 64
+
+// Event describes the creation or deletion of a network or an endpoint. The
+// endpoint fields are empty for network events.
+type Event struct {
+	Type         EventType
+	NetworkID    string
+	NetworkName  string
+	NetworkType  string
+	EndpointID   string
+	EndpointName string
+}
+
+// eventHub fans events out to subscribers without ever blocking the emitter.
+type eventHub struct {
+	subscribers map[chan Event]struct{}
+	sync.Mutex
+}
+
+func (h *eventHub) subscribe() <-chan Event {
+	ch := make(chan Event, eventBufferSize)
+
+	h.Lock()
+	defer h.Unlock()
+	if h.subscribers == nil {
+		h.subscribers = make(map[chan Event]struct{})
+	}
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes and closes the subscription. Subscriptions are only
+// closed with the lock held, so emit never sends on a closed channel.
+func (h *eventHub) unsubscribe(sub <-chan Event) {
+	h.Lock()
+	defer h.Unlock()
+	for ch := range h.subscribers {
+		if ch == sub {
+			delete(h.subscribers, ch)
+			close(ch)
+			return
+		}
+	}
+}
+
+func (h *eventHub) unsubscribeAll() {
+	h.Lock()
+	defer h.Unlock()
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// emit delivers ev to all the subscribers. A subscriber whose buffer is full
+// loses its oldest event.
+func (h *eventHub) emit(ev Event) {
+	h.Lock()
+	defer h.Unlock()
+	for ch := range h.subscribers {
+		for {
+			select {
+			case ch <- ev:
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+func networkEvent(t EventType, n *network) Event {
+	return Event{Type: t, NetworkID: string(n.id), NetworkName: n.Name(), NetworkType: n.networkType}
+}
+
+func endpointEvent(t EventType, ep *endpoint) Event {
+	ev := networkEvent(t, ep.network)
+	ev.EndpointID = string(ep.id)
+	ev.EndpointName = ep.name
+	return ev
+}
@@ -0,0 +1,89 @@
+package libnetwork
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestNetworkEvents(t *testing.T) {
+	c, _ := newFakeController()
+	events := c.Events()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []EventType{EventNetworkCreate, EventNetworkDelete} {
+		ev := <-events
+		if ev.Type != expected {
+			t.Fatalf("Expected event %s, got %s", expected, ev.Type)
+		}
+		if ev.NetworkID != network.ID() || ev.NetworkName != "network1" || ev.NetworkType != fakeNetworkType {
+			t.Fatalf("Unexpected network in event %+v", ev)
+		}
+	}
+
+	c.StopEvents(events)
+	if _, ok := <-events; ok {
+		t.Fatal("Expected the subscription to be closed")
+	}
+
+	// Emitting after the subscription is gone must not panic.
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointEvents(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := c.Events()
+	defer c.StopEvents(events)
+
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []EventType{EventEndpointCreate, EventEndpointDelete} {
+		ev := <-events
+		if ev.Type != expected || ev.EndpointName != "ep1" || ev.NetworkID != network.ID() {
+			t.Fatalf("Expected event %s for ep1, got %+v", expected, ev)
+		}
+	}
+}
+
+func TestEventsDropOldest(t *testing.T) {
+	c, _ := newFakeController()
+	events := c.Events()
+	defer c.StopEvents(events)
+
+	// Nobody reads the events, yet creating networks doesn't block.
+	count := eventBufferSize + 10
+	for i := 0; i < count; i++ {
+		if _, err := c.NewNetwork(context.Background(), fakeNetworkType, fmt.Sprintf("network%d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if l := len(events); l != eventBufferSize {
+		t.Fatalf("Expected %d buffered events, got %d", eventBufferSize, l)
+	}
+	if ev := <-events; ev.NetworkName != "network10" {
+		t.Fatalf("Expected the oldest events to be dropped, got %s first", ev.NetworkName)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"math/big"
 	"net"
+	"sort"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
@@ -46,8 +47,46 @@ var (
 	ErrNetworkAlreadyRegistered = errors.New("network already registered")
 	// ErrBadSubnet preformatted error
 	ErrBadSubnet = errors.New("network does not contain specified subnet")
+	// ErrBadPrefixLength preformatted error
+	ErrBadPrefixLength = errors.New("point-to-point subnets must be /30 or /31")
+	// ErrNetworkNotRegistered preformatted error
+	ErrNetworkNotRegistered = errors.New("network not registered")
 )
 
+// IPAM is the interface of an IP address manager, through which drivers
+// allocate the addresses of their networks. IPAllocator is the built-in
+// implementation.
+type IPAM interface {
+	// RequestPool registers network, with addresses allocated from subnet
+	// only, or from the whole network if subnet is nil. In the absence of a
+	// call, the whole network is used.
+	RequestPool(network *net.IPNet, subnet *net.IPNet) error
+
+	// RequestIP allocates ip from network, or the next available address
+	// if ip is nil.
+	RequestIP(network *net.IPNet, ip net.IP) (net.IP, error)
+
+	// ReleaseIP returns ip to the available addresses of network.
+	ReleaseIP(network *net.IPNet, ip net.IP) error
+
+	// ReleasePool forgets network along with all its allocated addresses.
+	ReleasePool(network *net.IPNet) error
+}
+
+// PointToPointIPAM is implemented by the IP address managers able to carve
+// routed point-to-point subnets out of a network, such as IPAllocator.
+type PointToPointIPAM interface {
+	IPAM
+
+	// RequestPointToPoint allocates the next free subnet of prefix length
+	// ones of network, and returns it along with its host-side and
+	// container-side addresses.
+	RequestPointToPoint(network *net.IPNet, ones int) (*net.IPNet, net.IP, net.IP, error)
+
+	// ReleasePointToPoint returns the addresses of subnet to network.
+	ReleasePointToPoint(network *net.IPNet, subnet *net.IPNet) error
+}
+
 // IPAllocator manages the ipam
 type IPAllocator struct {
 	allocatedIPs networkSet
@@ -86,10 +125,33 @@ func (a *IPAllocator) RegisterSubnet(network *net.IPNet, subnet *net.IPNet) erro
 	return nil
 }
 
+// RequestPool registers network with bounds defined by subnet, or by the
+// whole network if subnet is nil. See RegisterSubnet.
+func (a *IPAllocator) RequestPool(network *net.IPNet, subnet *net.IPNet) error {
+	if subnet == nil {
+		subnet = network
+	}
+	return a.RegisterSubnet(network, subnet)
+}
+
+// ReleasePool removes network and all its allocated addresses from the
+// allocator. Releasing an unknown network is a no-op.
+func (a *IPAllocator) ReleasePool(network *net.IPNet) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.allocatedIPs, network.String())
+	return nil
+}
+
 // RequestIP requests an available ip from the given network.  It
 // will return the next available ip if the ip provided is nil.  If the
 // ip provided is not nil it will validate that the provided ip is available
 // for use or return an error
+//
+// IPv4 and IPv6 networks are handled alike: positions in the range are
+// big.Int, and only the allocated addresses are tracked, which keeps large
+// IPv6 prefixes such as a /64 cheap.
 func (a *IPAllocator) RequestIP(network *net.IPNet, ip net.IP) (net.IP, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
@@ -108,17 +170,220 @@ func (a *IPAllocator) RequestIP(network *net.IPNet, ip net.IP) (net.IP, error) {
 }
 
 // ReleaseIP adds the provided ip back into the pool of
-// available ips to be returned for use.
+// available ips to be returned for use. Releasing an ip which is
+// not allocated is a no-op, releasing an ip outside of the network
+// is an error.
 func (a *IPAllocator) ReleaseIP(network *net.IPNet, ip net.IP) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
+	if !network.Contains(ip) {
+		return ErrIPOutOfRange
+	}
+
 	if allocated, exists := a.allocatedIPs[network.String()]; exists {
 		delete(allocated.p, ip.String())
 	}
 	return nil
 }
 
+// RequestPointToPoint carves the next free subnet of prefix length ones out
+// of the given network, for a routed point-to-point link. It returns the
+// subnet along with its host-side and container-side addresses: the two
+// usable addresses of a /30, or both addresses of a /31. The addresses of the
+// subnet are all accounted as allocated, so they won't be handed out by
+// RequestIP.
+func (a *IPAllocator) RequestPointToPoint(network *net.IPNet, ones int) (*net.IPNet, net.IP, net.IP, error) {
+	_, bits := network.Mask.Size()
+	if hostBits := bits - ones; hostBits != 1 && hostBits != 2 {
+		return nil, nil, nil, ErrBadPrefixLength
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := network.String()
+	allocated, ok := a.allocatedIPs[key]
+	if !ok {
+		allocated = newAllocatedMap(network)
+		a.allocatedIPs[key] = allocated
+	}
+
+	size := big.NewInt(0).Lsh(big.NewInt(1), uint(bits-ones))
+	firstIP, lastIP := netutils.NetworkRange(network)
+	last := ipToBigInt(lastIP)
+	for start := ipToBigInt(firstIP); ; start.Add(start, size) {
+		end := big.NewInt(0).Sub(big.NewInt(0).Add(start, size), big.NewInt(1))
+		if end.Cmp(last) == 1 {
+			return nil, nil, nil, ErrNoAvailableIPs
+		}
+		// Addresses outside the allocation range, such as the network
+		// and broadcast addresses, can't be part of the subnet.
+		if start.Cmp(allocated.begin) == -1 || end.Cmp(allocated.end) == 1 || !allocated.isFree(start, end) {
+			continue
+		}
+
+		for pos := big.NewInt(0).Set(start); pos.Cmp(end) <= 0; pos.Add(pos, big.NewInt(1)) {
+			allocated.p[bigIntToIP(pos).String()] = struct{}{}
+		}
+
+		subnet := &net.IPNet{IP: bigIntToIP(start), Mask: net.CIDRMask(ones, bits)}
+		if bits-ones == 1 {
+			return subnet, bigIntToIP(start), bigIntToIP(end), nil
+		}
+		hostIP := bigIntToIP(big.NewInt(0).Add(start, big.NewInt(1)))
+		containerIP := bigIntToIP(big.NewInt(0).Sub(end, big.NewInt(1)))
+		return subnet, hostIP, containerIP, nil
+	}
+}
+
+// ReleasePointToPoint releases all the addresses of a subnet previously
+// returned by RequestPointToPoint for the given network.
+func (a *IPAllocator) ReleasePointToPoint(network *net.IPNet, subnet *net.IPNet) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if !netutils.NetworkContains(network, subnet) {
+		return ErrIPOutOfRange
+	}
+
+	if allocated, exists := a.allocatedIPs[network.String()]; exists {
+		firstIP, lastIP := netutils.NetworkRange(subnet)
+		end := ipToBigInt(lastIP)
+		for pos := ipToBigInt(firstIP); pos.Cmp(end) <= 0; pos.Add(pos, big.NewInt(1)) {
+			delete(allocated.p, bigIntToIP(pos).String())
+		}
+	}
+	return nil
+}
+
+// NetworkState is the serializable state of a network of the allocator, for
+// its allocations to survive restarts.
+type NetworkState struct {
+	Network   *net.IPNet
+	Begin     net.IP   // First address of the allocation range.
+	End       net.IP   // Last address of the allocation range.
+	Last      net.IP   // Last allocated address, the next one is tried first.
+	Allocated []net.IP // Sorted by increasing address.
+}
+
+// ExportState returns the state of network, which must have been registered
+// or allocated from.
+func (a *IPAllocator) ExportState(network *net.IPNet) (*NetworkState, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allocated, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		return nil, ErrNetworkNotRegistered
+	}
+
+	state := &NetworkState{
+		Network: &net.IPNet{IP: network.IP, Mask: network.Mask},
+		Begin:   bigIntToIP(allocated.begin),
+		End:     bigIntToIP(allocated.end),
+		Last:    bigIntToIP(allocated.last),
+	}
+	for ip := range allocated.p {
+		state.Allocated = append(state.Allocated, net.ParseIP(ip))
+	}
+	sort.Sort(byAddress(state.Allocated))
+	return state, nil
+}
+
+// byAddress sorts IP addresses by increasing address.
+type byAddress []net.IP
+
+func (s byAddress) Len() int           { return len(s) }
+func (s byAddress) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byAddress) Less(i, j int) bool { return ipToBigInt(s[i]).Cmp(ipToBigInt(s[j])) == -1 }
+
+// ImportState replaces the state of state.Network with a previously exported
+// one. The addresses of inUse within the allocation range are accounted as
+// allocated as well, so that an address assigned after the state got
+// exported isn't handed out again.
+func (a *IPAllocator) ImportState(state *NetworkState, inUse []net.IP) error {
+	if state.Network == nil || state.Begin == nil || state.End == nil || state.Last == nil {
+		return errors.New("incomplete network state")
+	}
+
+	n := newAllocatedMap(state.Network)
+	begin, end := ipToBigInt(state.Begin), ipToBigInt(state.End)
+	if begin.Cmp(n.begin) == -1 || end.Cmp(n.end) == 1 || begin.Cmp(end) == 1 {
+		return ErrBadSubnet
+	}
+	n.begin.Set(begin)
+	n.end.Set(end)
+	// A last address off the range would lead to allocations off the range.
+	if last := ipToBigInt(state.Last); last.Cmp(begin) >= 0 && last.Cmp(end) <= 0 {
+		n.last.Set(last)
+	} else {
+		n.last.Sub(begin, big.NewInt(1))
+	}
+
+	for _, ip := range state.Allocated {
+		if _, err := n.checkIP(ip); err != nil && err != ErrIPAlreadyAllocated {
+			return err
+		}
+	}
+	for _, ip := range inUse {
+		if !state.Network.Contains(ip) {
+			continue
+		}
+		if _, err := n.checkIP(ip); err != nil && err != ErrIPAlreadyAllocated && err != ErrIPOutOfRange {
+			return err
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.allocatedIPs[state.Network.String()] = n
+	return nil
+}
+
+// Utilization returns the number of addresses allocated from network and the
+// number of addresses it has to allocate from, the network and broadcast
+// addresses excluded. Both are counted without walking the range, and the
+// total saturates at the largest int for the very large IPv6 networks. A
+// network nothing was registered or allocated from reports its whole range.
+func (a *IPAllocator) Utilization(network *net.IPNet) (used, total int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allocated, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		allocated = newAllocatedMap(network)
+	}
+	return len(allocated.p), allocated.size()
+}
+
+// size returns the number of addresses in the allocation range, saturating
+// at the largest int.
+func (allocated *allocatedMap) size() int {
+	const maxInt = int(^uint(0) >> 1)
+
+	size := big.NewInt(0).Sub(allocated.end, allocated.begin)
+	size.Add(size, big.NewInt(1))
+	switch {
+	case size.Sign() <= 0:
+		return 0
+	case size.Cmp(big.NewInt(int64(maxInt))) > 0:
+		return maxInt
+	}
+	return int(size.Int64())
+}
+
+// isFree reports whether none of the addresses between begin and end, both
+// included, are allocated.
+func (allocated *allocatedMap) isFree(begin, end *big.Int) bool {
+	for pos := big.NewInt(0).Set(begin); pos.Cmp(end) <= 0; pos.Add(pos, big.NewInt(1)) {
+		if _, ok := allocated.p[bigIntToIP(pos).String()]; ok {
+			return false
+		}
+	}
+	return true
+}
+
 func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
 	if _, ok := allocated.p[ip.String()]; ok {
 		return nil, ErrIPAlreadyAllocated
@@ -1,6 +1,7 @@
 package ipallocator
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"net"
@@ -114,6 +115,35 @@ func TestRequestNewIpV6(t *testing.T) {
 	}
 }
 
+func TestRequestIPv6Exhaustion(t *testing.T) {
+	a := New()
+	_, network, _ := net.ParseCIDR("fd00::/120")
+
+	// The first and last addresses of the range are never handed out.
+	for i := 1; i < 255; i++ {
+		ip, err := a.RequestIP(network, nil)
+		if err != nil {
+			t.Fatalf("Failed to allocate address %d: %v", i, err)
+		}
+		if expected := fmt.Sprintf("fd00::%x", i); ip.String() != expected {
+			t.Fatalf("Expected ip %s got %s", expected, ip)
+		}
+	}
+
+	if _, err := a.RequestIP(network, nil); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected ErrNoAvailableIPs, got %v", err)
+	}
+
+	if err := a.ReleaseIP(network, net.ParseIP("fd00::10")); err != nil {
+		t.Fatal(err)
+	}
+	ip, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.ParseIP("fd00::10"), ip)
+}
+
 func TestReleaseIp(t *testing.T) {
 	a := New()
 
@@ -132,6 +162,47 @@ func TestReleaseIp(t *testing.T) {
 	}
 }
 
+func TestReleaseIpTwice(t *testing.T) {
+	a := New()
+
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	ip, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := a.ReleaseIP(network, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The released ip must be available again, and only once.
+	if _, err := a.RequestIP(network, ip); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.RequestIP(network, ip); err != ErrIPAlreadyAllocated {
+		t.Fatalf("Expected ErrIPAlreadyAllocated, got %v", err)
+	}
+}
+
+func TestReleaseIpOutOfRange(t *testing.T) {
+	a := New()
+
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	if err := a.ReleaseIP(network, net.ParseIP("192.168.1.1")); err != ErrIPOutOfRange {
+		t.Fatalf("Expected ErrIPOutOfRange, got %v", err)
+	}
+}
+
 func TestReleaseIpV6(t *testing.T) {
 	a := New()
 
@@ -253,6 +324,29 @@ func TestRequestSpecificIp(t *testing.T) {
 	}
 }
 
+func TestRequestSpecificIpThenNext(t *testing.T) {
+	a := New()
+
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	// Reserve the first address of the range explicitly.
+	if _, err := a.RequestIP(network, net.ParseIP("192.168.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sequential allocation must skip the explicitly reserved address.
+	ip, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "192.168.0.2"; ip.String() != expected {
+		t.Fatalf("Expected allocated IP %s, got %s", expected, ip)
+	}
+}
+
 func TestRequestSpecificIpV6(t *testing.T) {
 	a := New()
 
@@ -505,6 +599,53 @@ func setLastTo(t *testing.T, a *IPAllocator, network *net.IPNet, ip net.IP) {
 	}
 }
 
+func TestAllocateSkipsNetworkAndBroadcast(t *testing.T) {
+	a := New()
+
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 0},
+		Mask: []byte{255, 255, 255, 252}, // /30
+	}
+
+	for _, expected := range []string{"192.168.0.1", "192.168.0.2"} {
+		ip, err := a.RequestIP(network, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip.String() != expected {
+			t.Fatalf("Expected allocated IP %s, got %s", expected, ip)
+		}
+	}
+
+	if _, err := a.RequestIP(network, nil); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected ErrNoAvailableIPs, got %v", err)
+	}
+
+	for _, ip := range []string{"192.168.0.0", "192.168.0.3"} {
+		if _, err := a.RequestIP(network, net.ParseIP(ip)); err != ErrIPOutOfRange {
+			t.Fatalf("Expected ErrIPOutOfRange for %s, got %v", ip, err)
+		}
+	}
+}
+
+func TestAllocateExhaustedSubnet(t *testing.T) {
+	a := New()
+
+	// A /31 has no address left once network and broadcast are excluded.
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 0},
+		Mask: []byte{255, 255, 255, 254},
+	}
+
+	if _, err := a.RequestIP(network, nil); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected ErrNoAvailableIPs, got %v", err)
+	}
+
+	if _, err := a.RequestIP(network, net.ParseIP("192.168.0.1")); err != ErrIPOutOfRange {
+		t.Fatalf("Expected ErrIPOutOfRange, got %v", err)
+	}
+}
+
 func TestAllocateDifferentSubnets(t *testing.T) {
 	a := New()
 	network1 := &net.IPNet{
@@ -664,6 +805,81 @@ func TestAllocateFromRange(t *testing.T) {
 	}
 }
 
+func TestRequestPointToPoint(t *testing.T) {
+	a := New()
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+
+	subnet1, host1, container1, err := a.RequestPointToPoint(network, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet2, host2, container2, err := a.RequestPointToPoint(network, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first /30 holds the network address, so it's skipped.
+	if subnet1.String() != "10.0.0.4/30" || subnet2.String() != "10.0.0.8/30" {
+		t.Fatalf("Expected subnets 10.0.0.4/30 and 10.0.0.8/30, got %s and %s", subnet1, subnet2)
+	}
+	if subnet1.Contains(subnet2.IP) || subnet2.Contains(subnet1.IP) {
+		t.Fatalf("Subnets %s and %s overlap", subnet1, subnet2)
+	}
+	assertIPEquals(t, net.ParseIP("10.0.0.5"), host1)
+	assertIPEquals(t, net.ParseIP("10.0.0.6"), container1)
+	assertIPEquals(t, net.ParseIP("10.0.0.9"), host2)
+	assertIPEquals(t, net.ParseIP("10.0.0.10"), container2)
+
+	// The carved addresses are not handed out individually.
+	ip, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subnet1.Contains(ip) || subnet2.Contains(ip) {
+		t.Fatalf("RequestIP returned %s from a point-to-point subnet", ip)
+	}
+
+	if err := a.ReleasePointToPoint(network, subnet1); err != nil {
+		t.Fatal(err)
+	}
+	subnet3, _, _, err := a.RequestPointToPoint(network, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subnet3.String() != subnet1.String() {
+		t.Fatalf("Expected released subnet %s to be reused, got %s", subnet1, subnet3)
+	}
+}
+
+func TestRequestPointToPoint31(t *testing.T) {
+	a := New()
+	_, network, _ := net.ParseCIDR("10.0.0.0/29")
+
+	var subnets []string
+	for {
+		subnet, host, container, err := a.RequestPointToPoint(network, 31)
+		if err == ErrNoAvailableIPs {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !host.Equal(subnet.IP) || !subnet.Contains(container) || host.Equal(container) {
+			t.Fatalf("Unexpected addresses %s and %s for subnet %s", host, container, subnet)
+		}
+		subnets = append(subnets, subnet.String())
+	}
+
+	// Network and broadcast addresses exclude the first and last /31.
+	if len(subnets) != 2 || subnets[0] != "10.0.0.2/31" || subnets[1] != "10.0.0.4/31" {
+		t.Fatalf("Unexpected subnets %v", subnets)
+	}
+
+	if _, _, _, err := a.RequestPointToPoint(network, 28); err != ErrBadPrefixLength {
+		t.Fatalf("Expected ErrBadPrefixLength, got %v", err)
+	}
+}
+
 func assertIPEquals(t *testing.T, ip1, ip2 net.IP) {
 	if !ip1.Equal(ip2) {
 		t.Fatalf("Expected IP %s, got %s", ip1, ip2)
@@ -688,3 +904,145 @@ func BenchmarkRequestIP(b *testing.B) {
 		}
 	}
 }
+
+func TestRequestReleasePool(t *testing.T) {
+	var a IPAM = New()
+	_, network, _ := net.ParseCIDR("192.168.0.0/24")
+	_, subnet, _ := net.ParseCIDR("192.168.0.64/26")
+
+	if err := a.RequestPool(network, subnet); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RequestPool(network, nil); err != ErrNetworkAlreadyRegistered {
+		t.Fatalf("Expected ErrNetworkAlreadyRegistered, got %v", err)
+	}
+
+	ip, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.ParseIP("192.168.0.65"), ip)
+
+	// Once released, the network is back to its full range.
+	if err := a.ReleasePool(network); err != nil {
+		t.Fatal(err)
+	}
+	ip, err = a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.ParseIP("192.168.0.1"), ip)
+}
+
+func TestExportImportState(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.168.0.0/24")
+	_, subnet, _ := net.ParseCIDR("192.168.0.0/25")
+
+	a := New()
+	if err := a.RegisterSubnet(network, subnet); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := a.RequestIP(network, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.ReleaseIP(network, net.ParseIP("192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := a.ExportState(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &NetworkState{}
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	// 192.168.0.4 got assigned after the state was exported.
+	b := New()
+	if err := b.ImportState(restored, []net.IP{net.ParseIP("192.168.0.4"), net.ParseIP("10.0.0.1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range []string{"192.168.0.1", "192.168.0.3", "192.168.0.4"} {
+		if _, err := b.RequestIP(network, net.ParseIP(ip)); err != ErrIPAlreadyAllocated {
+			t.Fatalf("Expected %s to be allocated, got %v", ip, err)
+		}
+	}
+	ip, err := b.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "192.168.0.5"; ip.String() != expected {
+		t.Fatalf("Expected %s, got %s", expected, ip)
+	}
+	if _, err := b.RequestIP(network, net.ParseIP("192.168.0.200")); err != ErrIPOutOfRange {
+		t.Fatalf("Expected the allocation range to be restored, got %v", err)
+	}
+}
+
+func TestExportStateUnknownNetwork(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.168.0.0/24")
+	if _, err := New().ExportState(network); err != ErrNetworkNotRegistered {
+		t.Fatalf("Expected ErrNetworkNotRegistered, got %v", err)
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	a := New()
+	_, network, _ := net.ParseCIDR("192.168.0.0/29")
+
+	if used, total := a.Utilization(network); used != 0 || total != 6 {
+		t.Fatalf("Expected 0 of 6 addresses used before any allocation, got %d of %d", used, total)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.RequestIP(network, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if used, total := a.Utilization(network); used != 3 || total != 6 {
+		t.Fatalf("Expected 3 of 6 addresses used, got %d of %d", used, total)
+	}
+
+	if err := a.ReleaseIP(network, net.ParseIP("192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := a.Utilization(network); used != 2 {
+		t.Fatalf("Expected 2 addresses used after a release, got %d", used)
+	}
+
+	// The total of a registered subnet is the size of the subnet.
+	_, network, _ = net.ParseCIDR("10.0.0.0/16")
+	_, subnet, _ := net.ParseCIDR("10.0.1.0/24")
+	if err := a.RegisterSubnet(network, subnet); err != nil {
+		t.Fatal(err)
+	}
+	if _, total := a.Utilization(network); total != 254 {
+		t.Fatalf("Expected 254 addresses in the subnet, got %d", total)
+	}
+}
+
+func TestUtilizationIPv6(t *testing.T) {
+	a := New()
+	_, network, _ := net.ParseCIDR("2001:db8::/64")
+	if _, err := a.RequestIP(network, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const maxInt = int(^uint(0) >> 1)
+	if used, total := a.Utilization(network); used != 1 || total != maxInt {
+		t.Fatalf("Expected 1 address of a saturated total used, got %d of %d", used, total)
+	}
+
+	_, network, _ = net.ParseCIDR("2001:db8::/120")
+	if _, total := a.Utilization(network); total != 254 {
+		t.Fatalf("Expected 254 addresses, got %d", total)
+	}
+}
@@ -1,15 +1,27 @@
 package libnetwork_test
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork"
-	_ "github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/drivers/test"
+	"github.com/docker/libnetwork/ipallocator"
+	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/pkg/options"
+	"github.com/docker/libnetwork/sandbox"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
 )
 
 var bridgeName = "docker0"
@@ -55,7 +67,7 @@ func TestSimplebridge(t *testing.T) {
 
 	controller := libnetwork.New()
 
-	network, err := controller.NewNetwork("simplebridge", "dummy", options)
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "dummy", options)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -64,3 +76,625 @@ func TestSimplebridge(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestNetworkByNameAndID(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	controller := libnetwork.New()
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := controller.NetworkByName("network1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID() != network.ID() {
+		t.Fatalf("NetworkByName returned network with id %s, expected %s", n.ID(), network.ID())
+	}
+
+	n, err = controller.NetworkByID(network.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Name() != "network1" {
+		t.Fatalf("NetworkByID returned network named %q, expected %q", n.Name(), "network1")
+	}
+
+	if _, err := controller.NetworkByName("network2"); err != libnetwork.ErrNoSuchNetwork("network2") {
+		t.Fatalf("Expected ErrNoSuchNetwork for unknown name, got %v", err)
+	}
+
+	if _, err := controller.NetworkByID("unknown"); err != libnetwork.ErrNoSuchNetwork("unknown") {
+		t.Fatalf("Expected ErrNoSuchNetwork for unknown id, got %v", err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkInfo(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	controller := libnetwork.New()
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer network.Delete()
+
+	// No address was configured, the driver elected the bridge subnet.
+	info := network.Info()
+	if info.Type != "simplebridge" {
+		t.Fatalf("Expected network type simplebridge, got %s", info.Type)
+	}
+	if info.Subnet == nil || info.Gateway == nil {
+		t.Fatalf("Expected the elected subnet and gateway, got %+v", info)
+	}
+	if !info.Subnet.Contains(info.Gateway) {
+		t.Fatalf("Gateway %s is not within subnet %s", info.Gateway, info.Subnet)
+	}
+	if !info.Subnet.IP.Equal(info.Subnet.IP.Mask(info.Subnet.Mask)) {
+		t.Fatalf("Expected a network address, got subnet %s", info.Subnet)
+	}
+	if info.SubnetIPv6 != nil {
+		t.Fatalf("Expected no IPv6 subnet, got %s", info.SubnetIPv6)
+	}
+
+	if len(info.Utilization) != 1 || info.Utilization[0].Subnet.String() != info.Subnet.String() {
+		t.Fatalf("Expected the utilization of subnet %s, got %+v", info.Subnet, info.Utilization)
+	}
+	before := info.Utilization[0]
+	ones, bits := info.Subnet.Mask.Size()
+	if expected := 1<<uint(bits-ones) - 2; before.Total != expected {
+		t.Fatalf("Expected %d allocatable addresses, got %d", expected, before.Total)
+	}
+
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	after := network.Info().Utilization[0]
+	if after.Used != before.Used+1 || after.Total != before.Total {
+		t.Fatalf("Expected one more address used after creating an endpoint, got %+v then %+v", before, after)
+	}
+}
+
+func TestNetworksAndWalk(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	controller := libnetwork.New()
+	if l := len(controller.Networks()); l != 0 {
+		t.Fatalf("Expected no networks on a new controller, got %d", l)
+	}
+
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := controller.Networks()
+	if len(list) != 1 || list[0].ID() != network.ID() {
+		t.Fatalf("Unexpected networks list %v", list)
+	}
+
+	var visited []libnetwork.Network
+	controller.WalkNetworks(func(n libnetwork.Network) bool {
+		// Calling back into the controller must not deadlock.
+		if _, err := controller.NetworkByID(n.ID()); err != nil {
+			t.Fatal(err)
+		}
+		visited = append(visited, n)
+		return true
+	})
+	if len(visited) != 1 || visited[0].ID() != network.ID() {
+		t.Fatalf("Unexpected walked networks %v", visited)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(controller.Networks()); l != 0 {
+		t.Fatalf("Expected no networks after delete, got %d", l)
+	}
+}
+
+func TestNetworkEndpointsEmpty(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	controller := libnetwork.New()
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(network.Endpoints()); l != 0 {
+		t.Fatalf("Expected no endpoints on a new network, got %d", l)
+	}
+
+	if _, err := network.EndpointByName("ep1"); err != libnetwork.ErrNoSuchEndpoint("ep1") {
+		t.Fatalf("Expected ErrNoSuchEndpoint for unknown name, got %v", err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNullNetwork(t *testing.T) {
+	controller := libnetwork.New()
+
+	network, err := controller.NewNetwork(context.Background(), "null", "testnetwork", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, sinfo, err := network.CreateEndpoint(context.Background(), "testep", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sinfo.Interfaces) != 0 || sinfo.Gateway != "" || sinfo.GatewayIPv6 != "" {
+		t.Fatalf("Expected an empty sandbox info, got %v", sinfo)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkDeleteMissingBridge(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	ipam := ipallocator.New()
+	_, d := bridge.NewWithIPAM(ipam)
+	controller := libnetwork.New(libnetwork.OptionDriver("simplebridge", d))
+
+	_, fixedCIDRv6, _ := net.ParseCIDR("2001:db8::/64")
+	netOptions := options.Generic{"EnableIPv6": true, "FixedCIDRv6": fixedCIDRv6}
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", netOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the bridge out of band.
+	link, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatalf("Deleting a network whose bridge is gone failed: %v", err)
+	}
+
+	if _, err := controller.NetworkByName("network1"); err != libnetwork.ErrNoSuchNetwork("network1") {
+		t.Fatalf("Expected the network to be removed from the controller, got %v", err)
+	}
+
+	// The rest of the network got cleaned up all the same.
+	if _, err := ipam.ExportState(fixedCIDRv6); err != ipallocator.ErrNetworkNotRegistered {
+		t.Fatalf("Expected the fixed IPv6 pool to be released, got %v", err)
+	}
+	network, err = controller.NewNetwork(context.Background(), "simplebridge", "network1", netOptions)
+	if err != nil {
+		t.Fatalf("Failed to create the network again: %v", err)
+	}
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinNetworks(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	// The simplebridge driver manages a single network, so register a second
+	// instance for the second bridge.
+	_, d := bridge.New()
+	controller := libnetwork.New(libnetwork.OptionDriver("simplebridge2", d))
+
+	// Only the default bridge gets created by the driver, the second one is
+	// adopted.
+	ip, addr1, err := net.ParseCIDR("10.200.2.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr1.IP = ip
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "testbr1"}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: addr1}); err != nil {
+		t.Fatal(err)
+	}
+
+	ip, addr0, err := net.ParseCIDR("10.200.1.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr0.IP = ip
+
+	var networks []libnetwork.Network
+	for i, nw := range []struct {
+		networkType string
+		option      options.Generic
+	}{
+		{"simplebridge", options.Generic{"BridgeName": bridgeName, "AddressIPv4": addr0}},
+		{"simplebridge2", options.Generic{"BridgeName": "testbr1", "AllowExisting": true}},
+	} {
+		network, err := controller.NewNetwork(context.Background(), nw.networkType, fmt.Sprintf("network%d", i), nw.option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		networks = append(networks, network)
+	}
+
+	key := filepath.Join(os.TempDir(), "libnetwork-join-test")
+	eps, sinfo, err := controller.JoinNetworks(context.Background(), key, networks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps) != 2 {
+		t.Fatalf("Expected one endpoint per network, got %d", len(eps))
+	}
+	if len(sinfo.Interfaces) != 2 {
+		t.Fatalf("Expected two interfaces, got %d", len(sinfo.Interfaces))
+	}
+	if sinfo.Gateway != "10.200.1.1" {
+		t.Fatalf("Expected the first network to provide the gateway, got %s", sinfo.Gateway)
+	}
+
+	s, err := sandbox.NewSandbox(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+
+	for _, iface := range sinfo.Interfaces {
+		if err := s.AddInterface(iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, iface := range info.Interfaces {
+		names[iface.Name] = true
+	}
+	if !names["eth0"] || !names["eth1"] {
+		t.Fatalf("Expected eth0 and eth1 in the sandbox, got %v", names)
+	}
+
+	// The renaming is the sandbox's business, the driver keeps naming the
+	// interface of the second endpoint eth0 however often it joins.
+	for i := 0; i < 2; i++ {
+		if err := eps[1].Leave(key); err != nil {
+			t.Fatal(err)
+		}
+		jinfo, err := eps[1].Join(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := jinfo.Interfaces[0].DstName; name != "eth0" {
+			t.Fatalf("Expected the driver to still name the interface eth0 on join %d, got %s", i, name)
+		}
+	}
+}
+
+func TestDeferredJoin(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	allocator := ipallocator.New()
+	_, d := bridge.NewWithIPAM(allocator)
+	controller := libnetwork.New(libnetwork.OptionDriver("simplebridge2", d))
+
+	ip, addr, err := net.ParseCIDR("10.200.3.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.IP = ip
+	network, err := controller.NewNetwork(context.Background(), "simplebridge2", "network1",
+		options.Generic{"BridgeName": bridgeName, "AddressIPv4": addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No sandbox yet, the interface is left in the host namespace.
+	ep, sinfo, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := sinfo.Interfaces[0]
+	if _, err := netlink.LinkByName(iface.SrcName); err != nil {
+		t.Fatalf("Expected %s in the host namespace: %v", iface.SrcName, err)
+	}
+	epIP, _, err := net.ParseCIDR(iface.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allocator.RequestIP(addr, epIP); err != ipallocator.ErrIPAlreadyAllocated {
+		t.Fatalf("Expected %s to be reserved, got %v", epIP, err)
+	}
+	if info := ep.Info(); info != nil {
+		t.Fatalf("Expected no sandbox info before joining, got %+v", info)
+	}
+
+	if _, err := ep.Join("", nil); err != libnetwork.ErrNoSandboxKey {
+		t.Fatalf("Expected ErrNoSandboxKey, got %v", err)
+	}
+
+	key := filepath.Join(os.TempDir(), "libnetwork-deferred-join-test")
+	s, err := sandbox.NewSandbox(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+
+	jinfo, err := ep.Join(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jinfo.Interfaces[0].Address != iface.Address {
+		t.Fatalf("Expected Join to hand out the reserved address %s, got %s", iface.Address, jinfo.Interfaces[0].Address)
+	}
+	if err := s.AddInterface(jinfo.Interfaces[0]); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, i := range info.Interfaces {
+		if i.Name == "eth0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected eth0 in the sandbox, got %+v", info.Interfaces)
+	}
+
+	if err := ep.Leave(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerCloseDeletesBridges(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	_, d := bridge.New()
+	controller := libnetwork.New(libnetwork.OptionDriver("simplebridge2", d))
+
+	ip, addr, err := net.ParseCIDR("10.200.2.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.IP = ip
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "testbr1"}}
+	if err := netlink.LinkAdd(br); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.AddrAdd(br, &netlink.Addr{IPNet: addr}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := controller.NewNetwork(context.Background(), "simplebridge", "network0", options.Generic{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := controller.NewNetwork(context.Background(), "simplebridge2", "network1", options.Generic{"BridgeName": "testbr1", "AllowExisting": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := controller.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{bridgeName, "testbr1"} {
+		if _, err := netlink.LinkByName(name); err == nil {
+			t.Fatalf("Bridge %s still exists after closing the controller", name)
+		}
+	}
+	if l := len(controller.Networks()); l != 0 {
+		t.Fatalf("Expected no network left, got %d", l)
+	}
+}
+
+func TestNewNetworkRollback(t *testing.T) {
+	d := test.New()
+	controller := libnetwork.New(libnetwork.OptionDriver(test.NetworkType, d))
+
+	network, err := controller.NewNetwork(context.Background(), test.NetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The driver network created for a taken name is deleted again.
+	if _, err := controller.NewNetwork(context.Background(), test.NetworkType, "network1", nil); err != libnetwork.NetworkNameError("network1") {
+		t.Fatalf("Expected a NetworkNameError, got %v", err)
+	}
+	if creates, deletes := d.Calls(test.MethodCreateNetwork), d.Calls(test.MethodDeleteNetwork); creates != 2 || deletes != 1 {
+		t.Fatalf("Expected 2 driver network creations and 1 deletion, got %d and %d", creates, deletes)
+	}
+
+	d.SetError(test.MethodCreateNetwork, errors.New("no more networks"))
+	if _, err := controller.NewNetwork(context.Background(), test.NetworkType, "network2", nil); err == nil {
+		t.Fatal("Expected the driver failure to be returned")
+	}
+	if _, err := controller.NetworkByName("network2"); err != libnetwork.ErrNoSuchNetwork("network2") {
+		t.Fatalf("Expected the failed network not to be registered, got %v", err)
+	}
+
+	if !d.HasNetwork(driverapi.UUID(network.ID())) {
+		t.Fatal("Expected the first network to be left alone")
+	}
+}
+
+func TestNetworkDeleteDriverFailure(t *testing.T) {
+	d := test.New()
+	controller := libnetwork.New(libnetwork.OptionDriver(test.NetworkType, d))
+
+	network, err := controller.NewNetwork(context.Background(), test.NetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The driver isn't asked to delete a network with endpoints.
+	if err := network.Delete(); err == nil {
+		t.Fatal("Expected deleting a network with endpoints to fail")
+	}
+	if c := d.Calls(test.MethodDeleteNetwork); c != 0 {
+		t.Fatalf("Expected no driver network deletion, got %d", c)
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetError(test.MethodDeleteNetwork, errors.New("network busy"))
+	if err := network.Delete(); err == nil {
+		t.Fatal("Expected the driver failure to be returned")
+	}
+	if _, err := controller.NetworkByName("network1"); err != nil {
+		t.Fatalf("Expected the network to be kept after a failed deletion: %v", err)
+	}
+
+	d.SetError(test.MethodDeleteNetwork, nil)
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := controller.NetworkByName("network1"); err != libnetwork.ErrNoSuchNetwork("network1") {
+		t.Fatalf("Expected the network to be gone, got %v", err)
+	}
+	if d.HasNetwork(driverapi.UUID(network.ID())) {
+		t.Fatal("Expected the driver network to be deleted")
+	}
+
+	// A network the driver lost track of is deleted all the same.
+	network, err = controller.NewNetwork(context.Background(), test.NetworkType, "network2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetError(test.MethodDeleteNetwork, driverapi.ErrNoNetwork)
+	if err := network.Delete(); err != nil {
+		t.Fatalf("Expected a network unknown to the driver to be deleted, got %v", err)
+	}
+	if _, err := controller.NetworkByName("network2"); err != libnetwork.ErrNoSuchNetwork("network2") {
+		t.Fatalf("Expected the network to be gone, got %v", err)
+	}
+}
+
+func TestRestoreBridgeNetwork(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	dir, err := ioutil.TempDir("", "libnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := datastore.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, d := bridge.NewWithIPAM(ipallocator.New())
+	controller := libnetwork.New(libnetwork.OptionDataStore(store), libnetwork.OptionDriver("simplebridge", d))
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted controller gets a fresh driver, which knows nothing of the
+	// network but what the store holds.
+	_, d = bridge.NewWithIPAM(ipallocator.New())
+	controller = libnetwork.New(libnetwork.OptionDataStore(store), libnetwork.OptionDriver("simplebridge", d))
+	network, err = controller.NetworkByName("network1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := network.EndpointByName("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatalf("Failed to delete the restored endpoint: %v", err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep2", "", nil); err != nil {
+		t.Fatalf("Failed to create an endpoint on the restored network: %v", err)
+	}
+	if errs := network.DeleteEndpoints(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := network.Delete(); err != nil {
+		t.Fatalf("Failed to delete the restored network: %v", err)
+	}
+	if _, err := netlink.LinkByName(bridgeName); err == nil {
+		t.Fatal("Expected the bridge to be deleted along with the restored network")
+	}
+}
+
+// recordingLogger keeps the messages logged to it, in order.
+type recordingLogger struct {
+	messages []string
+	fields   []driverapi.Fields
+}
+
+func (l *recordingLogger) Debug(msg string, fields driverapi.Fields) {
+	l.messages = append(l.messages, msg)
+	l.fields = append(l.fields, fields)
+}
+
+func TestOptionLogger(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	logger := &recordingLogger{}
+	controller := libnetwork.New(libnetwork.OptionLogger(logger))
+	network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", options.Generic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"created bridge", "added address", "set bridge up"}
+	if len(logger.messages) != len(expected) {
+		t.Fatalf("Expected messages %q, got %q", expected, logger.messages)
+	}
+	for i, msg := range expected {
+		if logger.messages[i] != msg {
+			t.Fatalf("Expected messages %q, got %q", expected, logger.messages)
+		}
+		if logger.fields[i]["bridge"] != bridgeName {
+			t.Fatalf("Expected message %q to name bridge %s, got fields %v", msg, bridgeName, logger.fields[i])
+		}
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if last := logger.messages[len(logger.messages)-1]; last != "deleted bridge" {
+		t.Fatalf("Expected the bridge deletion to be logged last, got %q", last)
+	}
+}
@@ -7,7 +7,8 @@ import (
 )
 
 // SetupTestNetNS joins a new network namespace, and returns its associated
-// teardown function.
+// teardown function. The test is skipped when the process lacks the
+// privileges to create a namespace.
 //
 // Example usage:
 //
@@ -16,6 +17,10 @@ import (
 func SetupTestNetNS(t *testing.T) func() {
 	runtime.LockOSThread()
 	if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		if err == syscall.EPERM {
+			t.Skipf("Skipping test: creating a netns requires CAP_SYS_ADMIN: %v", err)
+		}
 		t.Fatalf("Failed to enter netns: %v", err)
 	}
 
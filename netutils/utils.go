@@ -4,6 +4,9 @@
 package netutils
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -20,9 +23,27 @@ var (
 	// ErrNoDefaultRoute preformatted error
 	ErrNoDefaultRoute = errors.New("no default route")
 
+	// ErrNoAvailableNetwork preformatted error
+	ErrNoAvailableNetwork = errors.New("no available private network")
+
 	networkGetRoutesFct = netlink.RouteList
+
+	// RFC1918 private address ranges.
+	privateNetworks = []*net.IPNet{
+		{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+		{IP: net.IPv4(172, 16, 0, 0).To4(), Mask: net.CIDRMask(12, 32)},
+		{IP: net.IPv4(192, 168, 0, 0).To4(), Mask: net.CIDRMask(16, 32)},
+	}
 )
 
+// maxIfaceNameLen is the longest interface name accepted by the kernel,
+// IFNAMSIZ minus the terminating null byte.
+const maxIfaceNameLen = 15
+
+// maxRandomCIDRAttempts bounds the number of random networks
+// GenerateRandomCIDR tries before giving up.
+const maxRandomCIDRAttempts = 100
+
 // CheckNameserverOverlaps checks whether the passed network overlaps with any of the nameservers
 func CheckNameserverOverlaps(nameservers []string, toCheck *net.IPNet) error {
 	if len(nameservers) > 0 {
@@ -56,7 +77,9 @@ func CheckRouteOverlaps(toCheck *net.IPNet) error {
 
 // NetworkOverlaps detects overlap between one IPNet and another
 func NetworkOverlaps(netX *net.IPNet, netY *net.IPNet) bool {
-	if len(netX.IP) == len(netY.IP) {
+	// Compare address families rather than slice lengths, an IPv4 address
+	// may be stored in its 16 bytes form.
+	if (netX.IP.To4() == nil) == (netY.IP.To4() == nil) {
 		if firstIP, _ := NetworkRange(netX); netY.Contains(firstIP) {
 			return true
 		}
@@ -67,6 +90,13 @@ func NetworkOverlaps(netX *net.IPNet, netY *net.IPNet) bool {
 	return false
 }
 
+// NetworkContains checks whether the inner network is a subset of the outer
+// one
+func NetworkContains(outer *net.IPNet, inner *net.IPNet) bool {
+	firstIP, lastIP := NetworkRange(inner)
+	return firstIP != nil && outer.Contains(firstIP) && outer.Contains(lastIP)
+}
+
 // NetworkRange calculates the first and last IP addresses in an IPNet
 func NetworkRange(network *net.IPNet) (net.IP, net.IP) {
 	var netIP net.IP
@@ -115,6 +145,15 @@ func GetIfaceAddr(name string) (net.Addr, []net.Addr, error) {
 	return addrs4[0], addrs6, nil
 }
 
+// InterfaceMTU returns the MTU of the specified network interface.
+func InterfaceMTU(name string) (int, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, err
+	}
+	return iface.MTU, nil
+}
+
 // GenerateRandomMAC returns a random MAC address
 func GenerateRandomMAC() net.HardwareAddr {
 	hw := make(net.HardwareAddr, 6)
@@ -125,3 +164,73 @@ func GenerateRandomMAC() net.HardwareAddr {
 	hw[0] |= 0x2  // set local assignment bit (IEEE802)
 	return hw
 }
+
+// GenerateMACFromIP returns a locally administered MAC address where the 4
+// least significant bytes are derived from the IPv4 address.
+func GenerateMACFromIP(ip net.IP) net.HardwareAddr {
+	hw := make(net.HardwareAddr, 6)
+
+	// The first byte of the MAC address has to comply with these rules:
+	// 1. Unicast: Set the least-significant bit to 0.
+	// 2. Address is locally administered: Set the second-least-significant
+	// bit (U/L) to 1.
+	hw[0] = 0x02
+
+	// The second byte of the MAC address can be any value, use the same
+	// value as Docker does.
+	hw[1] = 0x42
+
+	// Fill the remaining 4 bytes based on the input.
+	copy(hw[2:], ip.To4())
+	return hw
+}
+
+// GenerateRandomName returns an interface name made of prefix followed by
+// length random hexadecimal digits. The name must fit in the kernel limit of
+// 15 bytes.
+func GenerateRandomName(prefix string, length int) (string, error) {
+	if length <= 0 || len(prefix)+length > maxIfaceNameLen {
+		return "", fmt.Errorf("invalid interface name length %d for prefix %q, at most %d bytes are allowed", length, prefix, maxIfaceNameLen)
+	}
+
+	id := make([]byte, (length+1)/2)
+	if _, err := crand.Read(id); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(id)[:length], nil
+}
+
+// GenerateRandomCIDR returns a random private IPv4 network with the given
+// prefix length which doesn't overlap with any existing route
+func GenerateRandomCIDR(mask int) (*net.IPNet, error) {
+	var candidates []*net.IPNet
+	for _, n := range privateNetworks {
+		if ones, _ := n.Mask.Size(); ones <= mask && mask <= 32 {
+			candidates = append(candidates, n)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("invalid prefix length %d for a private network", mask)
+	}
+
+	for i := 0; i < maxRandomCIDRAttempts; i++ {
+		base := candidates[rand.Intn(len(candidates))]
+		hostBits := ^binary.BigEndian.Uint32(base.Mask)
+
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base.IP)|rand.Uint32()&hostBits)
+
+		network := &net.IPNet{Mask: net.CIDRMask(mask, 32)}
+		network.IP = ip.Mask(network.Mask)
+
+		err := CheckRouteOverlaps(network)
+		if err == nil {
+			return network, nil
+		}
+		if err != ErrNetworkOverlaps {
+			return nil, err
+		}
+	}
+
+	return nil, ErrNoAvailableNetwork
+}
@@ -1,7 +1,9 @@
 package netutils
 
 import (
+	"bytes"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/vishvananda/netlink"
@@ -111,6 +113,34 @@ func TestNetworkOverlaps(t *testing.T) {
 	AssertNoOverlap("172.16.1.1/25", "172.16.0.1/24", t)
 	//netX starts and ends before netY
 	AssertNoOverlap("172.16.1.1/25", "172.16.2.1/24", t)
+
+	// IPv4 addresses in their 16 bytes form
+	netX := &net.IPNet{IP: net.ParseIP("172.16.1.1"), Mask: net.CIDRMask(24, 32)}
+	_, netY, _ := net.ParseCIDR("172.16.0.0/16")
+	if !NetworkOverlaps(netX, netY) {
+		t.Errorf("%v and %v should overlap", netX, netY)
+	}
+	//IPv4 and IPv6 networks never overlap
+	AssertNoOverlap("0.0.0.0/0", "::/0", t)
+}
+
+func TestNetworkContains(t *testing.T) {
+	for _, c := range []struct {
+		outer, inner string
+		contains     bool
+	}{
+		{"192.168.0.0/16", "192.168.2.0/24", true},
+		{"192.168.0.0/24", "192.168.0.0/24", true},
+		{"192.168.1.0/24", "192.168.2.0/24", false},
+		{"192.168.1.0/24", "192.168.0.0/16", false},
+		{"192.168.1.0/24", "fe80::/64", false},
+	} {
+		_, outer, _ := net.ParseCIDR(c.outer)
+		_, inner, _ := net.ParseCIDR(c.inner)
+		if NetworkContains(outer, inner) != c.contains {
+			t.Errorf("Unexpected containment of %s in %s, expected %v", inner, outer, c.contains)
+		}
+	}
 }
 
 func TestNetworkRange(t *testing.T) {
@@ -174,3 +204,108 @@ func TestNetworkRange(t *testing.T) {
 		t.Error(last.String())
 	}
 }
+
+func TestGenerateMACFromIP(t *testing.T) {
+	ip := net.ParseIP("172.17.0.2")
+
+	mac := GenerateMACFromIP(ip)
+	if mac.String() != "02:42:ac:11:00:02" {
+		t.Fatalf("Unexpected MAC address %s for IP %s", mac, ip)
+	}
+	if mac[0]&0x01 != 0 {
+		t.Fatalf("MAC address %s has the multicast bit set", mac)
+	}
+	if mac[0]&0x02 == 0 {
+		t.Fatalf("MAC address %s is not locally administered", mac)
+	}
+
+	if again := GenerateMACFromIP(net.ParseIP("172.17.0.2")); !bytes.Equal(mac, again) {
+		t.Fatalf("Same IP generated different MAC addresses %s and %s", mac, again)
+	}
+	if other := GenerateMACFromIP(net.ParseIP("172.17.0.3")); bytes.Equal(mac, other) {
+		t.Fatalf("Different IPs generated the same MAC address %s", mac)
+	}
+}
+
+func TestGenerateRandomName(t *testing.T) {
+	for _, length := range []int{1, 7, 11} {
+		name, err := GenerateRandomName("veth", length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(name, "veth") {
+			t.Fatalf("Expected name %q to start with veth", name)
+		}
+		if len(name) != len("veth")+length || len(name) > 15 {
+			t.Fatalf("Expected name %q to be %d bytes long", name, len("veth")+length)
+		}
+		if strings.Trim(name[len("veth"):], "0123456789abcdef") != "" {
+			t.Fatalf("Expected name %q to have a hexadecimal suffix", name)
+		}
+	}
+
+	for _, length := range []int{0, 11} {
+		if _, err := GenerateRandomName("vethx", length); err == nil {
+			t.Fatalf("Expected an error generating a name of %d random digits after vethx", length)
+		}
+	}
+}
+
+func TestGenerateRandomCIDR(t *testing.T) {
+	orig := networkGetRoutesFct
+	defer func() {
+		networkGetRoutesFct = orig
+	}()
+
+	routesData := []string{"10.0.0.0/8", "172.16.0.0/12"}
+	networkGetRoutesFct = func(netlink.Link, int) ([]netlink.Route, error) {
+		routes := []netlink.Route{}
+		for _, addr := range routesData {
+			_, netX, _ := net.ParseCIDR(addr)
+			routes = append(routes, netlink.Route{Dst: netX})
+		}
+		return routes, nil
+	}
+
+	for i := 0; i < 10; i++ {
+		network, err := GenerateRandomCIDR(24)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ones, _ := network.Mask.Size(); ones != 24 {
+			t.Fatalf("Expected a /24 network, got %s", network)
+		}
+		_, free, _ := net.ParseCIDR("192.168.0.0/16")
+		if !NetworkContains(free, network) {
+			t.Fatalf("Network %s overlaps with an existing route", network)
+		}
+	}
+
+	// No room left once all of the private ranges are routed.
+	routesData = append(routesData, "192.168.0.0/16")
+	if _, err := GenerateRandomCIDR(24); err != ErrNoAvailableNetwork {
+		t.Fatalf("Expected ErrNoAvailableNetwork, got %v", err)
+	}
+
+	if _, err := GenerateRandomCIDR(4); err == nil {
+		t.Fatal("Expected a /4 private network to be rejected")
+	}
+}
+
+func TestInterfaceMTU(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtu, err := InterfaceMTU("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu != lo.MTU {
+		t.Fatalf("Expected MTU %d, got %d", lo.MTU, mtu)
+	}
+
+	if _, err := InterfaceMTU("nosuchlink"); err == nil {
+		t.Fatal("Expected an error for a missing interface")
+	}
+}
@@ -7,7 +7,7 @@ controller := libnetwork.New()
 
 options := options.Generic{}
 // Create a network for containers to join.
-network, err := controller.NewNetwork("simplebridge", "network1", options)
+network, err := controller.NewNetwork(context.Background(), "simplebridge", "network1", options)
 if err != nil {
 	return
 }
@@ -23,7 +23,7 @@ if err != nil {
 // For each new container: allocate IP and interfaces. The returned network
 // settings will be used for container infos (inspect and such), as well as
 // iptables rules for port publishing.
-_, sinfo, err := network.CreateEndpoint("Endpoint1", networkNamespace.Key(), "")
+_, sinfo, err := network.CreateEndpoint(context.Background(), "Endpoint1", networkNamespace.Key(), "")
 if err != nil {
 	return
 }
@@ -43,21 +43,90 @@ if err := networkNamespace.SetGateway(sinfo.Gateway); err != nil {
 package libnetwork
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
 	"sync"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/common"
+	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"golang.org/x/net/context"
 )
 
 // NetworkController provides the interface for controller instance which manages
 // networks.
 type NetworkController interface {
-	// Create a new network. The options parameter carry driver specific options.
-	// Labels support will be added in the near future.
-	NewNetwork(networkType, name string, options interface{}) (Network, error)
+	// Register a driver for the specified network type, making it available
+	// to NewNetwork. Registering the same network type twice fails.
+	RegisterDriver(networkType string, d driverapi.Driver) error
+
+	// Return the sorted network types of the registered drivers, built-in
+	// ones included.
+	Drivers() []string
+
+	// Create a new network. The options parameter carry driver specific options,
+	// while netOptions configure the network itself, such as its labels.
+	// An empty networkType selects the controller's default driver. The
+	// creation is abandoned as soon as ctx is done.
+	NewNetwork(ctx context.Context, networkType, name string, options interface{}, netOptions ...NetworkOption) (Network, error)
+
+	// Return the network identified by the specified name.
+	NetworkByName(name string) (Network, error)
+
+	// Return the network identified by the specified id.
+	NetworkByID(id string) (Network, error)
+
+	// Return a snapshot of the networks managed by this controller.
+	Networks() []Network
+
+	// Return the networks whose label key is set to value.
+	NetworksByLabel(key, value string) []Network
+
+	// Call the walker function for each network managed by this controller,
+	// stopping as soon as the walker returns true.
+	WalkNetworks(walker NetworkWalker)
+
+	// Call the walker function for each endpoint of each network managed by
+	// this controller, stopping as soon as the walker returns true.
+	WalkEndpoints(walker EndpointWalker)
+
+	// Create an endpoint named after sboxKey on each of the networks, and
+	// return them along with the combined settings to apply to the sandbox.
+	// The interfaces are named eth0, eth1, etc. in the order of the
+	// networks, and the first network providing a gateway provides the
+	// default route. On failure the endpoints already created are deleted.
+	JoinNetworks(ctx context.Context, sboxKey string, networks ...Network) ([]Endpoint, *driverapi.SandboxInfo, error)
+
+	// Subscribe to the creations and deletions of networks and endpoints.
+	// Events are buffered per subscriber, the oldest ones being dropped
+	// when the subscriber lags behind.
+	Events() <-chan Event
+
+	// Cancel a subscription returned by Events, closing its channel.
+	StopEvents(events <-chan Event)
+
+	// Delete all the networks managed by this controller and release its
+	// datastore. Networks with active endpoints are left alone, unless the
+	// controller was created with OptionForceClose. The event subscriptions
+	// are closed. The errors met along the way are aggregated in the
+	// returned one.
+	Close() error
 }
 
+// NetworkWalker is a client provided function which will be used to walk the
+// Networks. When the function returns true, the walk will stop.
+type NetworkWalker func(nw Network) bool
+
+// EndpointWalker is a client provided function which will be used to walk the
+// Endpoints of all the Networks. When the function returns true, the walk will
+// stop.
+type EndpointWalker func(nw Network, ep Endpoint) bool
+
 // A Network represents a logical connectivity zone that containers may
 // ulteriorly join using the Link method. A Network is managed by a specific
 // driver.
@@ -65,32 +134,231 @@ type Network interface {
 	// A user chosen name for this network.
 	Name() string
 
+	// Rename the network, unless another network goes by name already. The
+	// driver isn't involved, as it only knows the network by id.
+	SetName(name string) error
+
 	// A system generated id for this network.
 	ID() string
 
 	// The type of network, which corresponds to its managing driver.
 	Type() string
 
+	// A copy of the labels set on the network at creation.
+	Labels() map[string]string
+
 	// Create a new endpoint to this network symbolically identified by the
-	// specified unique name. The options parameter carry driver specific options.
-	// Labels support will be added in the near future.
-	CreateEndpoint(name string, sboxKey string, options interface{}) (Endpoint, *driverapi.SandboxInfo, error)
+	// specified unique name. The options parameter carry driver specific options,
+	// while epOptions configure the endpoint itself, such as its labels. The
+	// creation is abandoned as soon as ctx is done.
+	//
+	// An empty sboxKey reserves the endpoint resources, such as its
+	// addresses, before the sandbox exists: the returned SandboxInfo describes
+	// the interfaces to move, and the endpoint gets wired with a later Join.
+	CreateEndpoint(ctx context.Context, name string, sboxKey string, options interface{}, epOptions ...EndpointOption) (Endpoint, *driverapi.SandboxInfo, error)
+
+	// Return a snapshot of the endpoints attached to this network.
+	Endpoints() []Endpoint
+
+	// Return the number of endpoints attached to this network, which must
+	// be zero for Delete to succeed.
+	EndpointCount() int
+
+	// Return the endpoint identified by the specified name.
+	EndpointByName(name string) (Endpoint, error)
+
+	// Delete the endpoint identified by the specified name, as its Delete
+	// method would.
+	DeleteEndpointByName(name string) error
+
+	// Return the endpoints whose label key is set to value.
+	EndpointsByLabel(key, value string) []Endpoint
+
+	// Return the addressing of the network as reported by its driver.
+	Info() NetworkInfo
+
+	// Leave and delete all the endpoints of the network, carrying on past
+	// failures. The endpoints which fail to be deleted stay attached to the
+	// network, and there is one error per such endpoint in the returned
+	// slice, which is empty when they all got deleted.
+	DeleteEndpoints() []error
 
 	// Delete the network.
 	Delete() error
 }
 
+// NetworkInfo describes the addressing of a network, including the subnets
+// and gateways its driver picked by itself.
+type NetworkInfo struct {
+	// The type of network, which corresponds to its managing driver.
+	Type string
+
+	// IPv4 subnet and gateway, nil if the network has none.
+	Subnet  *net.IPNet
+	Gateway net.IP
+
+	// IPv6 subnet and gateway, nil if the network has none.
+	SubnetIPv6  *net.IPNet
+	GatewayIPv6 net.IP
+
+	// Address usage of the subnets of the network, from which the share of
+	// used addresses can be computed. Empty if the driver doesn't report it.
+	Utilization []driverapi.SubnetUtilization
+}
+
 // Endpoint represents a logical connection between a network and a sandbox.
 type Endpoint interface {
-	// Delete endpoint.
+	// Join the sandbox identified by the specified key, which must not be
+	// empty. The options parameter carry driver specific options.
+	Join(sboxKey string, options interface{}) (*driverapi.SandboxInfo, error)
+
+	// Leave the sandbox identified by the specified key.
+	Leave(sboxKey string) error
+
+	// A copy of the labels set on the endpoint at creation.
+	Labels() map[string]string
+
+	// Return the traffic counters of the endpoint, as reported by its
+	// driver.
+	Statistics() (*Stats, error)
+
+	// Return a copy of the settings to apply to the sandbox, as returned by
+	// CreateEndpoint or the last Join, or nil if the endpoint isn't joined to
+	// any sandbox.
+	Info() *driverapi.SandboxInfo
+
+	// Return the number of sandboxes the endpoint joined through Join and
+	// didn't leave yet. The sandbox passed to CreateEndpoint isn't counted.
+	JoinCount() int
+
+	// Return a copy of the names the endpoint is known by for service
+	// discovery, its hostname first followed by its aliases, as set on
+	// creation with EndpointOptionDNSNames.
+	DNSNames() []string
+
+	// Delete endpoint. The deletion is refused while the endpoint is joined
+	// to sandboxes through Join, which would otherwise keep interfaces
+	// whose endpoint is gone.
 	Delete() error
 }
 
+// Stats holds the traffic counters of an endpoint, as seen from the host side
+// of its interface.
+type Stats struct {
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+// NetworkOption is a configuration function applied to a network when it
+// gets created.
+type NetworkOption func(n *network)
+
+// NetworkOptionLabels sets the labels of the network. The map is copied.
+func NetworkOptionLabels(labels map[string]string) NetworkOption {
+	return func(n *network) {
+		n.labels = copyLabels(labels)
+	}
+}
+
+// NetworkOptionMultipleEndpointsPerSandbox lets several endpoints of the
+// network be created for the same sandbox key. A sandbox key is claimed by a
+// single endpoint of the network by default.
+func NetworkOptionMultipleEndpointsPerSandbox() NetworkOption {
+	return func(n *network) {
+		n.multipleEndpointsPerSandbox = true
+	}
+}
+
+// EndpointOption is a configuration function applied to an endpoint when it
+// gets created.
+type EndpointOption func(ep *endpoint)
+
+// EndpointOptionLabels sets the labels of the endpoint. The map is copied.
+func EndpointOptionLabels(labels map[string]string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.labels = copyLabels(labels)
+	}
+}
+
+// EndpointOptionDNSNames sets the hostname and aliases the endpoint is known
+// by for service discovery. They must be RFC 1123 labels, and are checked by
+// CreateEndpoint.
+func EndpointOptionDNSNames(hostname string, aliases ...string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.dnsNames = append([]string{hostname}, aliases...)
+	}
+}
+
+// EndpointOptionMultipleSandboxes lets the endpoint be joined to more than one
+// sandbox at the same time, provided the driver supports it.
+func EndpointOptionMultipleSandboxes() EndpointOption {
+	return func(ep *endpoint) {
+		ep.multipleSandboxes = true
+	}
+}
+
+var (
+	// ErrAmbiguousNetworkName is returned when more than one network matches
+	// the name passed to a lookup.
+	ErrAmbiguousNetworkName = errors.New("more than one network matches the name")
+	// ErrAmbiguousEndpointName is returned when more than one endpoint of the
+	// network matches the name passed to a lookup.
+	ErrAmbiguousEndpointName = errors.New("more than one endpoint matches the name")
+	// ErrNetworkDeleting is returned when operating on a network which is
+	// being deleted.
+	ErrNetworkDeleting = errors.New("network is being deleted")
+	// ErrEndpointDeleting is returned when operating on an endpoint which is
+	// being deleted.
+	ErrEndpointDeleting = errors.New("endpoint is being deleted")
+	// ErrNoSandboxKey is returned when joining a sandbox without a key.
+	ErrNoSandboxKey = errors.New("no sandbox key")
+)
+
+// generateID is the source of the network and endpoint ids.
+var generateID = common.GenerateRandomID
+
+// maxIDAttempts is the number of ids generated before giving up on finding one
+// which isn't in use.
+const maxIDAttempts = 10
+
+// uniqueID returns an id which inUse doesn't report as taken.
+func uniqueID(inUse func(driverapi.UUID) bool) (driverapi.UUID, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id := driverapi.UUID(generateID())
+		if !inUse(id) {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate a unique id after %d attempts", maxIDAttempts)
+}
+
+// lifecycleState tells whether a network or an endpoint is usable, or on its
+// way out. Objects are active from their creation until Delete starts. An
+// endpoint is only known to its network while its driver creates it, so that
+// its id can't be handed out twice.
+type lifecycleState int
+
+const (
+	stateActive lifecycleState = iota
+	stateDeleting
+	stateDeleted
+	stateCreating
+)
+
 type endpoint struct {
-	name        string
-	id          driverapi.UUID
-	network     *network
-	sandboxInfo *driverapi.SandboxInfo
+	name              string
+	id                driverapi.UUID
+	network           *network
+	sandboxInfo       *driverapi.SandboxInfo
+	sandboxKeys       map[string]struct{}
+	joinedKeys        map[string]struct{}
+	multipleSandboxes bool
+	labels            map[string]string
+	dnsNames          []string // The hostname followed by the aliases.
+	state             lifecycleState
+	sync.Mutex
 }
 
 type network struct {
@@ -99,49 +367,483 @@ type network struct {
 	networkType string
 	id          driverapi.UUID
 	endpoints   map[driverapi.UUID]*endpoint
+	labels      map[string]string
+	// The sandbox keys claimed by the endpoints of the network, and the
+	// endpoint holding each, unless endpoints may share sandboxes.
+	sandboxKeys                 map[string]driverapi.UUID
+	multipleEndpointsPerSandbox bool
+	// The number of endpoints being created, which prevent the deletion of
+	// the network. Endpoints can only be created while the network is active.
+	creatingEndpoints int
+	state             lifecycleState
 	sync.Mutex
 }
 
 type networkTable map[driverapi.UUID]*network
 
+// Locks are always taken in the same order to prevent deadlocks: the lock of
+// the controller first, then the one of a network, then the one of an
+// endpoint. A method holding the lock of a network must thus not call one
+// taking the controller lock, such as network.Name, and the same goes for
+// endpoints. The event hub lock is only ever taken last.
 type controller struct {
-	networks networkTable
-	drivers  driverTable
+	networks            networkTable
+	drivers             driverTable
+	defaultDriver       string
+	allowDuplicateNames bool
+	forceClose          bool
+	store               datastore.DataStore
+	logger              driverapi.Logger
+	events              eventHub
 	sync.Mutex
 }
 
-// New creates a new instance of network controller.
-func New() NetworkController {
-	return &controller{networkTable{}, enumerateDrivers(), sync.Mutex{}}
+// Option is a configuration function applied to the controller by New.
+type Option func(c *controller)
+
+// OptionDefaultDriver sets the network type used by NewNetwork when none is
+// specified.
+func OptionDefaultDriver(networkType string) Option {
+	return func(c *controller) {
+		c.defaultDriver = networkType
+	}
+}
+
+// OptionDriver registers a driver for the specified network type, replacing
+// any built-in driver of the same type. A driver whose network type isn't a
+// valid driver name is ignored.
+func OptionDriver(networkType string, d driverapi.Driver) Option {
+	return func(c *controller) {
+		if err := driverapi.ValidateName(networkType); err != nil {
+			log.Warnf("Ignoring driver option: %v", err)
+			return
+		}
+		c.drivers[networkType] = d
+	}
+}
+
+// OptionAllowDuplicateNames lets the controller create several networks
+// sharing the same name. Names are unique by default.
+func OptionAllowDuplicateNames() Option {
+	return func(c *controller) {
+		c.allowDuplicateNames = true
+	}
+}
+
+// OptionForceClose makes Close leave and delete the endpoints of the networks
+// instead of failing to delete the networks which have some.
+func OptionForceClose() Option {
+	return func(c *controller) {
+		c.forceClose = true
+	}
+}
+
+// OptionLogger hands logger to the drivers, for them to report the changes
+// they make to the host. Nothing is logged by default.
+func OptionLogger(logger driverapi.Logger) Option {
+	return func(c *controller) {
+		c.logger = logger
+	}
+}
+
+// ErrNoSuchDriver is returned when no driver is registered for the network
+// type it holds.
+type ErrNoSuchDriver string
+
+func (networkType ErrNoSuchDriver) Error() string {
+	return fmt.Sprintf("unknown driver %q", string(networkType))
+}
+
+// ErrNoSuchNetwork is returned when no network managed by the controller
+// matches the name or id it holds.
+type ErrNoSuchNetwork string
+
+func (nw ErrNoSuchNetwork) Error() string {
+	return fmt.Sprintf("no such network %s", string(nw))
+}
+
+// ErrNoSuchEndpoint is returned when no endpoint of the network matches the
+// name or id it holds.
+type ErrNoSuchEndpoint string
+
+func (ep ErrNoSuchEndpoint) Error() string {
+	return fmt.Sprintf("no such endpoint %s", string(ep))
+}
+
+// NetworkNameError is returned when a network with the same name already
+// exists.
+type NetworkNameError string
+
+func (name NetworkNameError) Error() string {
+	return fmt.Sprintf("network with name %s already exists", string(name))
+}
+
+// New creates a new instance of network controller. The built-in drivers are
+// registered by default, more can be added with RegisterDriver.
+func New(opts ...Option) NetworkController {
+	c := &controller{networks: networkTable{}, drivers: enumerateDrivers()}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.logger != nil {
+		for _, d := range c.drivers {
+			if lu, ok := d.(driverapi.LoggerUser); ok {
+				lu.SetLogger(c.logger)
+			}
+		}
+	}
+
+	if c.store != nil {
+		for _, d := range c.drivers {
+			if su, ok := d.(driverapi.StoreUser); ok {
+				su.SetStore(c.store)
+			}
+		}
+		if err := c.restore(); err != nil {
+			log.Errorf("Failed to restore networks from the store: %v", err)
+		}
+	}
+	return c
+}
+
+func (c *controller) RegisterDriver(networkType string, d driverapi.Driver) error {
+	if err := driverapi.ValidateName(networkType); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.drivers[networkType]; ok {
+		return fmt.Errorf("driver %q is already registered", networkType)
+	}
+	if su, ok := d.(driverapi.StoreUser); ok && c.store != nil {
+		su.SetStore(c.store)
+	}
+	if lu, ok := d.(driverapi.LoggerUser); ok && c.logger != nil {
+		lu.SetLogger(c.logger)
+	}
+	c.drivers[networkType] = d
+	return nil
+}
+
+func (c *controller) Drivers() []string {
+	c.Lock()
+	defer c.Unlock()
+	types := make([]string, 0, len(c.drivers))
+	for networkType := range c.drivers {
+		types = append(types, networkType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// driver returns the driver registered for networkType. The driver table is
+// only ever accessed with the controller lock held, as RegisterDriver may
+// update it at any time.
+func (c *controller) driver(networkType string) (driverapi.Driver, bool) {
+	c.Lock()
+	defer c.Unlock()
+	d, ok := c.drivers[networkType]
+	return d, ok
 }
 
 // NewNetwork creates a new network of the specified networkType. The options
 // are driver specific and modeled in a generic way.
-func (c *controller) NewNetwork(networkType, name string, options interface{}) (Network, error) {
+func (c *controller) NewNetwork(ctx context.Context, networkType, name string, options interface{}, netOptions ...NetworkOption) (Network, error) {
+	var err error
+
+	if networkType == "" {
+		networkType = c.defaultDriver
+	}
+
 	network := &network{name: name, networkType: networkType}
-	network.id = driverapi.UUID(common.GenerateRandomID())
+	if network.id, err = c.newNetworkID(); err != nil {
+		return nil, err
+	}
 	network.ctrlr = c
+	network.endpoints = make(map[driverapi.UUID]*endpoint)
+	network.sandboxKeys = make(map[string]driverapi.UUID)
+	for _, opt := range netOptions {
+		opt(network)
+	}
 
-	d, ok := c.drivers[networkType]
+	d, ok := c.driver(networkType)
 	if !ok {
-		return nil, fmt.Errorf("unknown driver %q", networkType)
+		return nil, ErrNoSuchDriver(networkType)
 	}
 
-	if err := d.CreateNetwork(network.id, options); err != nil {
+	if err = c.checkNetworkCapabilities(networkType, d.Capabilities(), options); err != nil {
 		return nil, err
 	}
 
-	c.Lock()
-	c.networks[network.id] = network
-	c.Unlock()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = d.CreateNetwork(ctx, network.id, options); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// On failure make sure the driver releases what it just allocated.
+		if err != nil {
+			if e := d.DeleteNetwork(network.id); e != nil {
+				log.Warnf("Failed to roll back creation of network %s id %s: %v", name, network.id, e)
+			}
+		}
+	}()
+
+	if err = c.addNetwork(network); err != nil {
+		return nil, err
+	}
+
+	if err = c.storeNetwork(network); err != nil {
+		c.Lock()
+		delete(c.networks, network.id)
+		c.Unlock()
+		return nil, err
+	}
 
+	c.events.emit(networkEvent(EventNetworkCreate, network))
 	return network, nil
 }
 
+// newNetworkID returns an id no network of the controller has. A concurrent
+// NewNetwork drawing the same id fails in addNetwork.
+func (c *controller) newNetworkID() (driverapi.UUID, error) {
+	c.Lock()
+	defer c.Unlock()
+	return uniqueID(func(id driverapi.UUID) bool {
+		_, ok := c.networks[id]
+		return ok
+	})
+}
+
+func (c *controller) addNetwork(n *network) error {
+	// The name check and the insertion happen under the same lock so that two
+	// concurrent calls can't both succeed with the same name.
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.networks[n.id]; ok {
+		return fmt.Errorf("network with id %s already exists", n.id)
+	}
+	if !c.allowDuplicateNames {
+		for _, nw := range c.networks {
+			if nw.name == n.name {
+				return NetworkNameError(n.name)
+			}
+		}
+	}
+	c.networks[n.id] = n
+	return nil
+}
+
+func (c *controller) NetworkByName(name string) (Network, error) {
+	var found *network
+
+	c.Lock()
+	defer c.Unlock()
+	for _, n := range c.networks {
+		if n.name != name {
+			continue
+		}
+		if found != nil {
+			return nil, ErrAmbiguousNetworkName
+		}
+		found = n
+	}
+
+	if found == nil {
+		return nil, ErrNoSuchNetwork(name)
+	}
+	return found, nil
+}
+
+func (c *controller) NetworkByID(id string) (Network, error) {
+	c.Lock()
+	defer c.Unlock()
+	if n, ok := c.networks[driverapi.UUID(id)]; ok {
+		return n, nil
+	}
+	return nil, ErrNoSuchNetwork(id)
+}
+
+func (c *controller) Networks() []Network {
+	c.Lock()
+	defer c.Unlock()
+
+	list := make([]Network, 0, len(c.networks))
+	for _, n := range c.networks {
+		list = append(list, n)
+	}
+	return list
+}
+
+func (c *controller) NetworksByLabel(key, value string) []Network {
+	var list []Network
+	for _, n := range c.Networks() {
+		if v, ok := n.(*network).labels[key]; ok && v == value {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+func (c *controller) WalkNetworks(walker NetworkWalker) {
+	// Networks returns a copy, so the walker is invoked without holding the
+	// controller lock and is free to call back into the controller.
+	for _, n := range c.Networks() {
+		if walker(n) {
+			return
+		}
+	}
+}
+
+func (c *controller) WalkEndpoints(walker EndpointWalker) {
+	type networkEndpoint struct {
+		nw Network
+		ep Endpoint
+	}
+
+	// The endpoints are all collected before the walker is first invoked, so
+	// that no lock is held while it runs.
+	var list []networkEndpoint
+	for _, n := range c.Networks() {
+		for _, ep := range n.Endpoints() {
+			list = append(list, networkEndpoint{n, ep})
+		}
+	}
+	for _, ne := range list {
+		if walker(ne.nw, ne.ep) {
+			return
+		}
+	}
+}
+
+func (c *controller) JoinNetworks(ctx context.Context, sboxKey string, networks ...Network) ([]Endpoint, *driverapi.SandboxInfo, error) {
+	var (
+		eps []Endpoint
+		err error
+	)
+
+	defer func() {
+		if err != nil {
+			for _, ep := range eps {
+				if e := ep.Delete(); e != nil {
+					log.Warnf("Failed to roll back endpoint of sandbox %s: %v", sboxKey, e)
+				}
+			}
+		}
+	}()
+
+	sinfo := &driverapi.SandboxInfo{}
+	for _, nw := range networks {
+		if n, ok := nw.(*network); !ok || n.ctrlr != c {
+			err = fmt.Errorf("network %s is not managed by this controller", nw.Name())
+			return nil, nil, err
+		}
+
+		var (
+			ep     Endpoint
+			epInfo *driverapi.SandboxInfo
+		)
+		if ep, epInfo, err = nw.CreateEndpoint(ctx, sboxKey, sboxKey, nil); err != nil {
+			return nil, nil, err
+		}
+		eps = append(eps, ep)
+		if epInfo == nil {
+			continue
+		}
+
+		// Every driver names its interfaces from eth0, so number them
+		// again across networks to avoid collisions in the sandbox. The
+		// interfaces belong to the driver and get renamed as copies.
+		for _, iface := range epInfo.Interfaces {
+			ifc := *iface
+			ifc.DstName = fmt.Sprintf("eth%d", len(sinfo.Interfaces))
+			sinfo.Interfaces = append(sinfo.Interfaces, &ifc)
+		}
+		if sinfo.Gateway == "" {
+			sinfo.Gateway = epInfo.Gateway
+		}
+		if sinfo.GatewayIPv6 == "" {
+			sinfo.GatewayIPv6 = epInfo.GatewayIPv6
+		}
+		sinfo.PortBindings = append(sinfo.PortBindings, epInfo.PortBindings...)
+	}
+
+	return eps, sinfo, nil
+}
+
+func (c *controller) Close() error {
+	var errs []string
+
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		if c.forceClose {
+			for _, err := range n.DeleteEndpoints() {
+				errs = append(errs, fmt.Sprintf("network %s: %v", n.Name(), err))
+			}
+		}
+		if err := n.Delete(); err != nil {
+			errs = append(errs, fmt.Sprintf("network %s: %v", n.Name(), err))
+		}
+	}
+
+	c.events.unsubscribeAll()
+
+	if closer, ok := c.store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("datastore: %v", err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to close controller: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (c *controller) Events() <-chan Event {
+	return c.events.subscribe()
+}
+
+func (c *controller) StopEvents(events <-chan Event) {
+	c.events.unsubscribe(events)
+}
+
+// Name returns the name of the network. The name is guarded by the controller
+// lock, as the uniqueness of the names is checked under it.
 func (n *network) Name() string {
+	n.ctrlr.Lock()
+	defer n.ctrlr.Unlock()
 	return n.name
 }
 
+func (n *network) SetName(name string) error {
+	c := n.ctrlr
+	c.Lock()
+	if _, ok := c.networks[n.id]; !ok {
+		c.Unlock()
+		return ErrNoSuchNetwork(n.id)
+	}
+	if !c.allowDuplicateNames {
+		for _, nw := range c.networks {
+			if nw != n && nw.name == name {
+				c.Unlock()
+				return NetworkNameError(name)
+			}
+		}
+	}
+	n.name = name
+	c.Unlock()
+
+	if err := c.storeNetwork(n); err != nil {
+		log.Warnf("Failed to update network %s id %s in the store: %v", name, n.id, err)
+	}
+	return nil
+}
+
 func (n *network) ID() string {
 	return string(n.id)
 }
@@ -150,79 +852,407 @@ func (n *network) Type() string {
 	return n.networkType
 }
 
-func (n *network) Delete() error {
-	var err error
+func (n *network) Labels() map[string]string {
+	return copyLabels(n.labels)
+}
 
-	d, ok := n.ctrlr.drivers[n.networkType]
+// Info queries the driver for the addressing of the network. Should the driver
+// fail to report it, only the network type is filled in.
+func (n *network) Info() NetworkInfo {
+	info := NetworkInfo{Type: n.networkType}
+
+	d, ok := n.ctrlr.driver(n.networkType)
 	if !ok {
-		return fmt.Errorf("unknown driver %q", n.networkType)
+		return info
 	}
 
-	n.ctrlr.Lock()
-	_, ok = n.ctrlr.networks[n.id]
-	if !ok {
-		n.ctrlr.Unlock()
-		return fmt.Errorf("unknown network %s id %s", n.name, n.id)
+	dinfo, err := d.NetworkInfo(n.id)
+	if err != nil {
+		log.Warnf("Failed to get the info of network %s id %s: %v", n.Name(), n.id, err)
+		return info
 	}
 
-	n.Lock()
-	numEps := len(n.endpoints)
-	n.Unlock()
-	if numEps != 0 {
-		n.ctrlr.Unlock()
-		return fmt.Errorf("network %s has active endpoints", n.id)
+	info.Subnet = dinfo.Subnet
+	info.Gateway = dinfo.Gateway
+	info.SubnetIPv6 = dinfo.SubnetIPv6
+	info.GatewayIPv6 = dinfo.GatewayIPv6
+	info.Utilization = dinfo.Utilization
+	return info
+}
+
+func (n *network) DeleteEndpoints() []error {
+	var errs []error
+	for _, ep := range n.Endpoints() {
+		ep := ep.(*endpoint)
+		if err := ep.forceDelete(); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %s id %s: %v", ep.name, ep.id, err))
+		}
+	}
+	return errs
+}
+
+func (n *network) Delete() error {
+	var err error
+
+	d, ok := n.ctrlr.driver(n.networkType)
+	if !ok {
+		return ErrNoSuchDriver(n.networkType)
 	}
 
-	delete(n.ctrlr.networks, n.id)
-	n.ctrlr.Unlock()
+	if err = n.ctrlr.removeNetwork(n); err != nil {
+		return err
+	}
 	defer func() {
+		// On failure put the network back, unless its id or name got
+		// taken by a concurrent NewNetwork in the meantime.
+		n.Lock()
+		if err != nil {
+			n.state = stateActive
+		} else {
+			n.state = stateDeleted
+		}
+		n.Unlock()
 		if err != nil {
-			n.ctrlr.Lock()
-			n.ctrlr.networks[n.id] = n
-			n.ctrlr.Unlock()
+			if e := n.ctrlr.addNetwork(n); e != nil {
+				log.Warnf("Failed to restore network %s id %s after failed deletion: %v", n.Name(), n.id, e)
+			}
 		}
 	}()
 
-	err = d.DeleteNetwork(n.id)
-	return err
+	// A driver which lost track of the network, such as one which failed to
+	// restore it, has nothing left to delete.
+	if err = d.DeleteNetwork(n.id); err == driverapi.ErrNoNetwork {
+		log.Warnf("Driver of network %s id %s doesn't know it, deleting it anyway", n.Name(), n.id)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if e := n.ctrlr.deleteStoredNetwork(n); e != nil {
+		log.Warnf("Failed to remove network %s id %s from the store: %v", n.Name(), n.id, e)
+	}
+
+	n.ctrlr.events.emit(networkEvent(EventNetworkDelete, n))
+	return nil
+}
+
+// removeNetwork removes the network from the controller and marks it as being
+// deleted, unless it has endpoints. From then on CreateEndpoint fails, so no
+// endpoint shows up between the check and the removal.
+func (c *controller) removeNetwork(n *network) error {
+	c.Lock()
+	defer c.Unlock()
+	n.Lock()
+	defer n.Unlock()
+	if n.state == stateDeleting {
+		return ErrNetworkDeleting
+	}
+	if _, ok := c.networks[n.id]; !ok {
+		return ErrNoSuchNetwork(n.id)
+	}
+
+	var eps []string
+	for _, ep := range n.endpoints {
+		if !ep.created() {
+			continue
+		}
+		eps = append(eps, fmt.Sprintf("%s (id %s)", ep.name, ep.id))
+	}
+	if len(eps) != 0 {
+		return fmt.Errorf("network %s has %d active endpoints: %s", n.id, len(eps), strings.Join(eps, ", "))
+	}
+	if n.creatingEndpoints != 0 {
+		return fmt.Errorf("network %s has %d endpoints being created", n.id, n.creatingEndpoints)
+	}
+
+	n.state = stateDeleting
+	delete(c.networks, n.id)
+	return nil
 }
 
-func (n *network) CreateEndpoint(name string, sboxKey string, options interface{}) (Endpoint, *driverapi.SandboxInfo, error) {
+func (n *network) CreateEndpoint(ctx context.Context, name string, sboxKey string, options interface{}, epOptions ...EndpointOption) (Endpoint, *driverapi.SandboxInfo, error) {
 	ep := &endpoint{name: name}
-	ep.id = driverapi.UUID(common.GenerateRandomID())
 	ep.network = n
+	ep.sandboxKeys = make(map[string]struct{})
+	ep.joinedKeys = make(map[string]struct{})
+	if sboxKey != "" {
+		ep.sandboxKeys[sboxKey] = struct{}{}
+	}
+	for _, opt := range epOptions {
+		opt(ep)
+	}
+	if err := validateDNSNames(ep.dnsNames); err != nil {
+		return nil, nil, err
+	}
 
-	d, ok := n.ctrlr.drivers[n.networkType]
+	d, ok := n.ctrlr.driver(n.networkType)
 	if !ok {
-		return nil, nil, fmt.Errorf("unknown driver %q", n.networkType)
+		return nil, nil, ErrNoSuchDriver(n.networkType)
 	}
 
-	sinfo, err := d.CreateEndpoint(n.id, ep.id, sboxKey, options)
+	// Hold off the deletion of the network until the endpoint is created.
+	n.Lock()
+	if err := n.checkActive(); err != nil {
+		n.Unlock()
+		return nil, nil, err
+	}
+	id, err := uniqueID(func(id driverapi.UUID) bool {
+		_, ok := n.endpoints[id]
+		return ok
+	})
 	if err != nil {
+		n.Unlock()
+		return nil, nil, err
+	}
+	ep.id = id
+	ep.state = stateCreating
+	n.endpoints[ep.id] = ep
+	n.creatingEndpoints++
+	n.Unlock()
+	defer func() {
+		n.Lock()
+		n.creatingEndpoints--
+		if !ep.created() {
+			delete(n.endpoints, ep.id)
+		}
+		n.Unlock()
+	}()
+
+	if err := checkEndpointCapabilities(n.networkType, d.Capabilities(), options); err != nil {
+		return nil, nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := n.claimSandboxKey(sboxKey, ep.id); err != nil {
+		return nil, nil, err
+	}
+
+	sinfo, err := d.CreateEndpoint(ctx, n.id, ep.id, sboxKey, options)
+	if err != nil {
+		n.releaseSandboxKeys(ep.id)
 		return nil, nil, err
 	}
 
 	ep.sandboxInfo = sinfo
+	if err := n.ctrlr.storeEndpoint(ep); err != nil {
+		if e := d.DeleteEndpoint(n.id, ep.id); e != nil {
+			log.Warnf("Failed to roll back creation of endpoint %s id %s: %v", name, ep.id, e)
+		}
+		n.releaseSandboxKeys(ep.id)
+		return nil, nil, err
+	}
+
 	n.Lock()
-	n.endpoints[ep.id] = ep
+	ep.Lock()
+	ep.state = stateActive
+	ep.Unlock()
 	n.Unlock()
+
+	n.ctrlr.events.emit(endpointEvent(EventEndpointCreate, ep))
 	return ep, sinfo, nil
 }
 
+func (n *network) Endpoints() []Endpoint {
+	n.Lock()
+	defer n.Unlock()
+
+	list := make([]Endpoint, 0, len(n.endpoints))
+	for _, ep := range n.endpoints {
+		if ep.created() {
+			list = append(list, ep)
+		}
+	}
+	return list
+}
+
+func (n *network) EndpointCount() int {
+	n.Lock()
+	defer n.Unlock()
+	count := 0
+	for _, ep := range n.endpoints {
+		if ep.created() {
+			count++
+		}
+	}
+	return count
+}
+
+func (n *network) EndpointByName(name string) (Endpoint, error) {
+	var found *endpoint
+
+	n.Lock()
+	defer n.Unlock()
+	for _, ep := range n.endpoints {
+		if ep.name != name || !ep.created() {
+			continue
+		}
+		if found != nil {
+			return nil, ErrAmbiguousEndpointName
+		}
+		found = ep
+	}
+
+	if found == nil {
+		return nil, ErrNoSuchEndpoint(name)
+	}
+	return found, nil
+}
+
+func (n *network) DeleteEndpointByName(name string) error {
+	ep, err := n.EndpointByName(name)
+	if err != nil {
+		return err
+	}
+	return ep.Delete()
+}
+
+// checkActive returns the error to report when operating on a network which
+// isn't active, nil otherwise. It must be called with the network locked.
+func (n *network) checkActive() error {
+	switch n.state {
+	case stateDeleting:
+		return ErrNetworkDeleting
+	case stateDeleted:
+		return ErrNoSuchNetwork(n.id)
+	}
+	return nil
+}
+
+// claimSandboxKey makes eid the endpoint of the network created for sandbox
+// key, failing if another endpoint holds it already.
+func (n *network) claimSandboxKey(key string, eid driverapi.UUID) error {
+	if key == "" {
+		return nil
+	}
+
+	// The name is guarded by the controller lock, which is taken first.
+	name := n.Name()
+
+	n.Lock()
+	defer n.Unlock()
+	if n.multipleEndpointsPerSandbox {
+		return nil
+	}
+	if owner, ok := n.sandboxKeys[key]; ok && owner != eid {
+		return fmt.Errorf("sandbox %s already has endpoint %s on network %s", key, owner, name)
+	}
+	n.sandboxKeys[key] = eid
+	return nil
+}
+
+// releaseSandboxKeys drops the sandbox keys claimed by eid.
+func (n *network) releaseSandboxKeys(eid driverapi.UUID) {
+	n.Lock()
+	defer n.Unlock()
+	for key, owner := range n.sandboxKeys {
+		if owner == eid {
+			delete(n.sandboxKeys, key)
+		}
+	}
+}
+
+func (n *network) EndpointsByLabel(key, value string) []Endpoint {
+	var list []Endpoint
+	for _, ep := range n.Endpoints() {
+		if v, ok := ep.(*endpoint).labels[key]; ok && v == value {
+			list = append(list, ep)
+		}
+	}
+	return list
+}
+
+func (ep *endpoint) Labels() map[string]string {
+	return copyLabels(ep.labels)
+}
+
+func (ep *endpoint) DNSNames() []string {
+	return append([]string(nil), ep.dnsNames...)
+}
+
+func (ep *endpoint) Info() *driverapi.SandboxInfo {
+	ep.Lock()
+	defer ep.Unlock()
+	if len(ep.sandboxKeys) == 0 || ep.sandboxInfo == nil {
+		return nil
+	}
+
+	info := *ep.sandboxInfo
+	info.Interfaces = nil
+	for _, iface := range ep.sandboxInfo.Interfaces {
+		i := *iface
+		info.Interfaces = append(info.Interfaces, &i)
+	}
+	info.PortBindings = append([]driverapi.PortBinding(nil), ep.sandboxInfo.PortBindings...)
+	return &info
+}
+
+func (ep *endpoint) Statistics() (*Stats, error) {
+	n := ep.network
+	d, ok := n.ctrlr.driver(n.networkType)
+	if !ok {
+		return nil, ErrNoSuchDriver(n.networkType)
+	}
+
+	st, err := d.EndpointStatistics(n.id, ep.id)
+	if err != nil {
+		return nil, err
+	}
+	return &Stats{RxBytes: st.RxBytes, RxPackets: st.RxPackets, TxBytes: st.TxBytes, TxPackets: st.TxPackets}, nil
+}
+
+func (ep *endpoint) JoinCount() int {
+	ep.Lock()
+	defer ep.Unlock()
+	return len(ep.joinedKeys)
+}
+
 func (ep *endpoint) Delete() error {
+	return ep.delete(false)
+}
+
+// delete deletes the endpoint, even while joined to sandboxes when force is
+// set.
+func (ep *endpoint) delete(force bool) error {
 	var err error
 
-	d, ok := ep.network.ctrlr.drivers[ep.network.networkType]
+	d, ok := ep.network.ctrlr.driver(ep.network.networkType)
 	if !ok {
-		return fmt.Errorf("unknown driver %q", ep.network.networkType)
+		return ErrNoSuchDriver(ep.network.networkType)
 	}
 
+	ep.Lock()
+	if err = ep.checkActive(); err != nil {
+		ep.Unlock()
+		return err
+	}
+	if count := len(ep.joinedKeys); count != 0 && !force {
+		ep.Unlock()
+		err = fmt.Errorf("endpoint %s is still joined to %d sandboxes", ep.name, count)
+		return err
+	}
+	ep.state = stateDeleting
+	ep.Unlock()
+	defer func() {
+		ep.Lock()
+		if err != nil {
+			ep.state = stateActive
+		} else {
+			ep.state = stateDeleted
+		}
+		ep.Unlock()
+	}()
+
 	n := ep.network
 	n.Lock()
 	_, ok = n.endpoints[ep.id]
 	if !ok {
 		n.Unlock()
-		return fmt.Errorf("unknown endpoint %s id %s", ep.name, ep.id)
+		err = ErrNoSuchEndpoint(ep.id)
+		return err
 	}
 
 	delete(n.endpoints, ep.id)
@@ -235,6 +1265,201 @@ func (ep *endpoint) Delete() error {
 		}
 	}()
 
-	err = d.DeleteEndpoint(n.id, ep.id)
-	return err
+	// Tolerate driverapi.ErrNoEndpoint and driverapi.ErrNoNetwork: the driver
+	// may have lost track of the endpoint, which is deleted all the same.
+	if err = d.DeleteEndpoint(n.id, ep.id); err == driverapi.ErrNoEndpoint || err == driverapi.ErrNoNetwork {
+		log.Warnf("Driver of endpoint %s id %s doesn't know it, deleting it anyway", ep.name, ep.id)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	n.releaseSandboxKeys(ep.id)
+
+	if e := n.ctrlr.deleteStoredEndpoint(ep); e != nil {
+		log.Warnf("Failed to remove endpoint %s id %s from the store: %v", ep.name, ep.id, e)
+	}
+
+	n.ctrlr.events.emit(endpointEvent(EventEndpointDelete, ep))
+	return nil
+}
+
+// created tells whether the driver is done creating the endpoint. It must be
+// called with the network locked.
+func (ep *endpoint) created() bool {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.state != stateCreating
+}
+
+// checkActive returns the error to report when operating on an endpoint which
+// isn't active, nil otherwise. It must be called with the endpoint locked.
+func (ep *endpoint) checkActive() error {
+	switch ep.state {
+	case stateDeleting:
+		return ErrEndpointDeleting
+	case stateDeleted:
+		return ErrNoSuchEndpoint(ep.id)
+	}
+	return nil
+}
+
+// forceDelete leaves all the sandboxes the endpoint is joined to, and deletes
+// it even if some of them couldn't be left.
+func (ep *endpoint) forceDelete() error {
+	ep.Lock()
+	var keys []string
+	for key := range ep.sandboxKeys {
+		keys = append(keys, key)
+	}
+	ep.Unlock()
+
+	for _, key := range keys {
+		if err := ep.Leave(key); err != nil {
+			log.Warnf("Failed to leave sandbox %s with endpoint %s id %s: %v", key, ep.name, ep.id, err)
+		}
+	}
+	return ep.delete(true)
+}
+
+func (ep *endpoint) Join(sboxKey string, options interface{}) (*driverapi.SandboxInfo, error) {
+	var err error
+
+	n := ep.network
+	d, ok := n.ctrlr.driver(n.networkType)
+	if !ok {
+		return nil, ErrNoSuchDriver(n.networkType)
+	}
+
+	if sboxKey == "" {
+		return nil, ErrNoSandboxKey
+	}
+
+	n.Lock()
+	err = n.checkActive()
+	n.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ep.Lock()
+	if err = ep.checkActive(); err != nil {
+		ep.Unlock()
+		return nil, err
+	}
+	if _, ok := ep.sandboxKeys[sboxKey]; ok {
+		ep.Unlock()
+		return nil, fmt.Errorf("endpoint %s is already joined to sandbox %s", ep.name, sboxKey)
+	}
+	if len(ep.sandboxKeys) != 0 && !ep.multipleSandboxes {
+		ep.Unlock()
+		return nil, fmt.Errorf("endpoint %s is already joined to a sandbox", ep.name)
+	}
+	ep.sandboxKeys[sboxKey] = struct{}{}
+	ep.joinedKeys[sboxKey] = struct{}{}
+	ep.Unlock()
+	defer func() {
+		if err != nil {
+			ep.Lock()
+			delete(ep.sandboxKeys, sboxKey)
+			delete(ep.joinedKeys, sboxKey)
+			ep.Unlock()
+		}
+	}()
+
+	sinfo, err := d.Join(n.id, ep.id, sboxKey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ep.Lock()
+	ep.sandboxInfo = sinfo
+	ep.Unlock()
+
+	if e := n.ctrlr.storeEndpoint(ep); e != nil {
+		log.Warnf("Failed to update endpoint %s id %s in the store: %v", ep.name, ep.id, e)
+	}
+	return sinfo, nil
+}
+
+func (ep *endpoint) Leave(sboxKey string) error {
+	var err error
+
+	n := ep.network
+	d, ok := n.ctrlr.driver(n.networkType)
+	if !ok {
+		return ErrNoSuchDriver(n.networkType)
+	}
+
+	ep.Lock()
+	if _, ok := ep.sandboxKeys[sboxKey]; !ok {
+		ep.Unlock()
+		return fmt.Errorf("endpoint %s is not joined to sandbox %s", ep.name, sboxKey)
+	}
+	_, joined := ep.joinedKeys[sboxKey]
+	delete(ep.sandboxKeys, sboxKey)
+	delete(ep.joinedKeys, sboxKey)
+	ep.Unlock()
+	defer func() {
+		if err != nil {
+			ep.Lock()
+			ep.sandboxKeys[sboxKey] = struct{}{}
+			if joined {
+				ep.joinedKeys[sboxKey] = struct{}{}
+			}
+			ep.Unlock()
+		}
+	}()
+
+	if err = d.Leave(n.id, ep.id, sboxKey); err != nil {
+		return err
+	}
+
+	if e := n.ctrlr.storeEndpoint(ep); e != nil {
+		log.Warnf("Failed to update endpoint %s id %s in the store: %v", ep.name, ep.id, e)
+	}
+	return nil
+}
+
+// copyLabels returns a copy of labels, so that neither the caller nor the
+// network or endpoint holding them can mutate the other's map.
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(labels))
+	for k, v := range labels {
+		cp[k] = v
+	}
+	return cp
+}
+
+// maxDNSLabelLen is the longest label allowed by RFC 1123.
+const maxDNSLabelLen = 63
+
+// validateDNSNames checks that each of names is an RFC 1123 label, made of
+// letters, digits and hyphens not starting nor ending it, and that no name is
+// given twice, DNS names being case insensitive.
+func validateDNSNames(names []string) error {
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if len(name) == 0 || len(name) > maxDNSLabelLen {
+			return fmt.Errorf("invalid DNS name %q: must be 1 to %d characters long", name, maxDNSLabelLen)
+		}
+		if name[0] == '-' || name[len(name)-1] == '-' {
+			return fmt.Errorf("invalid DNS name %q: can't start or end with a hyphen", name)
+		}
+		for _, c := range name {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid DNS name %q: invalid character %q", name, c)
+			}
+		}
+
+		key := strings.ToLower(name)
+		if _, ok := seen[key]; ok {
+			return fmt.Errorf("duplicate DNS name %q", name)
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
 }
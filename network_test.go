@@ -0,0 +1,1277 @@
+package libnetwork
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/pkg/options"
+	"golang.org/x/net/context"
+)
+
+const fakeNetworkType = "fake"
+
+// fakeDriver is a driverapi.Driver which keeps no state besides counting the
+// calls made to it.
+type fakeDriver struct {
+	createNetworkCount  int
+	deleteNetworkCount  int
+	createEndpointCount int
+	deleteEndpointCount int
+	joinCount           int
+	leaveCount          int
+	capability          driverapi.Capability
+	networkInfo         driverapi.NetworkInfo
+	deleteNetworkErr    error
+	deleteEndpointErr   map[driverapi.UUID]error
+	createEndpointDelay time.Duration
+	deleteHook          func() // Called by DeleteNetwork and DeleteEndpoint.
+	interfaces          []driverapi.Interface
+	sync.Mutex
+}
+
+func (d *fakeDriver) callDeleteHook() {
+	d.Lock()
+	hook := d.deleteHook
+	d.Unlock()
+	if hook != nil {
+		hook()
+	}
+}
+
+func (d *fakeDriver) CreateNetwork(ctx context.Context, nid driverapi.UUID, config interface{}) error {
+	d.Lock()
+	d.createNetworkCount++
+	d.Unlock()
+	return nil
+}
+
+func (d *fakeDriver) DeleteNetwork(nid driverapi.UUID) error {
+	d.callDeleteHook()
+	d.Lock()
+	defer d.Unlock()
+	d.deleteNetworkCount++
+	return d.deleteNetworkErr
+}
+
+func (d *fakeDriver) CreateEndpoint(ctx context.Context, nid, eid driverapi.UUID, key string, config interface{}) (*driverapi.SandboxInfo, error) {
+	d.Lock()
+	d.createEndpointCount++
+	delay := d.createEndpointDelay
+	sinfo := &driverapi.SandboxInfo{}
+	for _, iface := range d.interfaces {
+		i := iface
+		sinfo.Interfaces = append(sinfo.Interfaces, &i)
+	}
+	d.Unlock()
+	time.Sleep(delay)
+	return sinfo, nil
+}
+
+func (d *fakeDriver) DeleteEndpoint(nid, eid driverapi.UUID) error {
+	d.callDeleteHook()
+	d.Lock()
+	defer d.Unlock()
+	d.deleteEndpointCount++
+	return d.deleteEndpointErr[eid]
+}
+
+func (d *fakeDriver) Join(nid, eid driverapi.UUID, sboxKey string, config interface{}) (*driverapi.SandboxInfo, error) {
+	d.Lock()
+	d.joinCount++
+	d.Unlock()
+	return &driverapi.SandboxInfo{}, nil
+}
+
+func (d *fakeDriver) Leave(nid, eid driverapi.UUID, sboxKey string) error {
+	d.Lock()
+	d.leaveCount++
+	d.Unlock()
+	return nil
+}
+
+func (d *fakeDriver) Capabilities() driverapi.Capability {
+	return d.capability
+}
+
+func (d *fakeDriver) NetworkInfo(nid driverapi.UUID) (*driverapi.NetworkInfo, error) {
+	d.Lock()
+	defer d.Unlock()
+	info := d.networkInfo
+	return &info, nil
+}
+
+func (d *fakeDriver) EndpointStatistics(nid, eid driverapi.UUID) (*driverapi.InterfaceStatistics, error) {
+	return &driverapi.InterfaceStatistics{RxBytes: 1, RxPackets: 1, TxBytes: 2, TxPackets: 2}, nil
+}
+
+func (d *fakeDriver) CheckNetwork(nid driverapi.UUID) error {
+	return nil
+}
+
+func newFakeController(opts ...Option) (*controller, *fakeDriver) {
+	d := &fakeDriver{capability: driverapi.Capability{IPv6: true, PortMapping: true, MultipleNetworks: true}}
+	opts = append(opts, OptionDriver(fakeNetworkType, d))
+	return New(opts...).(*controller), d
+}
+
+func TestRegisterDriver(t *testing.T) {
+	c, d := newFakeController()
+
+	if err := c.RegisterDriver(fakeNetworkType, d); err == nil {
+		t.Fatal("Expected registering the same network type twice to fail")
+	}
+
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if d.createNetworkCount != 1 {
+		t.Fatalf("Expected the registered driver to be used, got %d network creations", d.createNetworkCount)
+	}
+
+	// Names the built-in drivers couldn't have are refused as well.
+	if err := c.RegisterDriver("Bad-Name", &fakeDriver{}); err == nil {
+		t.Fatal("Expected registering an invalid network type to fail")
+	}
+	c = New(OptionDriver("Bad-Name", &fakeDriver{})).(*controller)
+	if _, ok := c.driver("Bad-Name"); ok {
+		t.Fatal("Expected a driver option with an invalid network type to be ignored")
+	}
+}
+
+func TestDrivers(t *testing.T) {
+	c, _ := newFakeController()
+
+	drivers := c.Drivers()
+	if !sort.StringsAreSorted(drivers) {
+		t.Fatalf("Expected sorted network types, got %v", drivers)
+	}
+	for _, networkType := range []string{"simplebridge", "host", "null", fakeNetworkType} {
+		if i := sort.SearchStrings(drivers, networkType); i == len(drivers) || drivers[i] != networkType {
+			t.Fatalf("Expected %s among the drivers, got %v", networkType, drivers)
+		}
+	}
+
+	if err := c.RegisterDriver("fake2", &fakeDriver{}); err != nil {
+		t.Fatal(err)
+	}
+	if l := len(c.Drivers()); l != len(drivers)+1 {
+		t.Fatalf("Expected the registered driver to be listed, got %v", c.Drivers())
+	}
+}
+
+func TestDuplicateNetworkName(t *testing.T) {
+	c, d := newFakeController()
+
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if _, ok := err.(NetworkNameError); !ok {
+		t.Fatalf("Expected a NetworkNameError, got %v", err)
+	}
+
+	if l := len(c.Networks()); l != 1 {
+		t.Fatalf("Expected exactly one network, got %d", l)
+	}
+
+	// The driver network created for the rejected name must be rolled back.
+	if d.createNetworkCount != 2 || d.deleteNetworkCount != 1 {
+		t.Fatalf("Expected 2 driver network creations and 1 deletion, got %d and %d",
+			d.createNetworkCount, d.deleteNetworkCount)
+	}
+}
+
+func TestAllowDuplicateNetworkName(t *testing.T) {
+	c, _ := newFakeController(OptionAllowDuplicateNames())
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.NetworkByName("network1"); err != ErrAmbiguousNetworkName {
+		t.Fatalf("Expected ErrAmbiguousNetworkName, got %v", err)
+	}
+}
+
+func TestNetworkSetName(t *testing.T) {
+	c, d := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.SetName("network2"); err != NetworkNameError("network2") {
+		t.Fatalf("Expected a NetworkNameError, got %v", err)
+	}
+	if err := network.SetName("renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NetworkByName("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID() != network.ID() || n.Name() != "renamed" {
+		t.Fatalf("Expected network %s to be found under its new name, got %s named %s", network.ID(), n.ID(), n.Name())
+	}
+	if _, err := c.NetworkByName("network1"); err != ErrNoSuchNetwork("network1") {
+		t.Fatalf("Expected the old name to be gone, got %v", err)
+	}
+	if d.createNetworkCount != 2 || d.deleteNetworkCount != 0 {
+		t.Fatalf("Expected the driver to be left alone, got %d creations and %d deletions",
+			d.createNetworkCount, d.deleteNetworkCount)
+	}
+
+	// The old name is available again.
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.SetName("network3"); err != ErrNoSuchNetwork(network.ID()) {
+		t.Fatalf("Expected renaming a deleted network to fail, got %v", err)
+	}
+}
+
+func TestEndpointJoinLeave(t *testing.T) {
+	c, d := newFakeController()
+
+	n, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, _, err := n.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Join("sbox1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Join("sbox1", nil); err == nil {
+		t.Fatal("Expected a second join to the same sandbox to fail")
+	}
+
+	if _, err := ep.Join("sbox2", nil); err == nil {
+		t.Fatal("Expected a join to a second sandbox to fail")
+	}
+
+	if err := ep.Leave("sbox2"); err == nil {
+		t.Fatal("Expected leaving a sandbox which was not joined to fail")
+	}
+
+	if err := ep.Leave("sbox1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Join("sbox2", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.joinCount != 2 || d.leaveCount != 1 {
+		t.Fatalf("Expected 2 driver joins and 1 leave, got %d and %d", d.joinCount, d.leaveCount)
+	}
+}
+
+func TestEndpointJoinMultipleSandboxes(t *testing.T) {
+	c, _ := newFakeController()
+
+	n, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, _, err := n.CreateEndpoint(context.Background(), "ep1", "sbox1", nil, EndpointOptionMultipleSandboxes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Join("sbox2", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"sbox1", "sbox2"} {
+		if err := ep.Leave(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDefaultDriver(t *testing.T) {
+	c, d := newFakeController(OptionDefaultDriver(fakeNetworkType))
+
+	n, err := c.NewNetwork(context.Background(), "", "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.Type() != fakeNetworkType {
+		t.Fatalf("Expected network of type %q, got %q", fakeNetworkType, n.Type())
+	}
+	if d.createNetworkCount != 1 {
+		t.Fatalf("Expected the default driver to be used, got %d network creations", d.createNetworkCount)
+	}
+}
+
+func TestNetworkCapabilities(t *testing.T) {
+	c, d := newFakeController()
+	d.capability = driverapi.Capability{}
+
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", options.Generic{"EnableIPv6": true}); err == nil {
+		t.Fatal("Expected an IPv6 network to be rejected by a driver without IPv6 support")
+	}
+	if d.createNetworkCount != 0 {
+		t.Fatalf("Expected the driver not to be called, got %d network creations", d.createNetworkCount)
+	}
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil); err == nil {
+		t.Fatal("Expected a second network to be rejected by a driver without multiple networks support")
+	}
+
+	epOptions := &struct{ PortBindings []driverapi.PortBinding }{
+		PortBindings: []driverapi.PortBinding{{Proto: driverapi.TCP, Port: 80}}}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", epOptions); err == nil {
+		t.Fatal("Expected port bindings to be rejected by a driver without port mapping support")
+	}
+
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateEndpointOnNewNetwork(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The endpoints map of a new network must be ready for writes.
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentEndpoints(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ep, _, err := network.CreateEndpoint(context.Background(), "ep", "", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			network.Endpoints()
+			network.EndpointByName("ep")
+			if err := ep.Delete(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if l := len(network.Endpoints()); l != 0 {
+		t.Fatalf("Expected no endpoints left, got %d", l)
+	}
+}
+
+func TestConcurrentNetworkDeleteRollback(t *testing.T) {
+	c, d := newFakeController()
+
+	var networks []Network
+	for i := 0; i < 20; i++ {
+		n, err := c.NewNetwork(context.Background(), fakeNetworkType, fmt.Sprintf("network%d", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		networks = append(networks, n)
+	}
+
+	// Every deletion fails and gets rolled back while new networks get
+	// created, some of them reusing the names of the networks being deleted.
+	d.Lock()
+	d.deleteNetworkErr = errors.New("delete failed")
+	d.Unlock()
+
+	var wg sync.WaitGroup
+	for i, n := range networks {
+		wg.Add(2)
+		go func(n Network) {
+			defer wg.Done()
+			if err := n.Delete(); err == nil {
+				t.Error("Expected network deletion to fail")
+			}
+		}(n)
+		go func(i int) {
+			defer wg.Done()
+			c.NewNetwork(context.Background(), fakeNetworkType, fmt.Sprintf("network%d", i), nil)
+		}(i + 10)
+	}
+	wg.Wait()
+
+	names := make(map[string]bool)
+	for _, n := range c.Networks() {
+		if names[n.Name()] {
+			t.Fatalf("Duplicate network name %s after concurrent rollbacks", n.Name())
+		}
+		names[n.Name()] = true
+	}
+	for i := 0; i < 30; i++ {
+		if !names[fmt.Sprintf("network%d", i)] {
+			t.Fatalf("Network network%d missing after concurrent rollbacks", i)
+		}
+	}
+}
+
+// Run with -race to also catch unsynchronized accesses.
+func TestConcurrentNetworkDeleteCreateEndpoint(t *testing.T) {
+	c, d := newFakeController()
+
+	// Widen the window between the checks of CreateEndpoint and the
+	// insertion of the endpoint.
+	d.createEndpointDelay = time.Millisecond
+
+	for i := 0; i < 100; i++ {
+		network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var deleteErr, createErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			deleteErr = network.Delete()
+		}()
+		go func() {
+			defer wg.Done()
+			_, _, createErr = network.CreateEndpoint(context.Background(), "ep1", "", nil)
+		}()
+		wg.Wait()
+
+		// An endpoint is never left behind on a deleted network.
+		if deleteErr == nil && createErr == nil {
+			t.Fatal("Endpoint created on a deleted network")
+		}
+		if deleteErr != nil {
+			if createErr != nil {
+				t.Fatalf("Expected either the deletion or the endpoint creation to succeed: %v, %v", deleteErr, createErr)
+			}
+			if errs := network.DeleteEndpoints(); len(errs) != 0 {
+				t.Fatal(errs)
+			}
+			if err := network.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// blockDeletes makes the driver deletions wait until the returned function is
+// called, once started is closed.
+func blockDeletes(d *fakeDriver) (started chan struct{}, release func()) {
+	started = make(chan struct{})
+	unblock := make(chan struct{})
+	var once sync.Once
+	d.Lock()
+	d.deleteHook = func() {
+		once.Do(func() { close(started) })
+		<-unblock
+	}
+	d.Unlock()
+	return started, func() { close(unblock) }
+}
+
+func TestNetworkDeleting(t *testing.T) {
+	c, d := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := blockDeletes(d)
+	done := make(chan error)
+	go func() { done <- network.Delete() }()
+	<-started
+
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil); err != ErrNetworkDeleting {
+		t.Fatalf("Expected ErrNetworkDeleting creating an endpoint, got %v", err)
+	}
+	if err := network.Delete(); err != ErrNetworkDeleting {
+		t.Fatalf("Expected ErrNetworkDeleting deleting the network again, got %v", err)
+	}
+
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil); err != ErrNoSuchNetwork(network.ID()) {
+		t.Fatalf("Expected ErrNoSuchNetwork once deleted, got %v", err)
+	}
+}
+
+func TestEndpointDeleting(t *testing.T) {
+	c, d := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := blockDeletes(d)
+	done := make(chan error)
+	go func() { done <- ep.Delete() }()
+	<-started
+
+	if _, err := ep.Join("sbox1", nil); err != ErrEndpointDeleting {
+		t.Fatalf("Expected ErrEndpointDeleting joining the endpoint, got %v", err)
+	}
+	if err := ep.Delete(); err != ErrEndpointDeleting {
+		t.Fatalf("Expected ErrEndpointDeleting deleting the endpoint again, got %v", err)
+	}
+
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if d.joinCount != 0 {
+		t.Fatalf("Expected the driver not to be joined, got %d joins", d.joinCount)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	c, d := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.NewNetwork(ctx, fakeNetworkType, "network2", nil); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, _, err := network.CreateEndpoint(ctx, "ep1", "", nil); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if d.createNetworkCount != 1 || d.createEndpointCount != 0 {
+		t.Fatalf("Expected the driver not to be called, got %d network and %d endpoint creations",
+			d.createNetworkCount, d.createEndpointCount)
+	}
+}
+
+func TestNoSuchErrors(t *testing.T) {
+	c, _ := newFakeController()
+
+	_, err := c.NewNetwork(context.Background(), "nosuchtype", "network1", nil)
+	if driverErr, ok := err.(ErrNoSuchDriver); !ok || string(driverErr) != "nosuchtype" {
+		t.Fatalf("Expected ErrNoSuchDriver for nosuchtype, got %v", err)
+	}
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	err = ep.Delete()
+	if epErr, ok := err.(ErrNoSuchEndpoint); !ok || string(epErr) != string(ep.(*endpoint).id) {
+		t.Fatalf("Expected ErrNoSuchEndpoint for endpoint id %s, got %v", ep.(*endpoint).id, err)
+	}
+
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		network.Delete(),
+		func() error { _, _, err := network.CreateEndpoint(context.Background(), "ep2", "", nil); return err }(),
+	} {
+		if nwErr, ok := err.(ErrNoSuchNetwork); !ok || string(nwErr) != network.ID() {
+			t.Fatalf("Expected ErrNoSuchNetwork for network id %s, got %v", network.ID(), err)
+		}
+	}
+}
+
+func TestDeleteEndpointByName(t *testing.T) {
+	c, d := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.DeleteEndpointByName("ep2"); err != ErrNoSuchEndpoint("ep2") {
+		t.Fatalf("Expected ErrNoSuchEndpoint, got %v", err)
+	}
+	if err := network.DeleteEndpointByName("ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if d.deleteEndpointCount != 1 {
+		t.Fatalf("Expected 1 driver endpoint deletion, got %d", d.deleteEndpointCount)
+	}
+	if _, err := network.EndpointByName("ep1"); err != ErrNoSuchEndpoint("ep1") {
+		t.Fatalf("Expected the endpoint to be gone, got %v", err)
+	}
+}
+
+func TestNetworkDeleteActiveEndpoints(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ep1", "ep2"} {
+		if _, _, err := network.CreateEndpoint(context.Background(), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := network.EndpointCount(); n != 2 {
+		t.Fatalf("Expected 2 endpoints, got %d", n)
+	}
+
+	err = network.Delete()
+	if err == nil {
+		t.Fatal("Expected deleting a network with active endpoints to fail")
+	}
+	for _, s := range []string{"2 active endpoints", "ep1", "ep2"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("Expected error %q to mention %q", err, s)
+		}
+	}
+}
+
+func TestNetworkLabels(t *testing.T) {
+	c, _ := newFakeController(OptionAllowDuplicateNames())
+
+	labels := map[string]string{"env": "prod", "team": "net"}
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil, NetworkOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil, NetworkOptionLabels(map[string]string{"env": "dev"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network3", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the caller's map nor the returned one may alter the network.
+	labels["env"] = "changed"
+	got := network.Labels()
+	if got["env"] != "prod" || got["team"] != "net" || len(got) != 2 {
+		t.Fatalf("Unexpected network labels %v", got)
+	}
+	got["env"] = "changed"
+	if network.Labels()["env"] != "prod" {
+		t.Fatal("Mutating the returned labels changed the network")
+	}
+
+	list := c.NetworksByLabel("env", "prod")
+	if len(list) != 1 || list[0].Name() != "network1" {
+		t.Fatalf("Expected network1 to be the only prod network, got %v", list)
+	}
+	if list := c.NetworksByLabel("team", ""); len(list) != 0 {
+		t.Fatalf("Expected no network with an empty team label, got %d", len(list))
+	}
+}
+
+func TestEndpointLabels(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"role": "web"}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil, EndpointOptionLabels(labels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep2", "", nil, EndpointOptionLabels(map[string]string{"role": "db"})); err != nil {
+		t.Fatal(err)
+	}
+
+	labels["role"] = "changed"
+	if got := ep.Labels(); got["role"] != "web" || len(got) != 1 {
+		t.Fatalf("Unexpected endpoint labels %v", got)
+	}
+
+	list := network.EndpointsByLabel("role", "web")
+	if len(list) != 1 || list[0] != ep {
+		t.Fatalf("Expected ep1 to be the only web endpoint, got %v", list)
+	}
+}
+
+func TestNetworkDeleteEndpoints(t *testing.T) {
+	c, d := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eps []Endpoint
+	for i := 0; i < 3; i++ {
+		ep, _, err := network.CreateEndpoint(context.Background(), fmt.Sprintf("ep%d", i), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+
+	// The driver fails to delete ep1.
+	failing := eps[1].(*endpoint)
+	d.deleteEndpointErr = map[driverapi.UUID]error{failing.id: errors.New("delete failed")}
+
+	errs := network.DeleteEndpoints()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "ep1") {
+		t.Fatalf("Expected a single error for ep1, got %v", errs)
+	}
+	if d.deleteEndpointCount != 3 {
+		t.Fatalf("Expected a deletion attempt per endpoint, got %d", d.deleteEndpointCount)
+	}
+	left := network.Endpoints()
+	if len(left) != 1 || left[0] != failing {
+		t.Fatalf("Expected only ep1 to be left, got %d endpoints", len(left))
+	}
+
+	// Once the driver recovers, the network can be cleaned up.
+	d.deleteEndpointErr = nil
+	if errs := network.DeleteEndpoints(); len(errs) != 0 {
+		t.Fatalf("Unexpected errors deleting the endpoints: %v", errs)
+	}
+	if err := network.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControllerClose(t *testing.T) {
+	c, d := newFakeController()
+
+	network1, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network1.CreateEndpoint(context.Background(), "ep1", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The network with an active endpoint is kept.
+	err = c.Close()
+	if err == nil || !strings.Contains(err.Error(), "network1") {
+		t.Fatalf("Expected Close to fail on network1, got %v", err)
+	}
+	if l := len(c.Networks()); l != 1 {
+		t.Fatalf("Expected network1 to be left, got %d networks", l)
+	}
+	if d.deleteNetworkCount != 1 || d.deleteEndpointCount != 0 {
+		t.Fatalf("Unexpected driver calls: %d network and %d endpoint deletions", d.deleteNetworkCount, d.deleteEndpointCount)
+	}
+}
+
+func TestControllerForceClose(t *testing.T) {
+	c, d := newFakeController(OptionForceClose())
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep.Join("sbox1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l := len(c.Networks()); l != 0 {
+		t.Fatalf("Expected no network left, got %d", l)
+	}
+	if d.leaveCount != 1 || d.deleteEndpointCount != 1 || d.deleteNetworkCount != 1 {
+		t.Fatalf("Unexpected driver calls: %d leaves, %d endpoint and %d network deletions", d.leaveCount, d.deleteEndpointCount, d.deleteNetworkCount)
+	}
+}
+
+// Run with -race to catch unsynchronized accesses to the driver table.
+func TestRegisterDriverConcurrentEndpoints(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*count)
+	for i := 0; i < count; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- c.RegisterDriver(fmt.Sprintf("fake%d", i), &fakeDriver{})
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, _, err := network.CreateEndpoint(context.Background(), fmt.Sprintf("ep%d", i), "", nil)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := network.EndpointCount(); n != count {
+		t.Fatalf("Expected %d endpoints, got %d", count, n)
+	}
+}
+
+func TestEndpointStatistics(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := ep.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *st != (Stats{RxBytes: 1, RxPackets: 1, TxBytes: 2, TxPackets: 2}) {
+		t.Fatalf("Unexpected statistics %+v", st)
+	}
+}
+
+func TestEndpointSandboxKeyInUse(t *testing.T) {
+	c, d := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "sbox1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep2", "sbox1", nil); err == nil {
+		t.Fatal("Expected an error creating a second endpoint for sbox1")
+	}
+	if d.createEndpointCount != 1 {
+		t.Fatalf("Expected the driver not to be called for the rejected endpoint, got %d creations", d.createEndpointCount)
+	}
+
+	// The key is free again once its endpoint is gone.
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep2", "sbox1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	shared, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil, NetworkOptionMultipleEndpointsPerSandbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ep1", "ep2"} {
+		if _, _, err := shared.CreateEndpoint(context.Background(), name, "sbox1", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEndpointInfo(t *testing.T) {
+	c, d := newFakeController()
+	d.interfaces = []driverapi.Interface{
+		{SrcName: "veth0", DstName: "eth0", Address: "192.168.1.2/24"},
+		{SrcName: "veth1", DstName: "eth1", Address: "192.168.2.2/24"},
+	}
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "sbox1", nil); err != nil {
+		t.Fatal(err)
+	}
+	unjoined, _, err := network.CreateEndpoint(context.Background(), "ep2", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := unjoined.Info(); info != nil {
+		t.Fatalf("Expected no info for an endpoint without sandbox, got %+v", info)
+	}
+
+	ep, err := network.EndpointByName("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := ep.Info()
+	if info == nil || len(info.Interfaces) != 2 {
+		t.Fatalf("Expected the two interfaces of the endpoint, got %+v", info)
+	}
+	for i, iface := range info.Interfaces {
+		if *iface != d.interfaces[i] {
+			t.Fatalf("Expected interface %+v, got %+v", d.interfaces[i], *iface)
+		}
+	}
+
+	// The returned info is a copy.
+	info.Interfaces[0].DstName = "mynet0"
+	if ep.Info().Interfaces[0].DstName != "eth0" {
+		t.Fatal("Modifying the returned info changed the endpoint")
+	}
+}
+
+func TestIDCollisionRetry(t *testing.T) {
+	c, _ := newFakeController()
+	network1, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep1, _, err := network1.CreateEndpoint(context.Background(), "ep1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay an id in use before handing out a fresh one.
+	var ids []string
+	defer func(orig func() string) { generateID = orig }(generateID)
+	generateID = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+
+	ids = []string{network1.ID(), "network2"}
+	network2, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network2.ID() != "network2" {
+		t.Fatalf("Expected the colliding id to be regenerated, got %s", network2.ID())
+	}
+
+	ids = []string{string(ep1.(*endpoint).id), "ep2"}
+	ep2, _, err := network1.CreateEndpoint(context.Background(), "ep2", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := ep2.(*endpoint).id; id != "ep2" {
+		t.Fatalf("Expected the colliding id to be regenerated, got %s", id)
+	}
+	if count := network1.EndpointCount(); count != 2 {
+		t.Fatalf("Expected ep1 to be left alone next to ep2, got %d endpoints", count)
+	}
+
+	ids = []string{}
+	for i := 0; i < maxIDAttempts; i++ {
+		ids = append(ids, network1.ID())
+	}
+	if _, err := c.NewNetwork(context.Background(), fakeNetworkType, "network3", nil); err == nil {
+		t.Fatal("Expected an error once the attempts are exhausted")
+	}
+}
+
+func TestConcurrentEndpointIDs(t *testing.T) {
+	c, d := newFakeController()
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both creations draw the same id first, and are still in the driver
+	// when the other one checks it.
+	var (
+		mu  sync.Mutex
+		ids = []string{"ep", "ep", "ep1", "ep2"}
+	)
+	defer func(orig func() string) { generateID = orig }(generateID)
+	generateID = func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	d.createEndpointDelay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	eps := make([]Endpoint, 2)
+	errs := make([]error, 2)
+	for i := range eps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			eps[i], _, errs[i] = network.CreateEndpoint(context.Background(), fmt.Sprintf("ep%d", i), "", nil)
+		}(i)
+	}
+
+	// An endpoint being created isn't listed yet.
+	time.Sleep(10 * time.Millisecond)
+	if count := network.EndpointCount(); count != 0 {
+		t.Fatalf("Expected no endpoint while the driver creates them, got %d", count)
+	}
+
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id0, id1 := eps[0].(*endpoint).id, eps[1].(*endpoint).id; id0 == id1 {
+		t.Fatalf("Expected concurrent endpoints to get distinct ids, both got %s", id0)
+	}
+	if count := network.EndpointCount(); count != 2 {
+		t.Fatalf("Expected the two endpoints, got %d", count)
+	}
+}
+
+func TestEndpointJoinCount(t *testing.T) {
+	c, _ := newFakeController()
+
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil, EndpointOptionMultipleSandboxes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"sbox1", "sbox2"}
+	for i, key := range keys {
+		if _, err := ep.Join(key, nil); err != nil {
+			t.Fatal(err)
+		}
+		if count := ep.JoinCount(); count != i+1 {
+			t.Fatalf("Expected a join count of %d, got %d", i+1, count)
+		}
+	}
+
+	if err := ep.Delete(); err == nil || !strings.Contains(err.Error(), "still joined to 2 sandboxes") {
+		t.Fatalf("Expected the deletion of a joined endpoint to fail, got %v", err)
+	}
+
+	for _, key := range keys {
+		if err := ep.Leave(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := ep.JoinCount(); count != 0 {
+		t.Fatalf("Expected a join count of 0 once left, got %d", count)
+	}
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockOrdering(t *testing.T) {
+	c, _ := newFakeController()
+
+	// Exercise the code paths taking the controller, network and endpoint
+	// locks concurrently. An inverted lock order shows up as a hang.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			network, err := c.NewNetwork(context.Background(), fakeNetworkType, fmt.Sprintf("network%d", i), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			var wg sync.WaitGroup
+			for _, fn := range []func(){
+				func() {
+					ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "sbox1", nil, EndpointOptionMultipleSandboxes())
+					if err != nil {
+						return
+					}
+					ep.Join("sbox2", nil)
+					ep.(*endpoint).forceDelete()
+				},
+				func() { network.Delete() },
+				func() { network.SetName(fmt.Sprintf("renamed%d", i)) },
+				func() { network.Name() },
+				func() { c.NetworkByName("network1") },
+				func() { network.EndpointByName("ep1") },
+			} {
+				wg.Add(1)
+				go func(fn func()) {
+					defer wg.Done()
+					fn()
+				}(fn)
+			}
+			wg.Wait()
+
+			network.DeleteEndpoints()
+			if _, err := c.NetworkByID(network.ID()); err == nil {
+				if err := network.Delete(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out, the controller, network and endpoint locks are likely taken in different orders")
+	}
+}
+
+func TestWalkEndpoints(t *testing.T) {
+	c, _ := newFakeController()
+
+	expected := make(map[string]string)
+	for _, name := range []string{"network1", "network2"} {
+		n, err := c.NewNetwork(context.Background(), fakeNetworkType, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, epName := range []string{"ep1", "ep2"} {
+			ep, _, err := n.CreateEndpoint(context.Background(), epName, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected[string(ep.(*endpoint).id)] = n.ID()
+		}
+	}
+
+	visited := make(map[string]string)
+	c.WalkEndpoints(func(n Network, ep Endpoint) bool {
+		// Calling back into the network must not deadlock.
+		if n.EndpointCount() != 2 {
+			t.Fatalf("Expected 2 endpoints on network %s, got %d", n.Name(), n.EndpointCount())
+		}
+		visited[string(ep.(*endpoint).id)] = n.ID()
+		return false
+	})
+	if !reflect.DeepEqual(visited, expected) {
+		t.Fatalf("Expected to walk %v, walked %v", expected, visited)
+	}
+
+	count := 0
+	c.WalkEndpoints(func(Network, Endpoint) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Fatalf("Expected the walk to stop after the first endpoint, got %d calls", count)
+	}
+}
+
+func TestEndpointDNSNames(t *testing.T) {
+	c, _ := newFakeController()
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil, EndpointOptionDNSNames("web", "www", "frontend-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"web", "www", "frontend-1"}
+	names := ep.DNSNames()
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected DNS names %v, got %v", expected, names)
+	}
+	names[0] = "changed"
+	if ep.DNSNames()[0] != "web" {
+		t.Fatal("DNSNames returned the endpoint own slice")
+	}
+
+	plain, _, err := network.CreateEndpoint(context.Background(), "ep2", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := plain.DNSNames(); len(names) != 0 {
+		t.Fatalf("Expected no DNS names, got %v", names)
+	}
+
+	for _, names := range [][]string{
+		{""},
+		{"-web"},
+		{"web-"},
+		{"web.example"},
+		{"web_1"},
+		{strings.Repeat("a", 64)},
+		{"web", "WEB"},
+	} {
+		if _, _, err := network.CreateEndpoint(context.Background(), "ep3", "", nil, EndpointOptionDNSNames(names[0], names[1:]...)); err == nil {
+			t.Fatalf("Expected DNS names %q to be rejected", names)
+		}
+	}
+	if l := network.EndpointCount(); l != 2 {
+		t.Fatalf("Expected the rejected endpoints not to be created, got %d endpoints", l)
+	}
+}
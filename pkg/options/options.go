@@ -29,6 +29,20 @@ func (e CannotSetFieldError) Error() string {
 	return fmt.Sprintf("cannot set field %q of type %q", e.Field, e.Type)
 }
 
+// TypeMismatchError is the error returned when the generic parameters hold a
+// value for a field whose type doesn't match the field of the destination
+// structure.
+type TypeMismatchError struct {
+	Field      string
+	Type       string
+	ExpectType string
+	ActualType string
+}
+
+func (e TypeMismatchError) Error() string {
+	return fmt.Sprintf("type mismatch, field %q of type %q expects %q, got %q", e.Field, e.Type, e.ExpectType, e.ActualType)
+}
+
 // Generic is an basic type to store arbitrary settings.
 type Generic map[string]interface{}
 
@@ -62,7 +76,15 @@ func GenerateFromModel(options Generic, model interface{}) (interface{}, error)
 		if !field.CanSet() {
 			return nil, CannotSetFieldError{name, resType.String()}
 		}
-		field.Set(reflect.ValueOf(value))
+		// An explicit nil leaves the field to its zero value.
+		if value == nil {
+			continue
+		}
+		val := reflect.ValueOf(value)
+		if !val.Type().AssignableTo(field.Type()) {
+			return nil, TypeMismatchError{name, resType.String(), field.Type().String(), val.Type().String()}
+		}
+		field.Set(val)
 	}
 
 	// If the model is not of pointer type, return content of the result.
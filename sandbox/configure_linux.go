@@ -41,7 +41,48 @@ func setGatewayIP(gw string) error {
 	})
 }
 
+func addStaticRoute(destination *net.IPNet, nextHop net.IP, ifaceName string) error {
+	var link netlink.Link
+
+	route := &netlink.Route{
+		Scope: netlink.SCOPE_UNIVERSE,
+		Dst:   destination,
+		Gw:    nextHop,
+	}
+
+	if ifaceName != "" {
+		var err error
+		if link, err = netlink.LinkByName(ifaceName); err != nil {
+			return fmt.Errorf("error finding interface %q: %v", ifaceName, err)
+		}
+		route.LinkIndex = link.Attrs().Index
+	}
+
+	// Check the next hop is reachable through one of the (specified)
+	// interfaces, rather than surfacing the kernel error.
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	reachable := false
+	for _, addr := range addrs {
+		if addr.IPNet != nil && addr.IPNet.Contains(nextHop) {
+			reachable = true
+			break
+		}
+	}
+	if !reachable {
+		return fmt.Errorf("next hop %s is not reachable on any interface", nextHop)
+	}
+
+	return netlink.RouteAdd(route)
+}
+
 func setInterfaceIP(iface netlink.Link, settings *driverapi.Interface) error {
+	if settings.Address == "" {
+		return nil
+	}
+
 	ipAddr, err := netlink.ParseAddr(settings.Address)
 	if err == nil {
 		err = netlink.AddrAdd(iface, ipAddr)
@@ -2,8 +2,12 @@ package sandbox
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/docker/libnetwork/driverapi"
@@ -11,21 +15,62 @@ import (
 	"github.com/vishvananda/netns"
 )
 
+// ifacePrefix is the prefix of the names given to interfaces added without
+// an explicit DstName.
+const ifacePrefix = "eth"
+
 // The networkNamespace type is the linux implementation of the Sandbox
 // interface. It represents a linux network namespace, and moves an interface
 // into it when called on method AddInterface or sets the gateway etc.
 type networkNamespace struct {
-	path  string
-	sinfo *driverapi.SandboxInfo
+	ns     namespace
+	sinfo  *driverapi.SandboxInfo
+	logger driverapi.Logger
+}
+
+// netnsMount is the handle of a network namespace kept alive by a bind mount
+// on the path the sandbox key names.
+type netnsMount struct {
+	path string
 }
 
 // NewSandbox provides a new sandbox instance created in an os specific way
-// provided a key which uniquely identifies the sandbox
+// provided a key which uniquely identifies the sandbox. On linux, the key is
+// the path the network namespace gets bind mounted on.
 func NewSandbox(key string) (Sandbox, error) {
-	return createNetworkNamespace(key)
+	return NewSandboxWithLogger(key, driverapi.NopLogger{})
+}
+
+// NewSandboxWithLogger provides a new sandbox instance like NewSandbox, which
+// reports the changes it makes to its network namespace to logger.
+func NewSandboxWithLogger(key string, logger driverapi.Logger) (Sandbox, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	if logger == nil {
+		logger = driverapi.NopLogger{}
+	}
+
+	ns, err := createNetworkNamespace(key)
+	if err != nil {
+		return nil, err
+	}
+	n := &networkNamespace{ns: ns, sinfo: &driverapi.SandboxInfo{}, logger: logger}
+	n.debug("created network namespace", nil)
+	return n, nil
 }
 
-func createNetworkNamespace(path string) (Sandbox, error) {
+// debug reports a change made to the sandbox, adding its key to fields.
+func (n *networkNamespace) debug(msg string, fields driverapi.Fields) {
+	if fields == nil {
+		fields = driverapi.Fields{}
+	}
+	fields["sandbox"] = n.Key()
+	n.logger.Debug(msg, fields)
+}
+
+func createNetworkNamespace(path string) (*netnsMount, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -50,11 +95,14 @@ func createNetworkNamespace(path string) (Sandbox, error) {
 		return nil, err
 	}
 
-	if err := syscall.Mount("/proc/self/ns/net", path, "bind", syscall.MS_BIND, ""); err != nil {
+	// The new namespace is the one of the current thread, which needn't be
+	// the main thread /proc/self refers to.
+	nsPath := fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid())
+	if err := syscall.Mount(nsPath, path, "bind", syscall.MS_BIND, ""); err != nil {
 		return nil, err
 	}
 
-	return &networkNamespace{path: path}, nil
+	return &netnsMount{path: path}, nil
 }
 
 func createNamespaceFile(path string) (err error) {
@@ -74,6 +122,107 @@ func loopbackUp() error {
 }
 
 func (n *networkNamespace) AddInterface(i *driverapi.Interface) error {
+	// Settle the name of the interface in the sandbox before touching the
+	// link, so that a collision leaves it in the host namespace.
+	if i.DstName == "" {
+		i.DstName = n.nextIfaceName()
+	} else if n.hasInterface(i.DstName) {
+		return fmt.Errorf("interface %q already exists in sandbox %s", i.DstName, n.Key())
+	}
+
+	// The namespace may hold links which weren't added through the sandbox,
+	// such as the loopback.
+	var existing netlink.Link
+	if err := n.invoke(func() (err error) {
+		existing, err = findLink(i.DstName)
+		return err
+	}); err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("interface %q already exists in sandbox %s", i.DstName, n.Key())
+	}
+
+	// Move the network interface identified by the SrcName attribute to
+	// the destination namespace.
+	if err := n.ns.moveInterface(i.SrcName); err != nil {
+		return err
+	}
+
+	if err := n.invoke(func() error {
+		iface, err := netlink.LinkByName(i.SrcName)
+		if err != nil {
+			return err
+		}
+
+		// Configure the interface now this is moved in the proper namespace.
+		if err := configureInterface(iface, i); err != nil {
+			return err
+		}
+
+		// Up the interface.
+		return netlink.LinkSetUp(iface)
+	}); err != nil {
+		return err
+	}
+
+	n.sinfo.Interfaces = append(n.sinfo.Interfaces, i)
+	n.debug("added interface", driverapi.Fields{"interface": i.SrcName, "name": i.DstName, "address": i.Address, "addressIPv6": i.AddressIPv6})
+	return nil
+}
+
+// hasInterface reports whether an interface previously added to the sandbox
+// is named name.
+func (n *networkNamespace) hasInterface(name string) bool {
+	for _, iface := range n.sinfo.Interfaces {
+		if iface.DstName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// nextIfaceName returns eth<N> for the lowest N not already in use by an
+// interface of the sandbox.
+func (n *networkNamespace) nextIfaceName() string {
+	for idx := 0; ; idx++ {
+		name := fmt.Sprintf("%s%d", ifacePrefix, idx)
+		if !n.hasInterface(name) {
+			return name
+		}
+	}
+}
+
+func (n *networkNamespace) RemoveInterface(i *driverapi.Interface) error {
+	err := n.invoke(func() error {
+		iface, err := findLink(i.DstName)
+		if err != nil || iface == nil {
+			// Removing an interface which is already gone is not an error.
+			return err
+		}
+		return netlink.LinkDel(iface)
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx, iface := range n.sinfo.Interfaces {
+		if iface.DstName == i.DstName {
+			n.sinfo.Interfaces = append(n.sinfo.Interfaces[:idx], n.sinfo.Interfaces[idx+1:]...)
+			break
+		}
+	}
+	n.debug("removed interface", driverapi.Fields{"name": i.DstName})
+	return nil
+}
+
+func (m *netnsMount) key() string {
+	return m.path
+}
+
+// invoke runs fn from within the network namespace, and switches back to the
+// original namespace before returning.
+func (m *netnsMount) invoke(fn func() error) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -83,71 +232,259 @@ func (n *networkNamespace) AddInterface(i *driverapi.Interface) error {
 	}
 	defer origns.Close()
 
-	f, err := os.OpenFile(n.path, os.O_RDONLY, 0)
+	f, err := m.open()
 	if err != nil {
-		return fmt.Errorf("failed get network namespace %q: %v", n.path, err)
+		return err
 	}
 	defer f.Close()
 
-	// Find the network inteerface identified by the SrcName attribute.
-	iface, err := netlink.LinkByName(i.SrcName)
-	if err != nil {
+	if err = netns.Set(netns.NsHandle(f.Fd())); err != nil {
 		return err
 	}
+	defer netns.Set(origns)
 
-	// Move the network interface to the destination namespace.
-	nsFD := f.Fd()
-	if err := netlink.LinkSetNsFd(iface, int(nsFD)); err != nil {
-		return err
+	return fn()
+}
+
+func (m *netnsMount) open() (*os.File, error) {
+	f, err := os.OpenFile(m.path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed get network namespace %q: %v", m.path, err)
 	}
+	return f, nil
+}
 
-	if err = netns.Set(netns.NsHandle(nsFD)); err != nil {
+func (m *netnsMount) moveInterface(name string) error {
+	f, err := m.open()
+	if err != nil {
 		return err
 	}
-	defer netns.Set(origns)
+	defer f.Close()
 
-	// Configure the interface now this is moved in the proper namespace.
-	if err := configureInterface(iface, i); err != nil {
+	iface, err := netlink.LinkByName(name)
+	if err != nil {
 		return err
 	}
+	return netlink.LinkSetNsFd(iface, int(f.Fd()))
+}
 
-	// Up the interface.
-	if err := netlink.LinkSetUp(iface); err != nil {
+// destroy unmounts the network namespace, which is enough to destroy it as
+// long as no process runs in it, and removes the mount point. EINVAL and ENOENT
+// mean it was already destroyed.
+func (m *netnsMount) destroy() error {
+	if err := syscall.Unmount(m.path, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+		return err
+	}
+	if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-
-	n.sinfo.Interfaces = append(n.sinfo.Interfaces, i)
 	return nil
 }
 
+func (n *networkNamespace) invoke(fn func() error) error {
+	return n.ns.invoke(fn)
+}
+
+// findLink returns the link with the specified name in the current network
+// namespace, or nil if there is no such link.
+func findLink(name string) (netlink.Link, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		if link.Attrs().Name == name {
+			return link, nil
+		}
+	}
+	return nil, nil
+}
+
 func (n *networkNamespace) SetGateway(gw string) error {
-	err := setGatewayIP(gw)
+	err := n.invoke(func() error {
+		return setGatewayIP(gw)
+	})
 	if err == nil {
 		n.sinfo.Gateway = gw
+		n.debug("set gateway", driverapi.Fields{"gateway": gw})
 	}
 
 	return err
 }
 
 func (n *networkNamespace) SetGatewayIPv6(gw string) error {
-	err := setGatewayIP(gw)
+	if ip := net.ParseIP(gw); ip == nil || ip.To4() != nil {
+		return fmt.Errorf("bad IPv6 address format %q", gw)
+	}
+
+	if !n.hasIPv6() {
+		return fmt.Errorf("cannot set IPv6 gateway %s: no interface in sandbox %s has an IPv6 address", gw, n.Key())
+	}
+
+	err := n.invoke(func() error {
+		return setGatewayIP(gw)
+	})
 	if err == nil {
 		n.sinfo.GatewayIPv6 = gw
+		n.debug("set IPv6 gateway", driverapi.Fields{"gateway": gw})
 	}
 
 	return err
 }
 
+func (n *networkNamespace) AddStaticRoute(destination *net.IPNet, nextHop net.IP, iface string) error {
+	if err := n.invoke(func() error {
+		return addStaticRoute(destination, nextHop, iface)
+	}); err != nil {
+		return err
+	}
+	n.debug("added static route", driverapi.Fields{"destination": destination, "nextHop": nextHop, "interface": iface})
+	return nil
+}
+
+func (n *networkNamespace) SetSysctl(key, value string) error {
+	path, err := sysctlPath(key)
+	if err != nil {
+		return err
+	}
+
+	// The network sysctls of /proc/sys are those of the namespace of the
+	// thread opening them.
+	if err := n.invoke(func() error {
+		return ioutil.WriteFile(path, []byte(value+"\n"), 0644)
+	}); err != nil {
+		return err
+	}
+	n.debug("set sysctl", driverapi.Fields{"key": key, "value": value})
+	return nil
+}
+
+// sysctlPath returns the /proc/sys path of the network sysctl key, failing for
+// other sysctls or keys escaping /proc/sys/net.
+func sysctlPath(key string) (string, error) {
+	parts := strings.Split(key, ".")
+	if len(parts) < 2 || parts[0] != "net" {
+		return "", fmt.Errorf("invalid sysctl %q: only network sysctls can be set", key)
+	}
+	for i, part := range parts {
+		part = strings.Replace(part, "/", ".", -1)
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid sysctl %q", key)
+		}
+		parts[i] = part
+	}
+	return filepath.Join(append([]string{"/proc/sys"}, parts...)...), nil
+}
+
+func (n *networkNamespace) Info() (*Info, error) {
+	var info *Info
+
+	err := n.invoke(func() error {
+		var err error
+		info, err = readInfo()
+		return err
+	})
+	return info, err
+}
+
+func readInfo() (*Info, error) {
+	info := &Info{}
+	names := make(map[int]string)
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		attrs := link.Attrs()
+		names[attrs.Index] = attrs.Name
+		if attrs.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, err
+		}
+
+		iface := &InterfaceInfo{Name: attrs.Name}
+		for _, addr := range addrs {
+			iface.Addresses = append(iface.Addresses, addr.IPNet)
+		}
+		info.Interfaces = append(info.Interfaces, iface)
+	}
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range routes {
+		switch {
+		case r.Gw == nil:
+			// Directly connected route.
+		case r.Dst == nil && r.Gw.To4() != nil:
+			info.Gateway = r.Gw
+		case r.Dst == nil:
+			info.GatewayIPv6 = r.Gw
+		default:
+			info.StaticRoutes = append(info.StaticRoutes, &StaticRoute{
+				Destination: r.Dst,
+				NextHop:     r.Gw,
+				Interface:   names[r.LinkIndex],
+			})
+		}
+	}
+
+	return info, nil
+}
+
+func (n *networkNamespace) hasIPv6() bool {
+	for _, i := range n.sinfo.Interfaces {
+		if i.AddressIPv6 != "" {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *networkNamespace) Interfaces() []*driverapi.Interface {
 	return n.sinfo.Interfaces
 }
 
+func (n *networkNamespace) SetDNS(servers []net.IP, search []string, options []string) error {
+	content, err := buildResolvConf(servers, search, options)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(resolvConfPath(n.Key()), content, 0644); err != nil {
+		return err
+	}
+	n.debug("wrote resolv.conf", driverapi.Fields{"path": resolvConfPath(n.Key())})
+	return nil
+}
+
 func (n *networkNamespace) Key() string {
-	return n.path
+	return n.ns.key()
 }
 
 func (n *networkNamespace) Destroy() error {
-	// Assuming no running process is executing in this network namespace,
-	// unmounting is sufficient to destroy it.
-	return syscall.Unmount(n.path, syscall.MNT_DETACH)
+	// Remove the interfaces first, so that their host side peers don't
+	// outlive the sandbox.
+	for _, i := range append([]*driverapi.Interface(nil), n.sinfo.Interfaces...) {
+		if err := n.RemoveInterface(i); err != nil {
+			return err
+		}
+	}
+
+	if err := n.ns.destroy(); err != nil {
+		return err
+	}
+	if err := os.Remove(resolvConfPath(n.Key())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	n.debug("destroyed network namespace", nil)
+	return nil
 }
@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolvConfSuffix is appended to the sandbox key to form the path of its
+// resolv.conf, so that sandboxes sharing a directory don't share the file.
+const resolvConfSuffix = ".resolv.conf"
+
+func resolvConfPath(key string) string {
+	return key + resolvConfSuffix
+}
+
+// buildResolvConf returns the content of a resolv.conf listing the
+// nameservers, search domains and options.
+func buildResolvConf(servers []net.IP, search []string, options []string) ([]byte, error) {
+	var content bytes.Buffer
+
+	for _, ns := range servers {
+		if ns == nil {
+			return nil, fmt.Errorf("invalid nameserver %v", ns)
+		}
+		fmt.Fprintf(&content, "nameserver %s\n", ns)
+	}
+	for _, entries := range [][]string{search, options} {
+		for _, entry := range entries {
+			if entry == "" || strings.IndexFunc(entry, isSpace) != -1 {
+				return nil, fmt.Errorf("invalid resolv.conf entry %q", entry)
+			}
+		}
+	}
+	if len(search) != 0 {
+		fmt.Fprintf(&content, "search %s\n", strings.Join(search, " "))
+	}
+	if len(options) != 0 {
+		fmt.Fprintf(&content, "options %s\n", strings.Join(options, " "))
+	}
+
+	return content.Bytes(), nil
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// writeFileAtomic writes content to a temporary file next to path and renames
+// it over path, so that readers never see a partially written file.
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,50 @@
 package sandbox
 
-import "github.com/docker/libnetwork/driverapi"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/libnetwork/driverapi"
+)
+
+// ErrEmptyKey is returned when creating a sandbox without a key.
+var ErrEmptyKey = errors.New("sandbox key can't be empty")
+
+// validateKey rejects the keys which can't identify a sandbox on any platform:
+// empty ones, and relative ones escaping the working directory.
+func validateKey(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if !filepath.IsAbs(key) {
+		for _, elem := range strings.Split(filepath.ToSlash(key), "/") {
+			if elem == ".." {
+				return fmt.Errorf("invalid sandbox key %q: relative keys can't traverse parent directories", key)
+			}
+		}
+	}
+	return nil
+}
+
+// namespace is the platform specific handle of the network stack of a
+// sandbox.
+type namespace interface {
+	// The key of the sandbox, which identifies the namespace.
+	key() string
+
+	// Run fn from within the namespace.
+	invoke(fn func() error) error
+
+	// Move the host interface named name into the namespace.
+	moveInterface(name string) error
+
+	// Release the namespace. Destroying an already destroyed namespace is
+	// not an error.
+	destroy() error
+}
 
 // Sandbox represents a network sandbox, identified by a specific key.  It
 // holds a list of Interfaces, routes etc, and more can be added dynamically.
@@ -16,10 +60,74 @@ type Sandbox interface {
 
 	// Add an existing Interface to this sandbox. The operation will rename
 	// from the Interface SrcName to DstName as it moves, and reconfigure the
-	// interface according to the specified settings.
+	// interface according to the specified settings. An empty DstName is
+	// filled in with the next free eth<N> name, and a DstName already used
+	// by another interface of the sandbox is an error.
 	AddInterface(*driverapi.Interface) error
 
+	// Remove an Interface previously added with AddInterface, deleting the
+	// link identified by its DstName from the sandbox. Removing an interface
+	// which is already gone is not an error.
+	RemoveInterface(*driverapi.Interface) error
+
+	// Set the IPv4 default gateway of the sandbox.
 	SetGateway(gw string) error
 
+	// Set the IPv6 default gateway of the sandbox. This requires one of the
+	// interfaces of the sandbox to have an IPv6 address.
 	SetGatewayIPv6(gw string) error
+
+	// Add a route to the destination network through the next hop. The
+	// route is bound to the named interface of the sandbox, unless iface is
+	// empty. The next hop must be reachable on one of the interfaces.
+	AddStaticRoute(destination *net.IPNet, nextHop net.IP, iface string) error
+
+	// Write the resolv.conf of the sandbox, next to its key with a
+	// ".resolv.conf" suffix, listing the nameservers, search domains and
+	// resolver options. The file is replaced atomically.
+	SetDNS(servers []net.IP, search []string, options []string) error
+
+	// Set a sysctl of the network namespace of the sandbox, such as
+	// "net.ipv4.conf.all.rp_filter". Only the network sysctls, which are
+	// namespaced, can be set. Dots in interface names are written as
+	// slashes, such as "net.ipv4.conf.eth0/100.rp_filter".
+	SetSysctl(key, value string) error
+
+	// Return the network configuration of the sandbox, as currently
+	// configured in the kernel.
+	Info() (*Info, error)
+
+	// Destroy the sandbox, removing its interfaces first. Destroying an
+	// already destroyed sandbox is not an error.
+	Destroy() error
+}
+
+// Info represents the network configuration of a sandbox.
+type Info struct {
+	// The interfaces of the sandbox, excluding the loopback interface.
+	Interfaces []*InterfaceInfo
+
+	// IPv4 default gateway of the sandbox.
+	Gateway net.IP
+
+	// IPv6 default gateway of the sandbox.
+	GatewayIPv6 net.IP
+
+	// Routes with a next hop other than the default ones.
+	StaticRoutes []*StaticRoute
+}
+
+// InterfaceInfo represents the name and addresses of an interface of a
+// sandbox.
+type InterfaceInfo struct {
+	Name      string
+	Addresses []*net.IPNet
+}
+
+// StaticRoute represents a route to a destination network through a next
+// hop, optionally bound to a specific interface.
+type StaticRoute struct {
+	Destination *net.IPNet
+	NextHop     net.IP
+	Interface   string
 }
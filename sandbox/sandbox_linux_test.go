@@ -1,12 +1,15 @@
 package sandbox
 
 import (
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
 
 	"github.com/docker/libcontainer/utils"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 )
 
@@ -52,3 +55,59 @@ func verifySandbox(t *testing.T, s Sandbox) {
 
 	netns.Set(origns)
 }
+
+func newInterface(t *testing.T, dstName, address string) *driverapi.Interface {
+	name1, err := utils.GenerateRandomName("veth", 7)
+	if err != nil {
+		t.Fatalf("Failed to generate veth name: %v", err)
+	}
+
+	name2, err := utils.GenerateRandomName("veth", 7)
+	if err != nil {
+		t.Fatalf("Failed to generate veth name: %v", err)
+	}
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: name1, TxQLen: 0},
+		PeerName:  name2}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create veth pair: %v", err)
+	}
+
+	return &driverapi.Interface{SrcName: name2, DstName: dstName, Address: address}
+}
+
+func linkExists(t *testing.T, s Sandbox, name string) bool {
+	var link netlink.Link
+
+	err := s.(*networkNamespace).invoke(func() error {
+		var err error
+		link, err = findLink(name)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to list links in sandbox %s: %v", s.Key(), err)
+	}
+	return link != nil
+}
+
+func defaultRoute(t *testing.T, s Sandbox, family int) net.IP {
+	var gw net.IP
+
+	err := s.(*networkNamespace).invoke(func() error {
+		routes, err := netlink.RouteList(nil, family)
+		if err != nil {
+			return err
+		}
+		for _, r := range routes {
+			if r.Dst == nil && r.Gw != nil {
+				gw = r.Gw
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to list routes in sandbox %s: %v", s.Key(), err)
+	}
+	return gw
+}
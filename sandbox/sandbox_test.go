@@ -1,6 +1,16 @@
 package sandbox
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
 
 func TestSandboxCreate(t *testing.T) {
 	key, err := newKey(t)
@@ -19,3 +29,470 @@ func TestSandboxCreate(t *testing.T) {
 
 	verifySandbox(t, s)
 }
+
+func TestSandboxCreateInvalidKey(t *testing.T) {
+	if _, err := NewSandbox(""); err != ErrEmptyKey {
+		t.Fatalf("Expected ErrEmptyKey, got %v", err)
+	}
+	if _, err := NewSandbox("../netns"); err == nil {
+		t.Fatal("Expected an error with a key traversing parent directories")
+	}
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a sandbox with absolute key %s: %v", key, err)
+	}
+	defer s.Destroy()
+	verifySandbox(t, s)
+}
+
+type recordingLogger []string
+
+func (l *recordingLogger) Debug(msg string, fields driverapi.Fields) {
+	*l = append(*l, fmt.Sprintf("%s %v", msg, fields["sandbox"]))
+}
+
+func TestSandboxLogger(t *testing.T) {
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	var logger recordingLogger
+	s, err := NewSandboxWithLogger(key, &logger)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"created network namespace " + key, "destroyed network namespace " + key}
+	if len(logger) != len(expected) || logger[0] != expected[0] || logger[1] != expected[1] {
+		t.Fatalf("Expected messages %q, got %q", expected, logger)
+	}
+}
+
+func TestSandboxAddRemoveInterface(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	iface := newInterface(t, "eth0", "192.168.1.100/24")
+	if err := s.AddInterface(iface); err != nil {
+		t.Fatalf("Failed to add interface to the sandbox: %v", err)
+	}
+	if !linkExists(t, s, "eth0") {
+		t.Fatal("Interface eth0 was not found in the sandbox")
+	}
+
+	if err := s.RemoveInterface(iface); err != nil {
+		t.Fatalf("Failed to remove interface from the sandbox: %v", err)
+	}
+	if linkExists(t, s, "eth0") {
+		t.Fatal("Interface eth0 is still present in the sandbox")
+	}
+	if l := len(s.Interfaces()); l != 0 {
+		t.Fatalf("Expected no interfaces in the sandbox, got %d", l)
+	}
+
+	// Removing the interface again must be a no-op.
+	if err := s.RemoveInterface(iface); err != nil {
+		t.Fatalf("Failed to remove an already removed interface: %v", err)
+	}
+}
+
+func TestSandboxSetGateways(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	iface := newInterface(t, "eth0", "192.168.1.100/24")
+	if err := s.AddInterface(iface); err != nil {
+		t.Fatalf("Failed to add interface to the sandbox: %v", err)
+	}
+
+	if err := s.SetGatewayIPv6("2001:db8::1"); err == nil {
+		t.Fatal("Expected setting an IPv6 gateway without IPv6 address to fail")
+	}
+
+	if err := s.SetGateway("192.168.1.1"); err != nil {
+		t.Fatalf("Failed to set the gateway: %v", err)
+	}
+	if gw := defaultRoute(t, s, netlink.FAMILY_V4); gw.String() != "192.168.1.1" {
+		t.Fatalf("Expected default gateway 192.168.1.1 in the sandbox, got %v", gw)
+	}
+
+	iface = newInterface(t, "eth1", "192.168.2.100/24")
+	iface.AddressIPv6 = "2001:db8::100/64"
+	if err := s.AddInterface(iface); err != nil {
+		t.Fatalf("Failed to add interface to the sandbox: %v", err)
+	}
+
+	if err := s.SetGatewayIPv6("2001:db8::1"); err != nil {
+		t.Fatalf("Failed to set the IPv6 gateway: %v", err)
+	}
+	if gw := defaultRoute(t, s, netlink.FAMILY_V6); gw.String() != "2001:db8::1" {
+		t.Fatalf("Expected default IPv6 gateway 2001:db8::1 in the sandbox, got %v", gw)
+	}
+}
+
+func TestSandboxAddStaticRoute(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	if err := s.AddInterface(newInterface(t, "eth0", "192.168.1.100/24")); err != nil {
+		t.Fatalf("Failed to add interface to the sandbox: %v", err)
+	}
+
+	_, dst, _ := net.ParseCIDR("10.10.0.0/24")
+	if err := s.AddStaticRoute(dst, net.ParseIP("192.168.10.1"), "eth0"); err == nil {
+		t.Fatal("Expected adding a route through an unreachable next hop to fail")
+	}
+
+	if err := s.AddStaticRoute(dst, net.ParseIP("192.168.1.1"), "eth0"); err != nil {
+		t.Fatalf("Failed to add static route: %v", err)
+	}
+
+	var found bool
+	err = s.(*networkNamespace).invoke(func() error {
+		routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		for _, r := range routes {
+			if r.Dst != nil && r.Dst.String() == dst.String() && r.Gw.String() == "192.168.1.1" {
+				found = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to list routes in sandbox: %v", err)
+	}
+	if !found {
+		t.Fatalf("Route to %s was not found in the sandbox", dst)
+	}
+}
+
+func TestSandboxDestroy(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	iface := newInterface(t, "eth0", "192.168.1.100/24")
+	if err := s.AddInterface(iface); err != nil {
+		t.Fatalf("Failed to add interface to the sandbox: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Destroy(); err != nil {
+			t.Fatalf("Failed to destroy the sandbox: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Fatalf("Expected sandbox key %s to be removed, got %v", key, err)
+	}
+
+	s, err = NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to re-create the sandbox: %v", err)
+	}
+	verifySandbox(t, s)
+
+	if err := s.Destroy(); err != nil {
+		t.Fatalf("Failed to destroy the sandbox: %v", err)
+	}
+}
+
+func TestSandboxInfo(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	if err := s.AddInterface(newInterface(t, "eth0", "192.168.1.100/24")); err != nil {
+		t.Fatalf("Failed to add interface to the sandbox: %v", err)
+	}
+	if err := s.SetGateway("192.168.1.1"); err != nil {
+		t.Fatalf("Failed to set the gateway: %v", err)
+	}
+	_, dst, _ := net.ParseCIDR("10.10.0.0/24")
+	if err := s.AddStaticRoute(dst, net.ParseIP("192.168.1.254"), "eth0"); err != nil {
+		t.Fatalf("Failed to add static route: %v", err)
+	}
+
+	// Add an interface behind the sandbox back.
+	err = s.(*networkNamespace).invoke(func() error {
+		return netlink.LinkAdd(&netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: "veth0"},
+			PeerName:  "veth1"})
+	})
+	if err != nil {
+		t.Fatalf("Failed to add interfaces to the sandbox: %v", err)
+	}
+
+	info, err := s.Info()
+	if err != nil {
+		t.Fatalf("Failed to get sandbox info: %v", err)
+	}
+
+	names := make(map[string]*InterfaceInfo)
+	for _, i := range info.Interfaces {
+		names[i.Name] = i
+	}
+	if len(names) != 3 || names["eth0"] == nil || names["veth0"] == nil || names["veth1"] == nil {
+		t.Fatalf("Expected interfaces eth0, veth0 and veth1, got %v", names)
+	}
+
+	var found bool
+	for _, addr := range names["eth0"].Addresses {
+		if addr.String() == "192.168.1.100/24" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Address 192.168.1.100/24 not found on eth0: %v", names["eth0"].Addresses)
+	}
+
+	if info.Gateway.String() != "192.168.1.1" {
+		t.Fatalf("Expected gateway 192.168.1.1, got %v", info.Gateway)
+	}
+
+	if len(info.StaticRoutes) != 1 || info.StaticRoutes[0].Destination.String() != dst.String() ||
+		info.StaticRoutes[0].NextHop.String() != "192.168.1.254" || info.StaticRoutes[0].Interface != "eth0" {
+		t.Fatalf("Unexpected static routes %v", info.StaticRoutes)
+	}
+}
+
+func TestSandboxAddInterfaceOrdering(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	for _, iface := range []*driverapi.Interface{
+		newInterface(t, "eth0", "192.168.1.100/24"),
+		newInterface(t, "eth1", "192.168.2.100/24"),
+	} {
+		if err := s.AddInterface(iface); err != nil {
+			t.Fatalf("Failed to add interface %s to the sandbox: %v", iface.DstName, err)
+		}
+	}
+	for _, name := range []string{"eth0", "eth1"} {
+		if !linkExists(t, s, name) {
+			t.Fatalf("Interface %s was not found in the sandbox", name)
+		}
+	}
+
+	// A second interface wanting eth0 must be rejected and left in the
+	// host namespace.
+	dup := newInterface(t, "eth0", "192.168.3.100/24")
+	if err := s.AddInterface(dup); err == nil {
+		t.Fatal("Expected an error adding a second eth0 to the sandbox")
+	}
+	if _, err := netlink.LinkByName(dup.SrcName); err != nil {
+		t.Fatalf("Rejected interface %s is gone from the host namespace: %v", dup.SrcName, err)
+	}
+
+	// Without a DstName the next free index is used.
+	next := newInterface(t, "", "192.168.4.100/24")
+	if err := s.AddInterface(next); err != nil {
+		t.Fatalf("Failed to add unnamed interface to the sandbox: %v", err)
+	}
+	if next.DstName != "eth2" {
+		t.Fatalf("Expected unnamed interface to be named eth2, got %q", next.DstName)
+	}
+	if !linkExists(t, s, "eth2") {
+		t.Fatal("Interface eth2 was not found in the sandbox")
+	}
+
+	ifaces := s.Interfaces()
+	if len(ifaces) != 3 {
+		t.Fatalf("Expected 3 interfaces in the sandbox, got %d", len(ifaces))
+	}
+	for idx, iface := range ifaces {
+		if want := fmt.Sprintf("eth%d", idx); iface.DstName != want {
+			t.Fatalf("Expected interface %d to be %s, got %s", idx, want, iface.DstName)
+		}
+	}
+}
+
+func TestSandboxAddInterfaceCustomName(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	iface := newInterface(t, "mynet0", "192.168.1.100/24")
+	if err := s.AddInterface(iface); err != nil {
+		t.Fatalf("Failed to add interface mynet0 to the sandbox: %v", err)
+	}
+	if !linkExists(t, s, "mynet0") {
+		t.Fatal("Interface mynet0 was not found in the sandbox")
+	}
+	if linkExists(t, s, "eth0") {
+		t.Fatal("Interface mynet0 was renamed to eth0")
+	}
+
+	// The loopback of the sandbox wasn't added through AddInterface, but
+	// its name is taken all the same.
+	lo := newInterface(t, "lo", "192.168.2.100/24")
+	if err := s.AddInterface(lo); err == nil {
+		t.Fatal("Expected an error naming an interface after the sandbox loopback")
+	}
+	if _, err := netlink.LinkByName(lo.SrcName); err != nil {
+		t.Fatalf("Rejected interface %s is gone from the host namespace: %v", lo.SrcName, err)
+	}
+}
+
+func TestSandboxSetDNS(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	servers := []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("2001:4860:4860::8888")}
+	if err := s.SetDNS(servers, []string{"example.com", "corp.example.com"}, []string{"ndots:2"}); err != nil {
+		t.Fatalf("Failed to set the sandbox DNS: %v", err)
+	}
+	// Setting it again replaces the previous content.
+	if err := s.SetDNS(servers[:1], []string{"example.com"}, nil); err != nil {
+		t.Fatalf("Failed to set the sandbox DNS: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(key + ".resolv.conf")
+	if err != nil {
+		t.Fatalf("Failed to read the sandbox resolv.conf: %v", err)
+	}
+	if expected := "nameserver 8.8.8.8\nsearch example.com\n"; string(content) != expected {
+		t.Fatalf("Expected resolv.conf %q, got %q", expected, content)
+	}
+
+	if err := s.SetDNS(servers, []string{"bad domain"}, nil); err == nil {
+		t.Fatal("Expected an error for a search domain containing a space")
+	}
+
+	if err := s.Destroy(); err != nil {
+		t.Fatalf("Failed to destroy the sandbox: %v", err)
+	}
+	if _, err := os.Stat(key + ".resolv.conf"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the sandbox resolv.conf to be removed, got %v", err)
+	}
+}
+
+func TestSandboxSetSysctl(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+
+	const rpFilter = "/proc/sys/net/ipv4/conf/all/rp_filter"
+	hostValue, err := ioutil.ReadFile(rpFilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := "2"
+	if string(hostValue) == "2\n" {
+		value = "1"
+	}
+
+	if err := s.SetSysctl("net.ipv4.conf.all.rp_filter", value); err != nil {
+		t.Fatalf("Failed to set rp_filter: %v", err)
+	}
+
+	var sandboxValue []byte
+	if err := s.(*networkNamespace).invoke(func() (err error) {
+		sandboxValue, err = ioutil.ReadFile(rpFilter)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if string(sandboxValue) != value+"\n" {
+		t.Fatalf("Expected rp_filter %s in the sandbox, got %q", value, sandboxValue)
+	}
+
+	if current, err := ioutil.ReadFile(rpFilter); err != nil || string(current) != string(hostValue) {
+		t.Fatalf("Expected the rp_filter of the host to be left alone, got %q: %v", current, err)
+	}
+
+	for _, key := range []string{"kernel.hostname", "net", "net..ipv4", "net.ipv4.conf.all/../../../kernel.hostname"} {
+		if err := s.SetSysctl(key, "1"); err == nil {
+			t.Fatalf("Expected setting sysctl %q to fail", key)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+)
+
+const (
+	networkKeyPrefix  = "network/"
+	endpointKeyPrefix = "endpoint/"
+)
+
+// networkRecord is the persisted form of a network.
+type networkRecord struct {
+	ID     driverapi.UUID
+	Name   string
+	Type   string
+	Labels map[string]string
+
+	MultipleEndpointsPerSandbox bool
+}
+
+// endpointRecord is the persisted form of an endpoint.
+type endpointRecord struct {
+	ID                driverapi.UUID
+	Network           driverapi.UUID
+	Name              string
+	SandboxInfo       *driverapi.SandboxInfo
+	SandboxKeys       []string
+	JoinedKeys        []string
+	MultipleSandboxes bool
+	Labels            map[string]string
+	DNSNames          []string
+}
+
+// OptionDataStore makes the controller persist its networks and endpoints to
+// store, and restore the ones found there when it gets created.
+func OptionDataStore(store datastore.DataStore) Option {
+	return func(c *controller) {
+		c.store = store
+	}
+}
+
+func networkKey(id driverapi.UUID) string {
+	return networkKeyPrefix + string(id)
+}
+
+func endpointKey(nid, eid driverapi.UUID) string {
+	return fmt.Sprintf("%s%s/%s", endpointKeyPrefix, nid, eid)
+}
+
+func (c *controller) storeNetwork(n *network) error {
+	if c.store == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(&networkRecord{
+		ID:                          n.id,
+		Name:                        n.Name(),
+		Type:                        n.networkType,
+		Labels:                      n.labels,
+		MultipleEndpointsPerSandbox: n.multipleEndpointsPerSandbox,
+	})
+	if err != nil {
+		return err
+	}
+	return c.store.Put(networkKey(n.id), value)
+}
+
+func (c *controller) deleteStoredNetwork(n *network) error {
+	if c.store == nil {
+		return nil
+	}
+	return c.store.Delete(networkKey(n.id))
+}
+
+func (c *controller) storeEndpoint(ep *endpoint) error {
+	if c.store == nil {
+		return nil
+	}
+
+	ep.Lock()
+	record := &endpointRecord{
+		ID:                ep.id,
+		Network:           ep.network.id,
+		Name:              ep.name,
+		SandboxInfo:       ep.sandboxInfo,
+		MultipleSandboxes: ep.multipleSandboxes,
+		Labels:            ep.labels,
+		DNSNames:          ep.dnsNames,
+	}
+	for key := range ep.sandboxKeys {
+		record.SandboxKeys = append(record.SandboxKeys, key)
+	}
+	for key := range ep.joinedKeys {
+		record.JoinedKeys = append(record.JoinedKeys, key)
+	}
+	ep.Unlock()
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return c.store.Put(endpointKey(ep.network.id, ep.id), value)
+}
+
+func (c *controller) deleteStoredEndpoint(ep *endpoint) error {
+	if c.store == nil {
+		return nil
+	}
+	return c.store.Delete(endpointKey(ep.network.id, ep.id))
+}
+
+// restore loads the networks and endpoints persisted in the store. Networks
+// of an unknown type are skipped, and endpoints whose network is gone are
+// removed from the store. The drivers persisting state of their own then
+// restore theirs.
+func (c *controller) restore() error {
+	kvs, err := c.store.List(networkKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range kvs {
+		var record networkRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			log.Warnf("Skipping invalid network record %s: %v", key, err)
+			continue
+		}
+		if _, ok := c.drivers[record.Type]; !ok {
+			log.Warnf("Skipping network %s id %s of unknown type %q", record.Name, record.ID, record.Type)
+			continue
+		}
+
+		c.networks[record.ID] = &network{
+			ctrlr:       c,
+			name:        record.Name,
+			networkType: record.Type,
+			id:          record.ID,
+			endpoints:   make(map[driverapi.UUID]*endpoint),
+			labels:      record.Labels,
+
+			sandboxKeys:                 make(map[string]driverapi.UUID),
+			multipleEndpointsPerSandbox: record.MultipleEndpointsPerSandbox,
+		}
+	}
+
+	kvs, err = c.store.List(endpointKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range kvs {
+		var record endpointRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			log.Warnf("Skipping invalid endpoint record %s: %v", key, err)
+			continue
+		}
+
+		n, ok := c.networks[record.Network]
+		if !ok {
+			log.Warnf("Removing endpoint %s id %s of missing network %s", record.Name, record.ID, record.Network)
+			if err := c.store.Delete(key); err != nil {
+				log.Warnf("Failed to remove endpoint record %s: %v", key, err)
+			}
+			continue
+		}
+
+		ep := &endpoint{
+			name:              record.Name,
+			id:                record.ID,
+			network:           n,
+			sandboxInfo:       record.SandboxInfo,
+			sandboxKeys:       make(map[string]struct{}),
+			joinedKeys:        make(map[string]struct{}),
+			multipleSandboxes: record.MultipleSandboxes,
+			labels:            record.Labels,
+			dnsNames:          record.DNSNames,
+		}
+		for _, key := range record.SandboxKeys {
+			ep.sandboxKeys[key] = struct{}{}
+			if err := n.claimSandboxKey(key, ep.id); err != nil {
+				log.Warnf("Restored endpoint %s id %s: %v", ep.name, ep.id, err)
+			}
+		}
+		for _, key := range record.JoinedKeys {
+			ep.joinedKeys[key] = struct{}{}
+		}
+		n.endpoints[ep.id] = ep
+	}
+
+	for _, n := range c.networks {
+		su, ok := c.drivers[n.networkType].(driverapi.StoreUser)
+		if !ok {
+			continue
+		}
+		var sinfos []*driverapi.SandboxInfo
+		for _, ep := range n.endpoints {
+			if ep.sandboxInfo != nil {
+				sinfos = append(sinfos, ep.sandboxInfo)
+			}
+		}
+		if err := su.RestoreNetwork(n.id, sinfos); err != nil {
+			log.Warnf("Failed to restore the driver state of network %s id %s: %v", n.name, n.id, err)
+		}
+	}
+
+	return nil
+}
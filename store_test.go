@@ -0,0 +1,154 @@
+package libnetwork
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"golang.org/x/net/context"
+)
+
+func TestDataStoreRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := datastore.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := newFakeController(OptionDataStore(store))
+	network1, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil, NetworkOptionLabels(map[string]string{"env": "prod"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	network2, err := c.NewNetwork(context.Background(), fakeNetworkType, "network2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, _, err := network1.CreateEndpoint(context.Background(), "ep1", "", nil, EndpointOptionLabels(map[string]string{"role": "web"}), EndpointOptionDNSNames("web", "www"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ep.Join("sbox1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := network2.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh controller built from the same store sees the same state.
+	c, _ = newFakeController(OptionDataStore(store))
+	if l := len(c.Networks()); l != 1 {
+		t.Fatalf("Expected exactly one restored network, got %d", l)
+	}
+
+	n, err := c.NetworkByName("network1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID() != network1.ID() || n.Type() != fakeNetworkType {
+		t.Fatalf("Restored network %s of type %s doesn't match %s", n.ID(), n.Type(), network1.ID())
+	}
+	if n.Labels()["env"] != "prod" {
+		t.Fatalf("Restored network lost its labels: %v", n.Labels())
+	}
+
+	restored, err := n.EndpointByName("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Labels()["role"] != "web" {
+		t.Fatalf("Restored endpoint lost its labels: %v", restored.Labels())
+	}
+	if names := restored.DNSNames(); len(names) != 2 || names[0] != "web" || names[1] != "www" {
+		t.Fatalf("Restored endpoint lost its DNS names: %v", names)
+	}
+	if err := restored.Leave("sbox1"); err != nil {
+		t.Fatalf("Restored endpoint lost its sandbox: %v", err)
+	}
+}
+
+func TestDataStoreRestoreReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := datastore.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An endpoint left behind by a network which is gone.
+	if err := store.Put(endpointKey("missing", "ep"), []byte(`{"ID":"ep","Network":"missing","Name":"ep1"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := newFakeController(OptionDataStore(store))
+	if l := len(c.Networks()); l != 0 {
+		t.Fatalf("Expected no restored network, got %d", l)
+	}
+	if _, err := store.Get(endpointKey("missing", "ep")); err != datastore.ErrKeyNotFound {
+		t.Fatalf("Expected the orphan endpoint to be removed from the store, got %v", err)
+	}
+}
+
+// storeUserDriver is a fake driver persisting state of its own.
+type storeUserDriver struct {
+	fakeDriver
+	store    datastore.DataStore
+	restored map[driverapi.UUID][]*driverapi.SandboxInfo
+}
+
+func (d *storeUserDriver) SetStore(store datastore.DataStore) {
+	d.store = store
+}
+
+func (d *storeUserDriver) RestoreNetwork(nid driverapi.UUID, endpoints []*driverapi.SandboxInfo) error {
+	d.restored[nid] = endpoints
+	return nil
+}
+
+func TestDataStoreRestoreDriverState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := datastore.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &storeUserDriver{restored: make(map[driverapi.UUID][]*driverapi.SandboxInfo)}
+	d.interfaces = []driverapi.Interface{{SrcName: "veth0", Address: "10.0.0.2/24"}}
+	c := New(OptionDataStore(store), OptionDriver(fakeNetworkType, d))
+	if d.store != store {
+		t.Fatal("Expected the driver to get the datastore")
+	}
+	network, err := c.NewNetwork(context.Background(), fakeNetworkType, "network1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := network.CreateEndpoint(context.Background(), "ep1", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	d = &storeUserDriver{restored: make(map[driverapi.UUID][]*driverapi.SandboxInfo)}
+	New(OptionDataStore(store), OptionDriver(fakeNetworkType, d))
+	sinfos, ok := d.restored[driverapi.UUID(network.ID())]
+	if !ok {
+		t.Fatal("Expected the driver to restore the network")
+	}
+	if len(sinfos) != 1 || sinfos[0].Interfaces[0].Address != "10.0.0.2/24" {
+		t.Fatalf("Expected the restored endpoint address, got %+v", sinfos)
+	}
+}